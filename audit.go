@@ -0,0 +1,77 @@
+package chopshop
+
+import (
+	"time"
+)
+
+// Actions recorded in audit events.
+const (
+	AuditLogin       = "login"
+	AuditLogout      = "logout"
+	AuditAddRight    = "add_right"
+	AuditRemoveRight = "remove_right"
+	AuditAccess      = "access"
+)
+
+// Results recorded in audit events.
+const (
+	AuditSuccess = "success"
+	AuditDenied  = "denied"
+)
+
+// AuditEvent records a security relevant action taken within a request.
+type AuditEvent struct {
+	Time      time.Time
+	RequestID string
+	SessionID string
+	Path      string
+
+	// ActorID and Actor identify the principal taking the action, and are
+	// empty for anonymous sessions.
+	ActorID uint64
+	Actor   string
+
+	// Action is one of the Audit action constants, and Target its subject:
+	// the username for logins and logouts, or the right concerned.
+	Action string
+	Target string
+	Result string
+}
+
+// AuditLogger records audit events, such as to a log or a database.
+type AuditLogger interface {
+	Record(event AuditEvent)
+}
+
+// AuditLoggerFunc adapts a function for use as an AuditLogger.
+type AuditLoggerFunc func(event AuditEvent)
+
+// Record implements AuditLogger.
+func (fn AuditLoggerFunc) Record(event AuditEvent) {
+	fn(event)
+}
+
+// audit records an event with the framework's AuditLogger, if any.
+func (ctx *RequestContext) audit(action, target, result string) {
+	logger := ctx.framework.AuditLogger
+	if logger == nil {
+		return
+	}
+
+	event := AuditEvent{
+		Time:      ctx.framework.now(),
+		RequestID: ctx.RequestID(),
+		SessionID: ctx.SessionID(),
+		Path:      ctx.Request.URL.Path,
+		Action:    action,
+		Target:    target,
+		Result:    result,
+	}
+
+	if ctx.IsAuthenticated() {
+		event.ActorID = ctx.UserID()
+		event.Actor = ctx.Username()
+	}
+
+	logger.Record(event)
+}
@@ -0,0 +1,87 @@
+package chopshop
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAudit(t *testing.T) {
+	access := func(right string) func(*RequestContext) {
+		return func(ctx *RequestContext) {
+			RightCheckMiddleware(right)(func(*RequestContext) Response {
+				return BlankResponse(http.StatusOK)
+			})(ctx).ServeHTTP(httptest.NewRecorder(), ctx.Request)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		actions []func(*RequestContext)
+		want    []string
+	}{
+		{
+			name: "login",
+			actions: []func(*RequestContext){
+				func(ctx *RequestContext) { ctx.SetPrincipal("bob", 3, []string{"a"}) },
+			},
+			want: []string{"3 login bob success"},
+		},
+		{
+			name:    "anonymous access",
+			actions: []func(*RequestContext){access("a")},
+			want:    []string{"0 access a denied"},
+		},
+		{
+			name: "access",
+			actions: []func(*RequestContext){
+				func(ctx *RequestContext) { ctx.SetPrincipal("bob", 3, []string{"a"}) },
+				access("a"),
+				access("admin"),
+			},
+			want: []string{"3 login bob success", "3 access a success", "3 access admin denied"},
+		},
+		{
+			name: "rights",
+			actions: []func(*RequestContext){
+				func(ctx *RequestContext) { ctx.SetPrincipal("bob", 3, nil) },
+				func(ctx *RequestContext) { ctx.AddRight("a") },
+				func(ctx *RequestContext) { ctx.AddTemporaryRight("b", time.Minute) },
+				func(ctx *RequestContext) { ctx.RemoveRight("a") },
+				func(ctx *RequestContext) { ctx.RemoveRight("c") },
+			},
+			want: []string{"3 login bob success", "3 add_right a success", "3 add_right b success", "3 remove_right a success"},
+		},
+		{
+			name: "logout once",
+			actions: []func(*RequestContext){
+				func(ctx *RequestContext) { ctx.SetPrincipal("bob", 3, nil) },
+				func(ctx *RequestContext) { ctx.DestroySession() },
+				func(ctx *RequestContext) { ctx.DestroyPrincipal() },
+			},
+			want: []string{"3 login bob success", "3 logout bob success"},
+		},
+	}
+
+	for _, tt := range tests {
+		f, ctx, _ := newTestContext(t)
+		var events []string
+		f.AuditLogger = AuditLoggerFunc(func(e AuditEvent) {
+			if e.RequestID != ctx.RequestID() || e.SessionID != ctx.SessionID() || e.Path != "/" {
+				t.Errorf("%s: event not identified: %+v", tt.name, e)
+			}
+			events = append(events, fmt.Sprintf("%d %s %s %s", e.ActorID, e.Action, e.Target, e.Result))
+		})
+
+		for _, action := range tt.actions {
+			action(ctx)
+		}
+
+		if !reflect.DeepEqual(events, tt.want) {
+			t.Errorf("%s: events %q, want %q", tt.name, events, tt.want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+// Package auth provides optional primitives for authenticating the users of an
+// application built on chopshop.
+package auth
+
+import (
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DefaultPasswordCost is the bcrypt cost used by HashPassword.
+const DefaultPasswordCost = 12
+
+// HashPassword returns a bcrypt hash of the password, suitable for storage,
+// using DefaultPasswordCost.
+func HashPassword(plain string) (string, error) {
+	return HashPasswordCost(plain, DefaultPasswordCost)
+}
+
+// HashPasswordCost returns a bcrypt hash of the password using the given cost.
+func HashPasswordCost(plain string, cost int) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plain), cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// CheckPassword returns true if the password matches a hash produced by
+// HashPassword. The comparison takes constant time with respect to the
+// content of the password.
+func CheckPassword(hash, plain string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(plain)) == nil
+}
+
+// PasswordNeedsRehash returns true if the hash was produced with a cost other
+// than DefaultPasswordCost, or cannot be read, so that it may be replaced
+// after the user next signs in.
+func PasswordNeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != DefaultPasswordCost
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := HashPasswordCost("hunter2", bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		plain string
+		ok    bool
+	}{
+		{"hunter2", true},
+		{"hunter3", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if ok := CheckPassword(hash, tt.plain); ok != tt.ok {
+			t.Errorf("CheckPassword(%q) = %v, want %v", tt.plain, ok, tt.ok)
+		}
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	current, _ := HashPassword("hunter2")
+	weak, _ := HashPasswordCost("hunter2", bcrypt.MinCost)
+
+	tests := []struct {
+		name  string
+		hash  string
+		needs bool
+	}{
+		{"current cost", current, false},
+		{"other cost", weak, true},
+		{"unreadable", "not a hash", true},
+	}
+
+	for _, tt := range tests {
+		if needs := PasswordNeedsRehash(tt.hash); needs != tt.needs {
+			t.Errorf("%s: PasswordNeedsRehash = %v, want %v", tt.name, needs, tt.needs)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Parameters of the time-based one-time passwords (RFC 6238) produced and
+// verified by this package. They match the defaults of common authenticator
+// apps.
+const (
+	TOTPDigits = 6
+	TOTPPeriod = 30 * time.Second
+
+	// DefaultTOTPSkew is the number of periods either side of the current
+	// one within which a code is accepted, allowing for clock drift and the
+	// time taken to enter the code.
+	DefaultTOTPSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a random base32 encoded secret for use with
+// TOTPCode and VerifyTOTP.
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPProvisioningURI returns the otpauth:// URI, typically presented as a QR
+// code, with which an authenticator app is enrolled.
+func TOTPProvisioningURI(secret, issuer, account string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(TOTPDigits))
+	query.Set("period", fmt.Sprint(int(TOTPPeriod.Seconds())))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+
+	return u.String()
+}
+
+// TOTPCode returns the code for the secret at time t.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return totpCode(key, totpStep(t)), nil
+}
+
+// VerifyTOTP returns true if code is valid for the secret at time t, or within
+// skew periods of it.
+func VerifyTOTP(secret, code string, t time.Time, skew int) bool {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil || len(code) != TOTPDigits {
+		return false
+	}
+
+	step := totpStep(t)
+	valid := false
+	for i := -int64(skew); i <= int64(skew); i++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step+i)), []byte(code)) == 1 {
+			valid = true
+		}
+	}
+
+	return valid
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.TrimRight(strings.ToUpper(strings.Replace(secret, " ", "", -1)), "=")
+	return totpEncoding.DecodeString(secret)
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod.Seconds())
+}
+
+// totpCode computes the HOTP value (RFC 4226) of the key for a counter.
+func totpCode(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod)
+}
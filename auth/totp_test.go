@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 secret of the RFC 6238 test vectors.
+var rfcSecret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCode(t *testing.T) {
+	// The RFC's eight digit codes, truncated to TOTPDigits.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		code, err := TOTPCode(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil || code != tt.code {
+			t.Errorf("TOTPCode at %d = %q, %v, want %q", tt.unix, code, err, tt.code)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, _ := TOTPCode(rfcSecret, now)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		at     time.Time
+		ok     bool
+	}{
+		{"current", rfcSecret, code, now, true},
+		{"within skew", rfcSecret, code, now.Add(TOTPPeriod), true},
+		{"outside skew", rfcSecret, code, now.Add(3 * TOTPPeriod), false},
+		{"lowercase spaced secret", strings.ToLower(rfcSecret[:4] + " " + rfcSecret[4:]), code, now, true},
+		{"short code", rfcSecret, code[1:], now, false},
+		{"bad secret", "!!", code, now, false},
+	}
+
+	for _, tt := range tests {
+		if ok := VerifyTOTP(tt.secret, tt.code, tt.at, DefaultTOTPSkew); ok != tt.ok {
+			t.Errorf("%s: VerifyTOTP = %v, want %v", tt.name, ok, tt.ok)
+		}
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := TOTPCode(secret, time.Now()); err != nil {
+		t.Errorf("generated secret unusable: %v", err)
+	}
+
+	uri := TOTPProvisioningURI(secret, "Acme", "bob@example.com")
+	if !strings.HasPrefix(uri, "otpauth://totp/Acme:bob@example.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("provisioning URI %q", uri)
+	}
+}
@@ -0,0 +1,267 @@
+package chopshop
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxRequestBody is the maximum size of a request body, in bytes, when
+// Framework.MaxRequestBody is unset.
+const DefaultMaxRequestBody = 1 << 20
+
+// DefaultMaxJSONDepth is the maximum nesting depth of a JSON request body when
+// Framework.MaxJSONDepth is unset.
+const DefaultMaxJSONDepth = 100
+
+// Errors produced when reading request bodies.
+var (
+	ErrUnsupportedContentEncoding = errors.New("unsupported content encoding")
+	ErrRequestBodyTooLarge        = errors.New("request body too large")
+	ErrJSONTooDeep                = errors.New("JSON nested too deeply")
+	ErrJSONTooManyTokens          = errors.New("JSON contains too many tokens")
+)
+
+func (f *Framework) maxRequestBody() int64 {
+	if f.MaxRequestBody > 0 {
+		return f.MaxRequestBody
+	}
+
+	return DefaultMaxRequestBody
+}
+
+func (f *Framework) maxJSONDepth() int {
+	if f.MaxJSONDepth > 0 {
+		return f.MaxJSONDepth
+	}
+
+	return DefaultMaxJSONDepth
+}
+
+// decodeBody replaces a gzip or deflate encoded request body with a reader over
+// its decompressed content. Either way, the body is limited to the maximum
+// request body size.
+func (ctx *RequestContext) decodeBody() error {
+	r := ctx.Request
+	if r.Body == nil {
+		return nil
+	}
+
+	if _, ok := r.Body.(*decodedBody); ok {
+		return nil
+	}
+
+	var decoded io.Reader
+	var identity bool
+	var err error
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		if r.ContentLength > ctx.framework.maxRequestBody() {
+			return ErrRequestBodyTooLarge
+		}
+		decoded, identity = r.Body, true
+	case "gzip", "x-gzip":
+		decoded, err = gzip.NewReader(r.Body)
+	case "deflate":
+		decoded, err = zlib.NewReader(r.Body)
+	default:
+		return ErrUnsupportedContentEncoding
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !identity {
+		r.Header.Del("Content-Encoding")
+		r.ContentLength = -1
+	}
+
+	r.Body = &decodedBody{
+		Reader: &bodyLimitReader{r: decoded, remaining: ctx.framework.maxRequestBody()},
+		Closer: r.Body,
+	}
+	return nil
+}
+
+// CaptureBody reads and returns the whole (decompressed) request body, limited
+// to the maximum request body size, and then restores it so that it may be
+// read again by the handler.
+func (ctx *RequestContext) CaptureBody() ([]byte, error) {
+	r := ctx.Request
+	if ctx.capturedBody == nil {
+		if err := ctx.decodeBody(); err != nil {
+			return nil, err
+		}
+
+		if r.Body == nil {
+			return nil, nil
+		}
+
+		limited := &bodyLimitReader{r: r.Body, remaining: ctx.framework.maxRequestBody()}
+		body, err := ioutil.ReadAll(limited)
+		if err != nil {
+			return nil, err
+		}
+
+		ctx.capturedBody = body
+	}
+
+	var closer io.Closer = r.Body
+	if body, ok := closer.(*decodedBody); ok {
+		closer = body.Closer
+	}
+
+	r.Body = &decodedBody{Reader: bytes.NewReader(ctx.capturedBody), Closer: closer}
+	return ctx.capturedBody, nil
+}
+
+// FormValue returns the first value of the named form field, as
+// http.Request.FormValue does, after decoding any content encoding.
+func (ctx *RequestContext) FormValue(key string) string {
+	if err := ctx.decodeBody(); err != nil {
+		return ""
+	}
+
+	return ctx.Request.FormValue(key)
+}
+
+// bodyErrorResponse returns the response for a failure to read the request
+// body.
+func (ctx *RequestContext) bodyErrorResponse(err error) Response {
+	switch err {
+	case ErrRequestBodyTooLarge:
+		return ctx.PayloadTooLargeResponse()
+	case ErrUnsupportedContentEncoding, ErrUnsupportedMediaType:
+		return ctx.UnsupportedMediaTypeResponse()
+	}
+
+	return ctx.CustomErrorResponse(err, "The request body could not be read.", http.StatusBadRequest)
+}
+
+type decodedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// bodyLimitReader reads at most remaining bytes, failing with
+// ErrRequestBodyTooLarge if the underlying reader holds more.
+type bodyLimitReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *bodyLimitReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		var probe [1]byte
+		n, err := l.r.Read(probe[:])
+		if n > 0 {
+			return 0, ErrRequestBodyTooLarge
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// jsonLimitReader fails with ErrJSONTooDeep or ErrJSONTooManyTokens once the
+// JSON passing through it exceeds the limits of the framework, so that it is
+// rejected before the decoder does the work of parsing it.
+type jsonLimitReader struct {
+	r       io.Reader
+	scanner jsonLimitScanner
+}
+
+func (ctx *RequestContext) newJSONLimitReader(r io.Reader) *jsonLimitReader {
+	return &jsonLimitReader{r: r, scanner: ctx.framework.newJSONLimitScanner()}
+}
+
+func (l *jsonLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	if scanErr := l.scanner.scan(p[:n]); scanErr != nil {
+		return 0, scanErr
+	}
+
+	return n, err
+}
+
+// checkJSONLimits returns an error if data exceeds the JSON limits of the
+// framework.
+func (f *Framework) checkJSONLimits(data []byte) error {
+	scanner := f.newJSONLimitScanner()
+	return scanner.scan(data)
+}
+
+// jsonLimitScanner counts the nesting depth and the tokens of a JSON document
+// presented to it in pieces. It does not otherwise validate the document.
+type jsonLimitScanner struct {
+	maxDepth  int
+	maxTokens int
+
+	depth    int
+	tokens   int
+	inString bool
+	escaped  bool
+	inScalar bool
+}
+
+func (f *Framework) newJSONLimitScanner() jsonLimitScanner {
+	return jsonLimitScanner{maxDepth: f.maxJSONDepth(), maxTokens: f.MaxJSONTokens}
+}
+
+func (s *jsonLimitScanner) scan(data []byte) error {
+	for _, c := range data {
+		if s.inString {
+			switch {
+			case s.escaped:
+				s.escaped = false
+			case c == '\\':
+				s.escaped = true
+			case c == '"':
+				s.inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			s.inString, s.inScalar = true, false
+			s.tokens++
+		case '[', '{':
+			s.inScalar = false
+			s.tokens++
+			s.depth++
+			if s.depth > s.maxDepth {
+				return ErrJSONTooDeep
+			}
+		case ']', '}':
+			s.inScalar = false
+			s.tokens++
+			s.depth--
+		case ',', ':', ' ', '\t', '\n', '\r':
+			s.inScalar = false
+		default:
+			if !s.inScalar {
+				s.inScalar = true
+				s.tokens++
+			}
+		}
+
+		if s.maxTokens > 0 && s.tokens > s.maxTokens {
+			return ErrJSONTooManyTokens
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package chopshop
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// compress encodes data with the given content encoding.
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	default:
+		return data
+	}
+
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	return buf.Bytes()
+}
+
+func TestReadJSONUnsafeEncodings(t *testing.T) {
+	large := append(append([]byte(`"`), bytes.Repeat([]byte("a"), 10<<20)...), '"')
+
+	tests := []struct {
+		name     string
+		encoding string
+		header   string
+		body     []byte
+		err      error
+	}{
+		{"identity", "", "", []byte(`"b"`), nil},
+		{"gzip", "gzip", "gzip", []byte(`"b"`), nil},
+		{"x-gzip", "gzip", "x-gzip", []byte(`"b"`), nil},
+		{"deflate", "deflate", "Deflate", []byte(`"b"`), nil},
+		{"unsupported", "", "br", []byte(`"b"`), ErrUnsupportedContentEncoding},
+		{"identity too large", "", "", large, ErrRequestBodyTooLarge},
+		{"gzip bomb", "gzip", "gzip", large, ErrRequestBodyTooLarge},
+	}
+
+	for _, tt := range tests {
+		f, ctx, _ := newTestContext(t)
+		f.MaxRequestBody = 1024
+		ctx.Request, _ = http.NewRequest("POST", "/", bytes.NewReader(compress(t, tt.encoding, tt.body)))
+		ctx.Request.Header.Set("Content-Encoding", tt.header)
+
+		var s string
+		err := ctx.ReadJSONUnsafe(&s)
+		if err != tt.err {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+
+		if err == nil && s != "b" {
+			t.Errorf("%s: decoded %q", tt.name, s)
+		}
+	}
+}
+
+func TestReadJSONUnsafeLimits(t *testing.T) {
+	deep := strings.Repeat("[", 5000) + strings.Repeat("]", 5000)
+
+	tests := []struct {
+		name      string
+		maxDepth  int
+		maxTokens int
+		body      string
+		err       error
+	}{
+		{"default depth", 0, 0, deep, ErrJSONTooDeep},
+		{"nested", 0, 0, `{"a":[1,2,"x\"]"],"b":{"c":true}}`, nil},
+		{"within depth", 3, 0, `[[[1]]]`, nil},
+		{"too deep", 3, 0, `[[[[1]]]]`, ErrJSONTooDeep},
+		{"within tokens", 0, 8, `[1,2,3,4,5,6]`, nil},
+		{"too many tokens", 0, 5, `[1,2,3,4,5,6]`, ErrJSONTooManyTokens},
+	}
+
+	for _, tt := range tests {
+		f, ctx, _ := newTestContext(t)
+		f.MaxJSONDepth, f.MaxJSONTokens = tt.maxDepth, tt.maxTokens
+		ctx.Request, _ = http.NewRequest("POST", "/", strings.NewReader(tt.body))
+
+		var v interface{}
+		if err := ctx.ReadJSONUnsafe(&v); err != tt.err {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestJSONSchemaMiddlewareLimits(t *testing.T) {
+	f, ctx, _ := newTestContext(t)
+	f.MaxJSONTokens = 5
+	ctx.Request, _ = http.NewRequest("POST", "/", strings.NewReader(`[1,2,3,4,5,6]`))
+
+	w := httptest.NewRecorder()
+	JSONSchemaMiddleware([]byte(`{}`))(func(*RequestContext) Response {
+		return BlankResponse(http.StatusOK)
+	})(ctx).ServeHTTP(w, ctx.Request)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestUnencodedBodyLimit(t *testing.T) {
+	large := `{"a":"` + strings.Repeat("x", 1000) + `"}`
+
+	tests := []struct {
+		name          string
+		body          string
+		unknownLength bool
+		unsafe        bool
+		err           error
+	}{
+		{"declared length", large, false, true, ErrRequestBodyTooLarge},
+		{"unknown length", large, true, true, ErrRequestBodyTooLarge},
+		{"ReadJSON", large, true, false, ErrRequestBodyTooLarge},
+		{"within limit", `{"a":"b"}`, true, true, nil},
+	}
+
+	for _, tt := range tests {
+		f, ctx, _ := newTestContext(t)
+		f.MaxRequestBody = 64
+		ctx.Request, _ = http.NewRequest("POST", "/", strings.NewReader(tt.body))
+		if tt.unknownLength {
+			ctx.Request.Body = ioutil.NopCloser(strings.NewReader(tt.body))
+			ctx.Request.ContentLength = -1
+		}
+
+		var v map[string]string
+		read := ctx.ReadJSON
+		if tt.unsafe {
+			read = ctx.ReadJSONUnsafe
+		}
+
+		if err := read(&v); err != tt.err {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	_, ctx, _ := newTestContext(t)
+	w := httptest.NewRecorder()
+	ctx.bodyErrorResponse(ErrRequestBodyTooLarge).ServeHTTP(w, ctx.Request)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestReadJSONContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		lax         bool
+		err         error
+	}{
+		{"application/json", false, nil},
+		{"application/json; charset=utf-8", false, nil},
+		{"Application/JSON", false, nil},
+		{"application/merge-patch+json", false, nil},
+		{"", false, nil},
+		{"text/plain", false, ErrUnsupportedMediaType},
+		{"application/x-www-form-urlencoded", false, ErrUnsupportedMediaType},
+		{"not a media type", false, ErrUnsupportedMediaType},
+		{"text/plain", true, nil},
+	}
+
+	for _, tt := range tests {
+		f, ctx, _ := newTestContext(t)
+		f.LaxJSONContentType = tt.lax
+
+		for _, unsafe := range []bool{false, true} {
+			ctx.Request, _ = http.NewRequest("POST", "/", strings.NewReader(`{"a":"b"}`))
+			ctx.Request.Header.Set("Content-Type", tt.contentType)
+
+			var v struct {
+				A string `json:"a"`
+			}
+			read := ctx.ReadJSON
+			if unsafe {
+				read = ctx.ReadJSONUnsafe
+			}
+
+			if err := read(&v); err != tt.err {
+				t.Errorf("%q (lax %t, unsafe %t): got %v, want %v", tt.contentType, tt.lax, unsafe, err, tt.err)
+			}
+
+			if tt.err == nil && v.A != "b" {
+				t.Errorf("%q: decoded %+v", tt.contentType, v)
+			}
+		}
+	}
+}
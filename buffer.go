@@ -0,0 +1,198 @@
+package chopshop
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// BufferedResponse is a Response which has been rendered into memory so that it
+// may be inspected or replayed.
+type BufferedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// BufferResponse renders a response into memory.
+func BufferResponse(response Response, r *http.Request) *BufferedResponse {
+	buf := &responseBuffer{header: make(http.Header)}
+	response.ServeHTTP(buf, r)
+
+	if buf.status == 0 {
+		buf.status = http.StatusOK
+	}
+
+	return &BufferedResponse{
+		Status: buf.status,
+		Header: buf.header,
+		Body:   buf.body.Bytes(),
+	}
+}
+
+// Successful returns true if the response has a 2xx status.
+func (b *BufferedResponse) Successful() bool {
+	return b.Status >= 200 && b.Status < 300
+}
+
+// ServeHTTP replays the buffered response. Cookies already set on the writer
+// are preserved; all other buffered headers replace existing values.
+func (b *BufferedResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	for k, v := range b.Header {
+		if k == "Set-Cookie" {
+			header[k] = append(header[k], v...)
+			continue
+		}
+
+		header[k] = append([]string(nil), v...)
+	}
+
+	w.WriteHeader(b.Status)
+	w.Write(b.Body)
+}
+
+// withoutCookies returns a copy of the response without its Set-Cookie
+// headers, for sharing with other sessions.
+func (b *BufferedResponse) withoutCookies() *BufferedResponse {
+	header := make(http.Header, len(b.Header))
+	for k, v := range b.Header {
+		header[k] = v
+	}
+	header.Del("Set-Cookie")
+
+	return &BufferedResponse{Status: b.Status, Header: header, Body: b.Body}
+}
+
+// Cancel is a no-op as there are no resources held by a BufferedResponse.
+func (b *BufferedResponse) Cancel() {}
+
+// responseBuffer is an http.ResponseWriter which writes into memory.
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
+
+// cappedBuffer is an http.ResponseWriter which writes into memory until the
+// body exceeds limit bytes (if limit is positive), at which point it writes
+// the buffered response, and all that follows, through to w.
+type cappedBuffer struct {
+	responseBuffer
+	w           http.ResponseWriter
+	limit       int64
+	passthrough bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.passthrough {
+		return b.w.Write(p)
+	}
+
+	if b.limit > 0 && int64(b.body.Len()+len(p)) > b.limit {
+		b.passthrough = true
+		b.buffered().ServeHTTP(b.w, nil)
+		return b.w.Write(p)
+	}
+
+	return b.responseBuffer.Write(p)
+}
+
+// buffered returns the response written so far.
+func (b *cappedBuffer) buffered() *BufferedResponse {
+	status := b.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	return &BufferedResponse{Status: status, Header: b.header, Body: b.body.Bytes()}
+}
+
+// transformedResponse is a Response which rewrites another once it has been
+// rendered into memory.
+type transformedResponse struct {
+	ctx       *RequestContext
+	response  Response
+	transform ResponseTransform
+	maxSize   int64
+}
+
+func (t *transformedResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	buf := &cappedBuffer{
+		responseBuffer: responseBuffer{header: make(http.Header)},
+		w:              w,
+		limit:          t.maxSize,
+	}
+	t.response.ServeHTTP(buf, r)
+
+	if buf.passthrough {
+		return
+	}
+
+	response := buf.buffered()
+	response.Header.Del("Content-Length")
+	t.transform(t.ctx, response).ServeHTTP(w, r)
+}
+
+func (t *transformedResponse) Cancel() {
+	t.response.Cancel()
+}
+
+// statusWriter is an http.ResponseWriter which records the status and size of
+// the response written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+
+	n, err := sw.ResponseWriter.Write(p)
+	sw.size += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher if the underlying writer does.
+func (sw *statusWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker if the underlying writer does.
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := sw.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+
+	return nil, nil, errors.New("chopshop: response writer does not support hijacking")
+}
@@ -0,0 +1,182 @@
+package chopshop
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ResponseCache stores buffered responses on behalf of
+// ResponseCacheMiddleware.
+type ResponseCache interface {
+	// Get returns the response stored under key along with the time at which
+	// it was stored, provided it has not expired.
+	Get(key string) (response *BufferedResponse, stored time.Time, ok bool)
+
+	// Set stores the response under key for the given duration.
+	Set(key string, response *BufferedResponse, ttl time.Duration)
+}
+
+// MemoryResponseCache is a ResponseCache held in process memory. Expired
+// entries are swept whenever the number stored has doubled since the last
+// sweep, so that the cache holds at most twice the entries live at a time.
+type MemoryResponseCache struct {
+	mu      sync.Mutex
+	clock   func() time.Time
+	entries map[string]memoryCacheEntry
+	sweepAt int
+}
+
+// minSweepEntries is the fewest entries a MemoryResponseCache sweeps at.
+const minSweepEntries = 64
+
+type memoryCacheEntry struct {
+	response *BufferedResponse
+	stored   time.Time
+	expires  time.Time
+}
+
+// NewMemoryResponseCache constructs an empty MemoryResponseCache whose entries
+// expire according to clock, such as Framework.Clock. If clock is nil the
+// system time is used.
+func NewMemoryResponseCache(clock func() time.Time) *MemoryResponseCache {
+	if clock == nil {
+		clock = time.Now
+	}
+
+	return &MemoryResponseCache{clock: clock, entries: make(map[string]memoryCacheEntry)}
+}
+
+// Get implements ResponseCache.
+func (c *MemoryResponseCache) Get(key string) (*BufferedResponse, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, time.Time{}, false
+	}
+
+	if c.clock().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, time.Time{}, false
+	}
+
+	return entry.response, entry.stored, true
+}
+
+// Set implements ResponseCache.
+func (c *MemoryResponseCache) Set(key string, response *BufferedResponse, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock()
+	if len(c.entries) >= c.sweepAt {
+		c.sweep(now)
+	}
+
+	c.entries[key] = memoryCacheEntry{
+		response: response,
+		stored:   now,
+		expires:  now.Add(ttl),
+	}
+}
+
+// sweep deletes the entries expired at now.
+func (c *MemoryResponseCache) sweep(now time.Time) {
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+
+	c.sweepAt = 2 * len(c.entries)
+	if c.sweepAt < minSweepEntries {
+		c.sweepAt = minSweepEntries
+	}
+}
+
+// frameworkMemoryCache is a MemoryResponseCache created on first use with the
+// clock of the framework serving the request, for middleware constructed
+// before the framework is known.
+type frameworkMemoryCache struct {
+	once  sync.Once
+	cache *MemoryResponseCache
+}
+
+func (c *frameworkMemoryCache) get(ctx *RequestContext) ResponseCache {
+	c.once.Do(func() {
+		c.cache = NewMemoryResponseCache(ctx.framework.now)
+	})
+
+	return c.cache
+}
+
+// ResponseCacheMiddleware constructs a middleware which caches 200 responses
+// to GET requests in memory for ttl. See ResponseCacheMiddlewareWithStore.
+func ResponseCacheMiddleware(ttl time.Duration, keyFn func(*RequestContext) string) Middleware {
+	return responseCacheMiddleware((&frameworkMemoryCache{}).get, ttl, keyFn)
+}
+
+// ResponseCacheMiddlewareWithStore constructs a middleware which caches 200
+// responses to GET requests in the given store for ttl, serving subsequent
+// requests with a matching key without invoking the handler. If keyFn is nil
+// the request URI is used. Keys are always qualified by the principal and its
+// rights so that sessions with differing access never share a body.
+func ResponseCacheMiddlewareWithStore(store ResponseCache, ttl time.Duration, keyFn func(*RequestContext) string) Middleware {
+	return responseCacheMiddleware(func(*RequestContext) ResponseCache { return store }, ttl, keyFn)
+}
+
+func responseCacheMiddleware(storeFor func(*RequestContext) ResponseCache, ttl time.Duration, keyFn func(*RequestContext) string) Middleware {
+	if keyFn == nil {
+		keyFn = func(ctx *RequestContext) string {
+			return ctx.Request.URL.RequestURI()
+		}
+	}
+
+	return func(fn ContextHandlerFunc) ContextHandlerFunc {
+		return func(ctx *RequestContext) Response {
+			if ctx.Request.Method != "GET" {
+				return fn(ctx)
+			}
+
+			store := storeFor(ctx)
+			key := ctx.principalCacheKey() + keyFn(ctx)
+			if response, stored, ok := store.Get(key); ok {
+				return withCacheHeaders(response, ctx.framework.now().Sub(stored), ttl)
+			}
+
+			response := BufferResponse(fn(ctx), ctx.Request)
+			if response.Status != http.StatusOK {
+				return response
+			}
+
+			response.Header.Del("Set-Cookie")
+			store.Set(key, response, ttl)
+			return withCacheHeaders(response, 0, ttl)
+		}
+	}
+}
+
+func withCacheHeaders(response *BufferedResponse, age, ttl time.Duration) *BufferedResponse {
+	header := make(http.Header, len(response.Header)+2)
+	for k, v := range response.Header {
+		header[k] = v
+	}
+
+	maxAge := ttl - age
+	if maxAge < 0 {
+		maxAge = 0
+	}
+
+	header.Set("Age", strconv.Itoa(int(age.Seconds())))
+	header.Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(maxAge.Seconds())))
+
+	return &BufferedResponse{
+		Status: response.Status,
+		Header: header,
+		Body:   response.Body,
+	}
+}
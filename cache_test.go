@@ -0,0 +1,111 @@
+package chopshop
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryResponseCacheExpiry(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewTestClock(start)
+	cache := NewMemoryResponseCache(clock.Now)
+	cache.Set("k", &BufferedResponse{Status: 200}, time.Minute)
+
+	tests := []struct {
+		advance time.Duration
+		found   bool
+	}{
+		{0, true},
+		{time.Minute, true},
+		{time.Second, false},
+	}
+
+	for _, tt := range tests {
+		clock.Advance(tt.advance)
+		_, stored, ok := cache.Get("k")
+		if ok != tt.found {
+			t.Fatalf("at %s: found %t, want %t", clock.Now(), ok, tt.found)
+		}
+
+		if ok && !stored.Equal(start) {
+			t.Errorf("stored at %s, want %s", stored, start)
+		}
+	}
+}
+
+func TestMemoryResponseCacheSweep(t *testing.T) {
+	clock := NewTestClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	cache := NewMemoryResponseCache(clock.Now)
+
+	tests := []struct {
+		ttl time.Duration
+		max int
+	}{
+		{time.Second, 2 * minSweepEntries},
+		{time.Hour, 2 * minSweepEntries},
+		{time.Hour, 4 * minSweepEntries},
+	}
+
+	n := 0
+	for _, tt := range tests {
+		for i := 0; i < minSweepEntries; i++ {
+			n++
+			cache.Set(fmt.Sprint(n), &BufferedResponse{Status: 200}, tt.ttl)
+		}
+
+		clock.Advance(time.Minute)
+		if len(cache.entries) > tt.max {
+			t.Errorf("after %d sets: %d entries, want at most %d", n, len(cache.entries), tt.max)
+		}
+	}
+
+	for i := 0; i < 10*minSweepEntries; i++ {
+		n++
+		cache.Set(fmt.Sprint(n), &BufferedResponse{Status: 200}, time.Second)
+		clock.Advance(time.Minute)
+	}
+
+	if len(cache.entries) > 4*minSweepEntries {
+		t.Errorf("expired entries kept: %d entries", len(cache.entries))
+	}
+}
+
+func TestResponseCacheMiddleware(t *testing.T) {
+	f, ctx, _ := newTestContext(t)
+	clock := NewTestClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	f.Clock = clock.Now
+
+	n := 0
+	h := ResponseCacheMiddleware(time.Minute, nil)(func(ctx *RequestContext) Response {
+		n++
+		return JSONResponse(n)
+	})
+
+	tests := []struct {
+		advance      time.Duration
+		user         uint64
+		body         string
+		cacheControl string
+	}{
+		{0, 0, "1\n", "private, max-age=60"},
+		{10 * time.Second, 0, "1\n", "private, max-age=50"},
+		{0, 2, "2\n", "private, max-age=60"},
+		{time.Minute, 2, "2\n", "private, max-age=0"},
+		{time.Second, 2, "3\n", "private, max-age=60"},
+	}
+
+	for i, tt := range tests {
+		clock.Advance(tt.advance)
+		if tt.user != 0 {
+			ctx.SetPrincipal("x", tt.user, nil)
+		}
+
+		w := httptest.NewRecorder()
+		h(ctx).ServeHTTP(w, ctx.Request)
+		if w.Body.String() != tt.body || w.Header().Get("Cache-Control") != tt.cacheControl {
+			t.Errorf("%d: body %q Cache-Control %q, want %q %q", i, w.Body.String(), w.Header().Get("Cache-Control"), tt.body, tt.cacheControl)
+		}
+	}
+}
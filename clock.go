@@ -0,0 +1,77 @@
+package chopshop
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/twinj/uuid"
+)
+
+// now returns the current time according to Clock.
+func (f *Framework) now() time.Time {
+	if f.Clock != nil {
+		return f.Clock()
+	}
+
+	return time.Now()
+}
+
+// newID returns a new session or request identifier according to NewID.
+func (f *Framework) newID() string {
+	if f.NewID != nil {
+		return f.NewID()
+	}
+
+	return uuid.NewV4().String()
+}
+
+// TestClock is a manually advanced clock, whose Now method may be assigned to
+// Framework.Clock so that tests observe deterministic times.
+type TestClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewTestClock constructs a TestClock reading the given time.
+func NewTestClock(now time.Time) *TestClock {
+	return &TestClock{now: now}
+}
+
+// Now returns the time of the clock.
+func (c *TestClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *TestClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// TestIDGen generates predictable identifiers, such as "id-1", "id-2", and so
+// on. Its Next method may be assigned to Framework.NewID.
+type TestIDGen struct {
+	mu     sync.Mutex
+	prefix string
+	n      int
+}
+
+// NewTestIDGen constructs a TestIDGen whose identifiers have the given prefix.
+func NewTestIDGen(prefix string) *TestIDGen {
+	return &TestIDGen{prefix: prefix}
+}
+
+// Next returns the next identifier.
+func (g *TestIDGen) Next() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.n++
+	return fmt.Sprintf("%s-%d", g.prefix, g.n)
+}
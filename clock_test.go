@@ -0,0 +1,41 @@
+package chopshop
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTestClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewTestClock(start)
+
+	f, _ := NewFramework("app", "")
+	f.Clock = clock.Now
+
+	tests := []struct {
+		advance time.Duration
+		want    time.Time
+	}{
+		{0, start},
+		{time.Second, start.Add(time.Second)},
+		{time.Hour, start.Add(time.Hour + time.Second)},
+	}
+
+	for _, tt := range tests {
+		clock.Advance(tt.advance)
+		if got := f.now(); !got.Equal(tt.want) {
+			t.Errorf("after advancing %s: got %s, want %s", tt.advance, got, tt.want)
+		}
+	}
+}
+
+func TestTestIDGen(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.NewID = NewTestIDGen("session").Next
+
+	for _, want := range []string{"session-1", "session-2", "session-3"} {
+		if got := f.newID(); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
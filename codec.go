@@ -0,0 +1,248 @@
+package chopshop
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupportedMediaType is returned by ReadBody when no decoder is registered
+// for the content type of the request, and by ReadJSON when the content type
+// is not JSON.
+var ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+// BodyDecoder decodes a request body into v.
+type BodyDecoder func(r io.Reader, v interface{}) error
+
+// XMLDecoder is a BodyDecoder for XML request bodies, which may be registered
+// with RegisterDecoder for "application/xml" and "text/xml".
+func XMLDecoder(r io.Reader, v interface{}) error {
+	return xml.NewDecoder(r).Decode(v)
+}
+
+// RegisterDecoder registers the decoder used by ReadBody for request bodies of
+// the given media type, such as "application/xml". JSON bodies are always
+// decoded as by ReadJSON.
+func (f *Framework) RegisterDecoder(mediaType string, dec BodyDecoder) {
+	if f.decoders == nil {
+		f.decoders = make(map[string]BodyDecoder)
+	}
+
+	f.decoders[strings.ToLower(mediaType)] = dec
+}
+
+// isJSONMediaType returns true for application/json and the structured syntax
+// suffix +json.
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// checkJSONContentType returns ErrUnsupportedMediaType if the request body
+// declares a Content-Type other than JSON. Bodies without a Content-Type are
+// assumed to be JSON.
+func (ctx *RequestContext) checkJSONContentType() error {
+	contentType := ctx.Request.Header.Get("Content-Type")
+	if contentType == "" || ctx.framework.LaxJSONContentType {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !isJSONMediaType(strings.ToLower(mediaType)) {
+		return ErrUnsupportedMediaType
+	}
+
+	return nil
+}
+
+// ReadBody sets fields of v, as ReadJSON does, from a request body decoded
+// according to its Content-Type by a decoder registered with RegisterDecoder.
+// Bodies without a Content-Type are read as JSON. If no decoder is registered
+// for the content type, ErrUnsupportedMediaType is returned.
+func (ctx *RequestContext) ReadBody(v interface{}) error {
+	mediaType := "application/json"
+	if contentType := ctx.Request.Header.Get("Content-Type"); contentType != "" {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return ErrUnsupportedMediaType
+		}
+		mediaType = strings.ToLower(parsed)
+	}
+
+	if isJSONMediaType(mediaType) {
+		return ctx.ReadJSON(v)
+	}
+
+	dec, ok := ctx.framework.decoders[mediaType]
+	if !ok {
+		return ErrUnsupportedMediaType
+	}
+
+	return ctx.readMerged(v, func(v interface{}) error {
+		if err := ctx.decodeBody(); err != nil {
+			return err
+		}
+
+		return dec(ctx.Request.Body, v)
+	})
+}
+
+// BodyEncoder encodes a response body. The value passed to it is the
+// rights-filtered serialization of the response, consisting only of
+// map[string]interface{}, []interface{}, string, json.Number, bool and nil.
+type BodyEncoder func(w io.Writer, v interface{}) error
+
+// XMLEncoder is a BodyEncoder producing XML, which may be registered with
+// RegisterEncoder for "application/xml". The value is enclosed in a response
+// element; object keys become element names and array items are enclosed in
+// item elements.
+func XMLEncoder(w io.Writer, v interface{}) error {
+	enc := xml.NewEncoder(w)
+	if err := encodeXMLValue(enc, "response", v); err != nil {
+		return err
+	}
+
+	return enc.Flush()
+}
+
+func encodeXMLValue(enc *xml.Encoder, name string, v interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := encodeXMLValue(enc, key, v[key]); err != nil {
+				return err
+			}
+		}
+		return enc.EncodeToken(start.End())
+	case []interface{}:
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, item := range v {
+			if err := encodeXMLValue(enc, "item", item); err != nil {
+				return err
+			}
+		}
+		return enc.EncodeToken(start.End())
+	case nil:
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		return enc.EncodeToken(start.End())
+	}
+
+	return enc.EncodeElement(v, start)
+}
+
+// RegisterEncoder registers the encoder used by Respond for clients accepting
+// the given media type, such as "application/xml". JSON is always available
+// and is used when the client expresses no preference.
+func (f *Framework) RegisterEncoder(mediaType string, enc BodyEncoder) {
+	if f.encoders == nil {
+		f.encoders = make(map[string]BodyEncoder)
+	}
+
+	f.encoders[strings.ToLower(mediaType)] = enc
+}
+
+// negotiateEncoder chooses the media type of a response from an Accept header,
+// returning a nil encoder when JSON should be used.
+func (f *Framework) negotiateEncoder(accept string) (string, BodyEncoder) {
+	type candidate struct {
+		mediaType string
+		q         float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		if q > 0 {
+			candidates = append(candidates, candidate{strings.ToLower(mediaType), q})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+
+	for _, c := range candidates {
+		if isJSONMediaType(c.mediaType) || c.mediaType == "*/*" || c.mediaType == "application/*" {
+			break
+		}
+
+		if enc, ok := f.encoders[c.mediaType]; ok {
+			return c.mediaType, enc
+		}
+	}
+
+	return "application/json", nil
+}
+
+// Respond returns a response containing v, filtered by the rights of the
+// context as JSONResponse does, in the format negotiated from the Accept
+// header among JSON and those registered with RegisterEncoder.
+func (ctx *RequestContext) Respond(v interface{}) Response {
+	mediaType, enc := ctx.framework.negotiateEncoder(ctx.Request.Header.Get("Accept"))
+	if enc == nil {
+		response := ctx.JSONResponse(v)
+		return ResponseFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept")
+			response.ServeHTTP(w, r)
+		})
+	}
+
+	out, err := ctx.safeSerialize(reflect.ValueOf(v))
+	if err != nil {
+		return ctx.ErrorResponse(err, http.StatusInternalServerError)
+	}
+
+	// reduce the serialization, which may contain marshalers, to plain values
+	data, err := json.Marshal(out)
+	if err != nil {
+		return ctx.ErrorResponse(err, http.StatusInternalServerError)
+	}
+
+	var plain interface{}
+	if err := decodeJSONNumbers(data, &plain); err != nil {
+		return ctx.ErrorResponse(err, http.StatusInternalServerError)
+	}
+
+	var body bytes.Buffer
+	if err := enc(&body, plain); err != nil {
+		return ctx.ErrorResponse(err, http.StatusInternalServerError)
+	}
+
+	return ResponseFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		w.Header().Set("Content-Type", mediaType)
+		w.Write(body.Bytes())
+	})
+}
@@ -0,0 +1,92 @@
+package chopshop
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type codecAccount struct {
+	Name  string `json:"name" xml:"name"`
+	Admin bool   `json:"admin" xml:"admin" writeRight:"root"`
+}
+
+func TestReadBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        codecAccount
+		err         error
+	}{
+		{"xml", "application/xml; charset=utf-8", `<acct><name>bob</name><admin>true</admin></acct>`, codecAccount{Name: "bob"}, nil},
+		{"json", "application/json", `{"name":"al","admin":true}`, codecAccount{Name: "al"}, nil},
+		{"json suffix", "application/vnd.api+json", `{"name":"al"}`, codecAccount{Name: "al"}, nil},
+		{"no content type", "", `{"name":"al"}`, codecAccount{Name: "al"}, nil},
+		{"unregistered", "application/msgpack", `x`, codecAccount{}, ErrUnsupportedMediaType},
+		{"malformed content type", "application/", `x`, codecAccount{}, ErrUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		f, ctx, _ := newTestContext(t, "user")
+		f.RegisterDecoder("Application/XML", XMLDecoder)
+		ctx.Request, _ = http.NewRequest("POST", "/", strings.NewReader(tt.body))
+		if tt.contentType != "" {
+			ctx.Request.Header.Set("Content-Type", tt.contentType)
+		}
+
+		var got codecAccount
+		if err := ctx.ReadBody(&got); err != tt.err {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.err)
+		}
+
+		if got != tt.want {
+			t.Errorf("%s: read %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+type codecDocument struct {
+	Name   string   `json:"name"`
+	Secret string   `json:"secret" readWrite:"admin"`
+	Tags   []string `json:"tags"`
+}
+
+func TestRespond(t *testing.T) {
+	const xmlBody = `<response><name>a</name><tags><item>x</item><item>y</item></tags></response>`
+
+	tests := []struct {
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"", "application/json", `{"name":"a","tags":["x","y"]}`},
+		{"application/xml", "application/xml", xmlBody},
+		{"application/json;q=0.9, application/xml", "application/xml", xmlBody},
+		{"application/xml;q=0.5, application/json", "application/json", `{"name":"a","tags":["x","y"]}`},
+		{"*/*, application/xml;q=0.5", "application/json", `{"name":"a","tags":["x","y"]}`},
+		{"application/xml;q=0", "application/json", `{"name":"a","tags":["x","y"]}`},
+		{"text/csv", "application/json", `{"name":"a","tags":["x","y"]}`},
+	}
+
+	for _, tt := range tests {
+		f, ctx, _ := newTestContext(t, "user")
+		f.RegisterEncoder("application/xml", XMLEncoder)
+		ctx.Request.Header.Set("Accept", tt.accept)
+
+		w := httptest.NewRecorder()
+		ctx.Respond(codecDocument{"a", "s", []string{"x", "y"}}).ServeHTTP(w, ctx.Request)
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, tt.contentType) {
+			t.Errorf("%q: Content-Type %q, want %q", tt.accept, ct, tt.contentType)
+		}
+
+		if body := strings.TrimSpace(w.Body.String()); body != tt.body {
+			t.Errorf("%q: body %q, want %q", tt.accept, body, tt.body)
+		}
+
+		if w.Header().Get("Vary") != "Accept" {
+			t.Errorf("%q: Vary %q", tt.accept, w.Header().Get("Vary"))
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package chopshop
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ContentEncoder wraps a writer with one which compresses everything written
+// to it, flushing the compressed stream on Close.
+type ContentEncoder func(w io.Writer) io.WriteCloser
+
+// GzipEncoder is the ContentEncoder for the gzip content coding.
+func GzipEncoder(w io.Writer) io.WriteCloser {
+	return gzip.NewWriter(w)
+}
+
+type contentEncoding struct {
+	name    string
+	encoder ContentEncoder
+}
+
+// RegisterContentEncoding registers an encoder for a content coding (such as
+// "br") for use by CompressionMiddleware, replacing any encoder previously
+// registered for it. Registered encodings are preferred, in the order they
+// were registered, over the built-in gzip encoding when a client accepts
+// several equally.
+func (f *Framework) RegisterContentEncoding(name string, enc ContentEncoder) {
+	name = strings.ToLower(name)
+	for i, e := range f.contentEncodings {
+		if e.name == name {
+			f.contentEncodings[i].encoder = enc
+			return
+		}
+	}
+
+	f.contentEncodings = append(f.contentEncodings, contentEncoding{name, enc})
+}
+
+// negotiateContentEncoding chooses the content coding with the highest q-value
+// in an Accept-Encoding header, returning a nil encoder if the response should
+// not be compressed.
+func (f *Framework) negotiateContentEncoding(acceptEncoding string) (string, ContentEncoder) {
+	return negotiateContentEncoding(acceptEncoding, f.contentEncodings)
+}
+
+// negotiateContentEncoding chooses between gzip and the registered encodings
+// as Framework.negotiateContentEncoding does.
+func negotiateContentEncoding(acceptEncoding string, registered []contentEncoding) (string, ContentEncoder) {
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if name == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				var err error
+				if q, err = strconv.ParseFloat(param[2:], 64); err != nil {
+					q = 0
+				}
+			}
+		}
+
+		accepted[name] = q
+	}
+
+	qFor := func(name string) float64 {
+		if q, ok := accepted[name]; ok {
+			return q
+		}
+
+		return accepted["*"]
+	}
+
+	best := contentEncoding{"gzip", GzipEncoder}
+	bestQ := qFor(best.name)
+	for i := len(registered) - 1; i >= 0; i-- {
+		if c := registered[i]; qFor(c.name) >= bestQ {
+			best, bestQ = c, qFor(c.name)
+		}
+	}
+
+	if bestQ <= 0 {
+		return "", nil
+	}
+
+	if q, ok := accepted["identity"]; ok && q > bestQ {
+		return "", nil
+	}
+
+	return best.name, best.encoder
+}
+
+// CompressionMiddleware compresses responses using the content coding most
+// preferred by the client's Accept-Encoding header, choosing between gzip and
+// any encodings registered with RegisterContentEncoding, or sends them
+// uncompressed if none is acceptable. Responses which already carry a
+// Content-Encoding, or whose Content-Type is already compressed (such as most
+// images), are sent unchanged.
+func CompressionMiddleware(fn ContextHandlerFunc) ContextHandlerFunc {
+	return func(ctx *RequestContext) Response {
+		response := fn(ctx)
+		if ctx.Request.Method == http.MethodHead {
+			return response
+		}
+
+		name, enc := ctx.framework.negotiateContentEncoding(ctx.Request.Header.Get("Accept-Encoding"))
+		return &compressedResponse{response: response, encoding: name, encoder: enc}
+	}
+}
+
+// GzipMiddleware constructs a middleware which compresses responses with gzip
+// if the client's Accept-Encoding header allows it, as CompressionMiddleware
+// does without considering other encodings.
+func GzipMiddleware() Middleware {
+	return func(fn ContextHandlerFunc) ContextHandlerFunc {
+		return func(ctx *RequestContext) Response {
+			response := fn(ctx)
+			if ctx.Request.Method == http.MethodHead {
+				return response
+			}
+
+			name, enc := negotiateContentEncoding(ctx.Request.Header.Get("Accept-Encoding"), nil)
+			return &compressedResponse{response: response, encoding: name, encoder: enc}
+		}
+	}
+}
+
+// compressedResponse is a Response which compresses another.
+type compressedResponse struct {
+	response Response
+	encoding string
+	encoder  ContentEncoder
+}
+
+func (c *compressedResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if c.encoder == nil {
+		c.response.ServeHTTP(w, r)
+		return
+	}
+
+	cw := &compressWriter{ResponseWriter: w, encoding: c.encoding, encoder: c.encoder}
+	c.response.ServeHTTP(cw, r)
+	cw.Close()
+}
+
+func (c *compressedResponse) Cancel() {
+	c.response.Cancel()
+}
+
+// compressWriter is an http.ResponseWriter which compresses the body once the
+// header has been written, unless the response has no body or is already
+// encoded or compressed.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	encoder     ContentEncoder
+	w           io.WriteCloser
+	wroteHeader bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	header := cw.Header()
+	if header.Get("Content-Encoding") == "" && !isCompressedContentType(header.Get("Content-Type")) &&
+		status != http.StatusNoContent && status != http.StatusNotModified && status >= http.StatusOK {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		cw.w = cw.encoder(cw.ResponseWriter)
+	}
+
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	cw.WriteHeader(http.StatusOK)
+	if cw.w == nil {
+		return cw.ResponseWriter.Write(p)
+	}
+
+	return cw.w.Write(p)
+}
+
+// Flush flushes any compressed data buffered by the encoder to the client.
+func (cw *compressWriter) Flush() {
+	if flusher, ok := cw.w.(interface {
+		Flush() error
+	}); ok {
+		flusher.Flush()
+	}
+
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (cw *compressWriter) Close() error {
+	if cw.w == nil {
+		return nil
+	}
+
+	return cw.w.Close()
+}
+
+// compressedContentTypes are the prefixes of the content types whose content
+// is already compressed, and so gains nothing from further compression.
+var compressedContentTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-bzip2",
+	"application/x-7z-compressed",
+}
+
+func isCompressedContentType(contentType string) bool {
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	if strings.HasPrefix(contentType, "image/svg+xml") {
+		return false
+	}
+
+	for _, prefix := range compressedContentTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,17 @@
+//go:build brotli
+// +build brotli
+
+package chopshop
+
+import (
+	"io"
+
+	"github.com/andybalholm/brotli"
+)
+
+// BrotliEncoder is the ContentEncoder for the br content coding. It is only
+// available when built with the brotli tag, and must be registered with
+// Framework.RegisterContentEncoding("br", BrotliEncoder) to be used.
+func BrotliEncoder(w io.Writer) io.WriteCloser {
+	return brotli.NewWriter(w)
+}
@@ -0,0 +1,142 @@
+package chopshop
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// upperEncoder is a ContentEncoder which "compresses" by upper-casing.
+func upperEncoder(w io.Writer) io.WriteCloser { return upperWriter{w} }
+
+type upperWriter struct{ w io.Writer }
+
+func (u upperWriter) Write(p []byte) (int, error) { return u.w.Write(bytes.ToUpper(p)) }
+func (u upperWriter) Close() error                { return nil }
+
+func TestCompressionMiddleware(t *testing.T) {
+	jsonHandler := func(ctx *RequestContext) Response { return JSONResponse("hello") }
+	imageHandler := func(ctx *RequestContext) Response {
+		return ResponseFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png"))
+		})
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		acceptEncoding string
+		handler        ContextHandlerFunc
+		encoding       string
+		body           string
+	}{
+		{"preferred by order", "GET", "br, gzip", jsonHandler, "br", "\"HELLO\"\n"},
+		{"server preference on tie", "GET", "gzip, deflate, br", jsonHandler, "br", "\"HELLO\"\n"},
+		{"quality", "GET", "br;q=0.5, gzip;q=0.9", jsonHandler, "gzip", "\"hello\"\n"},
+		{"wildcard", "GET", "*", jsonHandler, "br", "\"HELLO\"\n"},
+		{"none", "GET", "", jsonHandler, "", "\"hello\"\n"},
+		{"unsupported", "GET", "deflate", jsonHandler, "", "\"hello\"\n"},
+		{"refused", "GET", "gzip;q=0, br;q=0", jsonHandler, "", "\"hello\"\n"},
+		{"identity preferred", "GET", "identity, gzip;q=0.5", jsonHandler, "", "\"hello\"\n"},
+		{"compressed type", "GET", "gzip", imageHandler, "", "png"},
+		{"head", "HEAD", "gzip", jsonHandler, "", ""},
+	}
+
+	for _, tt := range tests {
+		f, _ := NewFramework("app", "")
+		f.SessionSecret = []byte("secret")
+		f.RegisterContentEncoding("br", upperEncoder)
+		f.Path("/").Handler(CompressionMiddleware(tt.handler))
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(tt.method, "/", nil)
+		r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		f.ServeHTTP(w, r)
+		if encoding := w.Header().Get("Content-Encoding"); encoding != tt.encoding {
+			t.Errorf("%s: Content-Encoding %q, want %q", tt.name, encoding, tt.encoding)
+		}
+
+		body := w.Body.Bytes()
+		if tt.encoding == "gzip" {
+			zr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			body, _ = ioutil.ReadAll(zr)
+		}
+
+		if tt.method != "HEAD" && string(body) != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.name, body, tt.body)
+		}
+	}
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.SessionSecret = []byte("secret")
+	f.RegisterContentEncoding("upper", upperEncoder)
+
+	body := strings.Repeat("hello ", 100)
+	f.Path("/json").Middleware(GzipMiddleware()).Handler(func(ctx *RequestContext) Response {
+		return JSONResponse(body)
+	})
+	f.Path("/stream").Middleware(GzipMiddleware()).Handler(func(ctx *RequestContext) Response {
+		return StreamResponse("text/plain", ioutil.NopCloser(strings.NewReader(body)))
+	})
+	f.Path("/image").Middleware(GzipMiddleware()).Handler(func(ctx *RequestContext) Response {
+		return ResponseFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(body))
+		})
+	})
+
+	tests := []struct {
+		name     string
+		path     string
+		accept   string
+		encoding string
+		body     string
+	}{
+		{"json", "/json", "upper, gzip", "gzip", `"` + body + `"` + "\n"},
+		{"stream", "/stream", "gzip", "gzip", body},
+		{"no accept", "/json", "", "", `"` + body + `"` + "\n"},
+		{"other encoding", "/json", "upper", "", `"` + body + `"` + "\n"},
+		{"compressed type", "/image", "gzip", "", body},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept-Encoding", tt.accept)
+		}
+
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, r)
+		if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s: encoding %q, want %q", tt.name, got, tt.encoding)
+			continue
+		}
+
+		got := w.Body.String()
+		if tt.encoding == "gzip" {
+			zr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+				continue
+			}
+
+			out, _ := ioutil.ReadAll(zr)
+			got = string(out)
+		}
+
+		if got != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.name, got, tt.body)
+		}
+	}
+}
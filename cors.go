@@ -0,0 +1,115 @@
+package chopshop
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures CORSMiddleware.
+type CORSOptions struct {
+	// AllowedOrigins lists the origins (such as "https://app.example.com")
+	// permitted to make cross-origin requests. "*" permits any origin.
+	AllowedOrigins []string
+
+	// AllowedMethods and AllowedHeaders list the methods and request headers
+	// permitted in cross-origin requests. If empty, those requested by a
+	// preflight request are permitted.
+	AllowedMethods []string
+	AllowedHeaders []string
+
+	// ExposedHeaders lists the response headers readable by the client.
+	ExposedHeaders []string
+
+	// AllowCredentials permits requests from the origins listed explicitly to
+	// carry cookies and authorization headers, echoing the requesting origin.
+	// Origins permitted only by "*" are never permitted credentials.
+	AllowCredentials bool
+
+	// MaxAge is the time for which the result of a preflight request may be
+	// cached. It is omitted if zero.
+	MaxAge time.Duration
+}
+
+// listsOrigin returns true if the origin is listed explicitly.
+func (o *CORSOptions) listsOrigin(origin string) bool {
+	for _, allowed := range o.AllowedOrigins {
+		if allowed != "*" && strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasWildcard returns true if any origin is allowed.
+func (o *CORSOptions) hasWildcard() bool {
+	return hasItem("*", o.AllowedOrigins)
+}
+
+// CORSMiddleware constructs a middleware which permits cross-origin requests
+// from the origins allowed by opts. CORS preflight requests are answered with
+// BlankResponse(204) without invoking the wrapped handler, so it should be
+// added after (that is, outside) middleware which may reject them. Requests
+// from other origins receive no CORS headers, leaving the browser to block
+// them.
+func CORSMiddleware(opts CORSOptions) Middleware {
+	return func(fn ContextHandlerFunc) ContextHandlerFunc {
+		return func(ctx *RequestContext) Response {
+			r := ctx.Request
+			origin := r.Header.Get("Origin")
+			preflight := isPreflight(r)
+
+			header := ctx.ResponseWriter.Header()
+			header.Add("Vary", "Origin")
+
+			listed := opts.listsOrigin(origin)
+			if origin == "" || !listed && !opts.hasWildcard() {
+				if preflight {
+					return BlankResponse(http.StatusNoContent)
+				}
+
+				return fn(ctx)
+			}
+
+			switch {
+			case listed && opts.AllowCredentials:
+				header.Set("Access-Control-Allow-Origin", origin)
+				header.Set("Access-Control-Allow-Credentials", "true")
+			case opts.hasWildcard():
+				header.Set("Access-Control-Allow-Origin", "*")
+			default:
+				header.Set("Access-Control-Allow-Origin", origin)
+			}
+
+			if !preflight {
+				if len(opts.ExposedHeaders) > 0 {
+					header.Set("Access-Control-Expose-Headers", strings.Join(opts.ExposedHeaders, ", "))
+				}
+
+				return fn(ctx)
+			}
+
+			methods := r.Header.Get("Access-Control-Request-Method")
+			if len(opts.AllowedMethods) > 0 {
+				methods = strings.Join(opts.AllowedMethods, ", ")
+			}
+			header.Set("Access-Control-Allow-Methods", methods)
+
+			headers := r.Header.Get("Access-Control-Request-Headers")
+			if len(opts.AllowedHeaders) > 0 {
+				headers = strings.Join(opts.AllowedHeaders, ", ")
+			}
+			if headers != "" {
+				header.Set("Access-Control-Allow-Headers", headers)
+			}
+
+			if opts.MaxAge > 0 {
+				header.Set("Access-Control-Max-Age", strconv.Itoa(int(opts.MaxAge/time.Second)))
+			}
+
+			return BlankResponse(http.StatusNoContent)
+		}
+	}
+}
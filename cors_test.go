@@ -0,0 +1,211 @@
+package chopshop
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	trusted := CORSOptions{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Content-Type", "X-XSRF-Token"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+	public := CORSOptions{AllowedOrigins: []string{"*"}}
+	mixed := CORSOptions{
+		AllowedOrigins:   []string{"*", "https://app.example.com"},
+		AllowCredentials: true,
+	}
+
+	tests := []struct {
+		name      string
+		opts      CORSOptions
+		method    string
+		origin    string
+		preflight bool
+		status    int
+		ran       bool
+		header    map[string]string
+	}{
+		{
+			name:   "listed origin",
+			opts:   trusted,
+			method: "GET",
+			origin: "https://app.example.com",
+			status: http.StatusOK,
+			ran:    true,
+			header: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Credentials": "true",
+			},
+		},
+		{
+			name:   "unlisted origin",
+			opts:   trusted,
+			method: "GET",
+			origin: "https://evil.example.com",
+			status: http.StatusOK,
+			ran:    true,
+			header: map[string]string{
+				"Access-Control-Allow-Origin":      "",
+				"Access-Control-Allow-Credentials": "",
+			},
+		},
+		{
+			name:      "listed preflight",
+			opts:      trusted,
+			method:    "OPTIONS",
+			origin:    "https://app.example.com",
+			preflight: true,
+			status:    http.StatusNoContent,
+			header: map[string]string{
+				"Access-Control-Allow-Methods": "GET, POST",
+				"Access-Control-Allow-Headers": "Content-Type, X-XSRF-Token",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			name:      "unlisted preflight",
+			opts:      trusted,
+			method:    "OPTIONS",
+			origin:    "https://evil.example.com",
+			preflight: true,
+			status:    http.StatusNoContent,
+			header:    map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:   "wildcard",
+			opts:   public,
+			method: "GET",
+			origin: "https://x.example",
+			status: http.StatusOK,
+			ran:    true,
+			header: map[string]string{"Access-Control-Allow-Origin": "*"},
+		},
+		{
+			name:   "wildcard with credentials",
+			opts:   mixed,
+			method: "GET",
+			origin: "https://evil.example.com",
+			status: http.StatusOK,
+			ran:    true,
+			header: map[string]string{
+				"Access-Control-Allow-Origin":      "*",
+				"Access-Control-Allow-Credentials": "",
+			},
+		},
+		{
+			name:   "listed with wildcard",
+			opts:   mixed,
+			method: "GET",
+			origin: "https://app.example.com",
+			status: http.StatusOK,
+			ran:    true,
+			header: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Credentials": "true",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		f, _ := NewFramework("app", "")
+		f.SessionSecret = []byte("secret")
+
+		ran := false
+		f.Path("/api").Middleware(CORSMiddleware(test.opts)).Handler(func(ctx *RequestContext) Response {
+			ran = true
+			return JSONResponse(1)
+		})
+
+		r := httptest.NewRequest(test.method, "/api", nil)
+		r.Header.Set("Origin", test.origin)
+		if test.preflight {
+			r.Header.Set("Access-Control-Request-Method", "POST")
+		}
+
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, r)
+		if w.Code != test.status || ran != test.ran {
+			t.Errorf("%s: status %d ran %t, want %d %t", test.name, w.Code, ran, test.status, test.ran)
+		}
+
+		for k, v := range test.header {
+			if got := w.Header().Get(k); got != v {
+				t.Errorf("%s: %s %q, want %q", test.name, k, got, v)
+			}
+		}
+	}
+}
+
+func TestCORSPreflightOnMethodRoutes(t *testing.T) {
+	opts := CORSOptions{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowCredentials: true,
+	}
+
+	f, _ := NewFramework("app", "")
+	f.SessionSecret = []byte("secret")
+	f.Middleware(CORSMiddleware(opts))
+
+	ran := false
+	handler := func(ctx *RequestContext) Response {
+		ran = true
+		return JSONResponse(1)
+	}
+
+	f.Path("/items").Post(handler)
+	f.Path("/admin").Middleware(RightCheckMiddleware("admin")).Post(handler)
+	f.Subrouter("/api").Resource("/widgets", widgetController{})
+
+	g, _ := NewFramework("app", "")
+	g.SessionSecret = []byte("secret")
+	g.Path("/items").Middleware(CORSMiddleware(opts)).Methods("PUT").Handler(handler)
+
+	tests := []struct {
+		name   string
+		f      *Framework
+		method string
+		path   string
+		asked  string
+		status int
+		ran    bool
+		cors   bool
+	}{
+		{"preflight", f, "OPTIONS", "/items", "POST", http.StatusNoContent, false, true},
+		{"preflight guarded", f, "OPTIONS", "/admin", "POST", http.StatusNoContent, false, true},
+		{"preflight resource", f, "OPTIONS", "/api/widgets/7", "DELETE", http.StatusNoContent, false, true},
+		{"preflight route middleware", g, "OPTIONS", "/items", "PUT", http.StatusNoContent, false, true},
+		{"preflight unserved method", f, "OPTIONS", "/items", "DELETE", http.StatusMethodNotAllowed, false, false},
+		{"plain options", f, "OPTIONS", "/items", "", http.StatusMethodNotAllowed, false, false},
+		{"request", f, "POST", "/items", "", http.StatusOK, true, true},
+		{"wrong method", f, "GET", "/items", "", http.StatusMethodNotAllowed, false, false},
+	}
+
+	for _, tt := range tests {
+		ran = false
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		r.Header.Set("Origin", "https://app.example.com")
+		if tt.asked != "" {
+			r.Header.Set("Access-Control-Request-Method", tt.asked)
+		}
+
+		w := httptest.NewRecorder()
+		tt.f.ServeHTTP(w, r)
+		if w.Code != tt.status || ran != tt.ran {
+			t.Errorf("%s: status %d ran %t, want %d %t", tt.name, w.Code, ran, tt.status, tt.ran)
+		}
+
+		if cors := w.Header().Get("Access-Control-Allow-Origin") != ""; cors != tt.cors {
+			t.Errorf("%s: CORS headers %t, want %t", tt.name, cors, tt.cors)
+		}
+
+		if tt.asked != "" && len(w.Header()["Set-Cookie"]) != 0 {
+			t.Errorf("%s: cookies set on preflight response", tt.name)
+		}
+	}
+}
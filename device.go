@@ -0,0 +1,166 @@
+package chopshop
+
+import (
+	"net/http"
+	"strings"
+)
+
+// DeviceInfo describes the client making a request.
+type DeviceInfo struct {
+	Browser        string
+	BrowserVersion string
+	OS             string
+	Mobile         bool
+}
+
+// UserAgentParser extracts DeviceInfo from request headers, allowing a full
+// User-Agent parsing library to replace the built-in heuristics.
+type UserAgentParser interface {
+	Parse(header http.Header) DeviceInfo
+}
+
+// UserAgentParserFunc adapts a function for use as a UserAgentParser.
+type UserAgentParserFunc func(header http.Header) DeviceInfo
+
+// Parse implements UserAgentParser.
+func (fn UserAgentParserFunc) Parse(header http.Header) DeviceInfo {
+	return fn(header)
+}
+
+// UserAgent returns the User-Agent header of the request.
+func (ctx *RequestContext) UserAgent() string {
+	return ctx.Request.Header.Get("User-Agent")
+}
+
+// DeviceInfo returns a description of the client parsed from the User-Agent
+// and Sec-CH-UA client hint headers by Framework.UserAgentParser, or by
+// lightweight built-in heuristics if it is nil.
+func (ctx *RequestContext) DeviceInfo() DeviceInfo {
+	if ctx.deviceInfo == nil {
+		var parser UserAgentParser = UserAgentParserFunc(parseUserAgent)
+		if ctx.framework.UserAgentParser != nil {
+			parser = ctx.framework.UserAgentParser
+		}
+
+		info := parser.Parse(ctx.Request.Header)
+		ctx.deviceInfo = &info
+	}
+
+	return *ctx.deviceInfo
+}
+
+// userAgentBrowsers lists the markers identifying browsers, in order of
+// precedence as most browsers also claim to be Safari or Chrome.
+var userAgentBrowsers = []struct {
+	marker, name, versionMarker string
+}{
+	{"Edg/", "Edge", "Edg/"},
+	{"EdgiOS/", "Edge", "EdgiOS/"},
+	{"OPR/", "Opera", "OPR/"},
+	{"Firefox/", "Firefox", "Firefox/"},
+	{"FxiOS/", "Firefox", "FxiOS/"},
+	{"CriOS/", "Chrome", "CriOS/"},
+	{"Chrome/", "Chrome", "Chrome/"},
+	{"Safari/", "Safari", "Version/"},
+}
+
+// userAgentSystems lists the markers identifying operating systems, in order
+// of precedence.
+var userAgentSystems = []struct {
+	marker, name string
+}{
+	{"Windows", "Windows"},
+	{"Android", "Android"},
+	{"iPhone", "iOS"},
+	{"iPad", "iOS"},
+	{"iPod", "iOS"},
+	{"CrOS", "ChromeOS"},
+	{"Mac OS X", "macOS"},
+	{"Linux", "Linux"},
+}
+
+func parseUserAgent(header http.Header) DeviceInfo {
+	var info DeviceInfo
+	ua := header.Get("User-Agent")
+
+	for _, b := range userAgentBrowsers {
+		if strings.Contains(ua, b.marker) {
+			info.Browser = b.name
+			info.BrowserVersion = userAgentToken(ua, b.versionMarker)
+			break
+		}
+	}
+
+	for _, s := range userAgentSystems {
+		if strings.Contains(ua, s.marker) {
+			info.OS = s.name
+			break
+		}
+	}
+
+	info.Mobile = strings.Contains(ua, "Mobi")
+
+	// client hints are more reliable than the User-Agent where present
+	if mobile := header.Get("Sec-CH-UA-Mobile"); mobile != "" {
+		info.Mobile = mobile == "?1"
+	}
+
+	if platform := strings.Trim(header.Get("Sec-CH-UA-Platform"), `"`); platform != "" {
+		info.OS = platform
+		if platform == "macOS" || platform == "Chrome OS" {
+			info.OS = strings.Replace(platform, " ", "", -1)
+		}
+	}
+
+	if brand, version := clientHintBrand(header.Get("Sec-CH-UA")); brand != "" {
+		info.Browser, info.BrowserVersion = brand, version
+	}
+
+	return info
+}
+
+// userAgentToken returns the product version following marker.
+func userAgentToken(ua, marker string) string {
+	i := strings.Index(ua, marker)
+	if i < 0 {
+		return ""
+	}
+
+	version := ua[i+len(marker):]
+	if end := strings.IndexAny(version, " ;)"); end >= 0 {
+		version = version[:end]
+	}
+
+	return version
+}
+
+// clientHintBrand picks the most specific brand from a Sec-CH-UA header such
+// as `"Chromium";v="118", "Google Chrome";v="118", "Not=A?Brand";v="99"`.
+func clientHintBrand(hint string) (string, string) {
+	var brand, version string
+	for _, entry := range strings.Split(hint, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ";", 2)
+		name := strings.Trim(parts[0], `"`)
+		if name == "" || strings.Contains(name, "Brand") {
+			continue
+		}
+
+		var v string
+		if len(parts) == 2 {
+			v = strings.Trim(strings.TrimPrefix(strings.TrimSpace(parts[1]), "v="), `"`)
+		}
+
+		if brand == "" || brand == "Chromium" {
+			brand, version = name, v
+		}
+	}
+
+	switch brand {
+	case "Google Chrome":
+		brand = "Chrome"
+	case "Microsoft Edge":
+		brand = "Edge"
+	}
+
+	return brand, version
+}
@@ -0,0 +1,81 @@
+package chopshop
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDeviceInfo(t *testing.T) {
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   DeviceInfo
+	}{
+		{
+			"chrome",
+			map[string]string{"User-Agent": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36"},
+			DeviceInfo{"Chrome", "118.0.0.0", "Windows", false},
+		},
+		{
+			"mobile safari",
+			map[string]string{"User-Agent": "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1"},
+			DeviceInfo{"Safari", "17.0", "iOS", true},
+		},
+		{
+			"firefox",
+			map[string]string{"User-Agent": "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:109.0) Gecko/20100101 Firefox/118.0"},
+			DeviceInfo{"Firefox", "118.0", "macOS", false},
+		},
+		{
+			"edge",
+			map[string]string{"User-Agent": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36 Edg/118.0.2088.76"},
+			DeviceInfo{"Edge", "118.0.2088.76", "Windows", false},
+		},
+		{
+			"android chrome",
+			map[string]string{"User-Agent": "Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Mobile Safari/537.36"},
+			DeviceInfo{"Chrome", "118.0.0.0", "Android", true},
+		},
+		{
+			"client hints",
+			map[string]string{
+				"Sec-CH-UA":          `"Chromium";v="118", "Google Chrome";v="118", "Not=A?Brand";v="99"`,
+				"Sec-CH-UA-Mobile":   "?1",
+				"Sec-CH-UA-Platform": `"Android"`,
+			},
+			DeviceInfo{"Chrome", "118", "Android", true},
+		},
+		{"none", nil, DeviceInfo{}},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t)
+		for k, v := range tt.header {
+			ctx.Request.Header.Set(k, v)
+		}
+
+		if got := ctx.DeviceInfo(); got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUserAgentParser(t *testing.T) {
+	f, ctx, _ := newTestContext(t)
+	calls := 0
+	f.UserAgentParser = UserAgentParserFunc(func(header http.Header) DeviceInfo {
+		calls++
+		return DeviceInfo{Browser: header.Get("User-Agent")}
+	})
+	ctx.Request.Header.Set("User-Agent", "custom")
+
+	for i := 0; i < 2; i++ {
+		if got := ctx.DeviceInfo(); got.Browser != "custom" {
+			t.Errorf("got %+v", got)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("parser called %d times, want once", calls)
+	}
+}
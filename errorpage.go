@@ -0,0 +1,85 @@
+package chopshop
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrorPage is the data with which Framework.ErrorTemplate is executed.
+type ErrorPage struct {
+	Status    int
+	Title     string
+	Message   string
+	RequestID string
+}
+
+// errorPageResponse returns an error response which is an HTML page rendered
+// by the error template if the client prefers HTML, or JSON otherwise.
+func (ctx *RequestContext) errorPageResponse(message string, status int) Response {
+	if !prefersHTML(ctx.Request.Header.Get("Accept")) {
+		response := ErrorResponse(message, status)
+		return ResponseFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept")
+			response.ServeHTTP(w, r)
+		})
+	}
+
+	tmpl := ctx.framework.ErrorTemplate
+	name := strconv.Itoa(status)
+	if tmpl.Lookup(name) == nil {
+		name = "error"
+	}
+
+	page := ErrorPage{
+		Status:    status,
+		Title:     http.StatusText(status),
+		Message:   message,
+		RequestID: ctx.RequestID(),
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&body, name, page); err != nil {
+		ctx.framework.logf("chopshop: error template %q failed: %s", name, err)
+		return ErrorResponse(message, status)
+	}
+
+	return ResponseFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		w.Write(body.Bytes())
+	})
+}
+
+// prefersHTML returns true if an Accept header names text/html with a higher
+// q-value than it gives JSON, either explicitly or through a wildcard.
+func prefersHTML(accept string) bool {
+	var htmlQ, jsonQ float64
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		switch mediaType = strings.ToLower(mediaType); {
+		case mediaType == "text/html":
+			htmlQ = q
+		case isJSONMediaType(mediaType) || mediaType == "application/*" || mediaType == "*/*":
+			if q > jsonQ {
+				jsonQ = q
+			}
+		}
+	}
+
+	return htmlQ > jsonQ
+}
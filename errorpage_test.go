@@ -0,0 +1,86 @@
+package chopshop
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorPages(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.SessionSecret = []byte("secret")
+	f.ErrorTemplate = template.Must(template.New("error").Parse(`<h1>{{.Status}} {{.Title}}</h1><p>{{.Message}}</p>`))
+	template.Must(f.ErrorTemplate.New("404").Parse(`<h1>Not here</h1>`))
+	f.Path("/bad").Handler(func(ctx *RequestContext) Response {
+		return ctx.CustomErrorResponse(errors.New("x"), "Bad <input>.", http.StatusBadRequest)
+	})
+	f.Path("/missing").Handler(func(ctx *RequestContext) Response {
+		return ctx.CustomErrorResponse(errors.New("x"), "Missing.", http.StatusNotFound)
+	})
+
+	browser := "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
+	tests := []struct {
+		name        string
+		path        string
+		accept      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{
+			name:        "html",
+			path:        "/bad",
+			accept:      browser,
+			status:      http.StatusBadRequest,
+			contentType: "text/html; charset=utf-8",
+			body:        "<h1>400 Bad Request</h1><p>Bad &lt;input&gt;.</p>",
+		},
+		{
+			name:        "status template",
+			path:        "/missing",
+			accept:      browser,
+			status:      http.StatusNotFound,
+			contentType: "text/html; charset=utf-8",
+			body:        "<h1>Not here</h1>",
+		},
+		{
+			name:        "json",
+			path:        "/bad",
+			accept:      "application/json",
+			status:      http.StatusBadRequest,
+			contentType: "application/json",
+			body:        `"message":"Bad \u003cinput\u003e."`,
+		},
+		{
+			name:        "no accept",
+			path:        "/bad",
+			status:      http.StatusBadRequest,
+			contentType: "application/json",
+		},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+
+		if got := w.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s: content type %q, want %q", tt.name, got, tt.contentType)
+		}
+
+		if !strings.Contains(w.Body.String(), tt.body) {
+			t.Errorf("%s: body %q, want %q", tt.name, w.Body.String(), tt.body)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package chopshop
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// ETagJSONResponse returns a JSON response containing only the fields for which
+// the current context possesses the "read" right, tagged with an ETag derived
+// from both the serialized body and the rights of the principal. If the
+// request's If-None-Match header matches, 304 Not Modified is returned instead.
+func (ctx *RequestContext) ETagJSONResponse(v interface{}) Response {
+	out, err := ctx.safeSerialize(reflect.ValueOf(v))
+	if err != nil {
+		return ctx.ErrorResponse(err, http.StatusInternalServerError)
+	}
+
+	body, err := json.Marshal(out)
+	if err != nil {
+		return ctx.ErrorResponse(err, http.StatusInternalServerError)
+	}
+
+	etag := entityTag([]byte(ctx.rightsKey()), body)
+	if etagMatches(ctx.Request.Header.Get("If-None-Match"), etag) {
+		return NotModifiedResponse(etag)
+	}
+
+	return ResponseFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", etag)
+		w.Write(append(body, '\n'))
+	})
+}
+
+// ETagResponse wraps a response, such as one from RequestContext.JSONResponse,
+// so that a 200 response is tagged with a strong ETag computed over its body.
+// As the body is that which would be sent, the ETag of a response filtered by
+// the rights of the context reflects only the fields visible to the client. If
+// the request's If-None-Match header matches, 304 Not Modified is sent
+// instead. The wrapped response is rendered into memory to compute the ETag,
+// so ETagResponse is unsuitable for streamed responses.
+func ETagResponse(response Response) Response {
+	return &etagResponse{response: response}
+}
+
+type etagResponse struct {
+	response Response
+}
+
+func (e *etagResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	buffered := BufferResponse(e.response, r)
+	if buffered.Status != http.StatusOK || buffered.Header.Get("ETag") != "" {
+		buffered.ServeHTTP(w, r)
+		return
+	}
+
+	etag := entityTag(buffered.Body)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		NotModifiedResponse(etag).ServeHTTP(w, r)
+		return
+	}
+
+	buffered.Header.Set("ETag", etag)
+	buffered.ServeHTTP(w, r)
+}
+
+func (e *etagResponse) Cancel() {
+	e.response.Cancel()
+}
+
+// NotModifiedResponse constructs a 304 Not Modified response carrying the
+// given ETag.
+func NotModifiedResponse(etag string) ResponseFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+	}
+}
+
+// entityTag computes a strong ETag over the given byte slices.
+func entityTag(parts ...[]byte) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write(part)
+		h.Write([]byte{0})
+	}
+
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches implements the weak comparison used by If-None-Match.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,116 @@
+package chopshop
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestETagJSONResponse(t *testing.T) {
+	type entity struct {
+		A string `json:"a"`
+	}
+
+	etagFor := func(rights ...string) string {
+		_, ctx, _ := newTestContext(t, rights...)
+		w := httptest.NewRecorder()
+		ctx.ETagJSONResponse(entity{"x"}).ServeHTTP(w, ctx.Request)
+		return w.Header().Get("ETag")
+	}
+
+	if etagFor("r1") == etagFor("r2") {
+		t.Error("principals with differing rights share an ETag")
+	}
+
+	if etagFor("r1", "r2") != etagFor("r2", "r1") {
+		t.Error("ETag depends on the order of rights")
+	}
+
+	etag := etagFor("r1")
+	tests := []struct {
+		ifNoneMatch string
+		status      int
+	}{
+		{"", http.StatusOK},
+		{etag, http.StatusNotModified},
+		{"W/" + etag, http.StatusNotModified},
+		{`"other", ` + etag, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+		{`"other"`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t, "r1")
+		ctx.Request.Header.Set("If-None-Match", tt.ifNoneMatch)
+
+		w := httptest.NewRecorder()
+		ctx.ETagJSONResponse(entity{"x"}).ServeHTTP(w, ctx.Request)
+		if w.Code != tt.status || w.Header().Get("ETag") != etag {
+			t.Errorf("If-None-Match %q: status %d ETag %s, want %d %s", tt.ifNoneMatch, w.Code, w.Header().Get("ETag"), tt.status, etag)
+		}
+
+		if tt.status == http.StatusNotModified && w.Body.Len() != 0 {
+			t.Errorf("If-None-Match %q: body sent with 304", tt.ifNoneMatch)
+		}
+	}
+}
+
+func TestETagResponse(t *testing.T) {
+	type doc struct {
+		Name   string `json:"name" readWrite:"user"`
+		Secret string `json:"secret" readWrite:"admin"`
+	}
+
+	serve := func(ifNoneMatch string, rights ...string) *httptest.ResponseRecorder {
+		_, ctx, w := newTestContext(t, rights...)
+		if ifNoneMatch != "" {
+			ctx.Request.Header.Set("If-None-Match", ifNoneMatch)
+		}
+
+		ETagResponse(ctx.JSONResponse(doc{Name: "a", Secret: "s"})).ServeHTTP(w, ctx.Request)
+		return w
+	}
+
+	w := serve("", "user")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || w.Body.String() != "{\"name\":\"a\"}\n" {
+		t.Fatalf("status %d ETag %s body %q", w.Code, etag, w.Body.String())
+	}
+
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type %q", w.Header().Get("Content-Type"))
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		rights      []string
+		status      int
+		sameETag    bool
+	}{
+		{"match", etag, []string{"user"}, http.StatusNotModified, true},
+		{"other", `"other"`, []string{"user"}, http.StatusOK, true},
+		{"more visible fields", etag, []string{"user", "admin"}, http.StatusOK, false},
+	}
+
+	for _, tt := range tests {
+		w := serve(tt.ifNoneMatch, tt.rights...)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+
+		if (w.Header().Get("ETag") == etag) != tt.sameETag {
+			t.Errorf("%s: ETag %s, original %s", tt.name, w.Header().Get("ETag"), etag)
+		}
+
+		if tt.status == http.StatusNotModified && w.Body.Len() != 0 {
+			t.Errorf("%s: body sent with 304", tt.name)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	ETagResponse(ErrorResponse("failed", http.StatusInternalServerError)).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusInternalServerError || w.Header().Get("ETag") != "" {
+		t.Errorf("error response: status %d ETag %s", w.Code, w.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,77 @@
+package chopshop
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// flightGroup deduplicates concurrent calls sharing the same key.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg       sync.WaitGroup
+	response *BufferedResponse
+}
+
+// do invokes fn unless a call with the same key is already in flight, in which
+// case it waits for and returns that call's result. The boolean is true for the
+// caller which invoked fn.
+func (g *flightGroup) do(key string, fn func() *BufferedResponse) (*BufferedResponse, bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		call.wg.Wait()
+		return call.response, false
+	}
+
+	call := &flightCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		call.wg.Done()
+	}()
+
+	call.response = fn()
+	return call.response, true
+}
+
+// principalCacheKey returns a key prefix identifying the principal and the
+// rights it holds.
+func (ctx *RequestContext) principalCacheKey() string {
+	if !ctx.IsAuthenticated() {
+		return "anonymous:"
+	}
+
+	return fmt.Sprintf("%d:%s:", ctx.UserID(), ctx.rightsKey())
+}
+
+// rightsKey returns a canonical representation of the rights currently held.
+func (ctx *RequestContext) rightsKey() string {
+	if !ctx.IsAuthenticated() {
+		return ""
+	}
+
+	rights := append([]string(nil), ctx.principal.Rights...)
+	for right := range ctx.temporaryRights() {
+		if ctx.hasTemporaryRight(right) {
+			rights = append(rights, right)
+		}
+	}
+	sort.Strings(rights)
+
+	return strings.Join(rights, ",")
+}
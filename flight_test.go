@@ -0,0 +1,83 @@
+package chopshop
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSingleFlightMiddleware(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.SessionSecret = []byte("secret")
+
+	var calls int32
+	release := make(chan struct{})
+	mw := SingleFlightMiddleware(nil)
+	handler := mw(func(ctx *RequestContext) Response {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return ResponseFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.SetCookie(w, &http.Cookie{Name: "leader", Value: "secret"})
+			w.Write([]byte(ctx.Username()))
+		})
+	})
+
+	tests := []struct {
+		name     string
+		username string
+		rights   []string
+		requests int
+	}{
+		{"alice", "alice", []string{"a"}, 5},
+		{"bob", "bob", []string{"a"}, 5},
+		{"anonymous", "", nil, 5},
+	}
+
+	type result struct {
+		username, body string
+		cookie         bool
+	}
+
+	results := make(chan result, 15)
+	var wg sync.WaitGroup
+	for _, tt := range tests {
+		for i := 0; i < tt.requests; i++ {
+			ctx, _ := f.CreateRequestContext(httptest.NewRecorder(), httptest.NewRequest("GET", "/report", nil))
+			if tt.rights != nil {
+				ctx.SetPrincipal(tt.username, uint64(len(tt.username)), tt.rights)
+			}
+
+			wg.Add(1)
+			go func(username string) {
+				defer wg.Done()
+				w := httptest.NewRecorder()
+				handler(ctx).ServeHTTP(w, ctx.Request)
+				_, cookie := responseCookies(w)["leader"]
+				results <- result{username, w.Body.String(), cookie}
+			}(tt.username)
+		}
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	leaders := 0
+	for r := range results {
+		if r.body != r.username {
+			t.Errorf("%q received the response of %q", r.username, r.body)
+		}
+
+		if r.cookie {
+			leaders++
+		}
+	}
+
+	if calls != int32(len(tests)) || leaders != len(tests) {
+		t.Fatalf("handler ran %d times and %d responses set cookies, want %d", calls, leaders, len(tests))
+	}
+}
@@ -0,0 +1,267 @@
+package chopshop
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// Values of Framework.TimeFormat other than a layout accepted by time.Format.
+const (
+	// TimeFormatUnix encodes times as integer seconds since the epoch.
+	TimeFormatUnix = "unix"
+
+	// TimeFormatUnixMilli encodes times as integer milliseconds since the
+	// epoch.
+	TimeFormatUnixMilli = "unixmilli"
+
+	// TimeFormatRFC3339 encodes times as RFC 3339 strings without fractional
+	// seconds.
+	TimeFormatRFC3339 = time.RFC3339
+)
+
+// Values of Framework.DurationFormat.
+const (
+	// DurationFormatNanoseconds encodes durations as integer nanoseconds.
+	DurationFormatNanoseconds = ""
+
+	// DurationFormatSeconds encodes durations as fractional seconds.
+	DurationFormatSeconds = "seconds"
+
+	// DurationFormatString encodes durations as strings such as "1h30m", as
+	// produced by time.Duration's String method.
+	DurationFormatString = "string"
+)
+
+// hasCustomFormats returns true if values read by ReadJSON must be normalized
+// before they are decoded.
+func (f *Framework) hasCustomFormats() bool {
+	return f.TimeFormat != "" || f.DurationFormat != DurationFormatNanoseconds
+}
+
+// timeValue returns the time held by rv, which may be a time.Time or a non-nil
+// pointer to one.
+func timeValue(rv reflect.Value) (time.Time, bool) {
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+
+	if rv.Type() != timeType {
+		return time.Time{}, false
+	}
+
+	return rv.Interface().(time.Time), true
+}
+
+// formatTime encodes t according to TimeFormat.
+func (f *Framework) formatTime(t time.Time) interface{} {
+	switch f.TimeFormat {
+	case TimeFormatUnix:
+		return t.Unix()
+	case TimeFormatUnixMilli:
+		return t.UnixNano() / int64(time.Millisecond)
+	}
+
+	return t.Format(f.TimeFormat)
+}
+
+// parseTime converts a time encoded according to TimeFormat into the RFC 3339
+// form expected by time.Time's UnmarshalJSON. Values in any other form are
+// returned unchanged.
+func (f *Framework) parseTime(v interface{}) (interface{}, error) {
+	var t time.Time
+	switch v := v.(type) {
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return nil, err
+		}
+
+		switch f.TimeFormat {
+		case TimeFormatUnix:
+			t = time.Unix(n, 0)
+		case TimeFormatUnixMilli:
+			t = time.Unix(0, n*int64(time.Millisecond))
+		default:
+			return v, nil
+		}
+	case string:
+		if f.TimeFormat == TimeFormatUnix || f.TimeFormat == TimeFormatUnixMilli {
+			return v, nil
+		}
+
+		var err error
+		if t, err = time.Parse(f.TimeFormat, v); err != nil {
+			return nil, err
+		}
+	default:
+		return v, nil
+	}
+
+	return t.Format(time.RFC3339Nano), nil
+}
+
+// durationValue returns the duration held by rv, which may be a time.Duration
+// or a non-nil pointer to one.
+func durationValue(rv reflect.Value) (time.Duration, bool) {
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+
+	if rv.Type() != durationType {
+		return 0, false
+	}
+
+	return time.Duration(rv.Int()), true
+}
+
+// formatDuration encodes d according to DurationFormat.
+func (f *Framework) formatDuration(d time.Duration) interface{} {
+	switch f.DurationFormat {
+	case DurationFormatSeconds:
+		return d.Seconds()
+	case DurationFormatString:
+		return d.String()
+	}
+
+	return int64(d)
+}
+
+// parseDuration converts a duration encoded according to DurationFormat into
+// integer nanoseconds. Values in any other form are returned unchanged.
+func (f *Framework) parseDuration(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		if f.DurationFormat != DurationFormatSeconds {
+			return v, nil
+		}
+
+		sec, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+
+		return json.Number(strconv.FormatInt(int64(sec*float64(time.Second)), 10)), nil
+	case string:
+		if f.DurationFormat != DurationFormatString {
+			return v, nil
+		}
+
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, err
+		}
+
+		return json.Number(strconv.FormatInt(int64(d), 10)), nil
+	}
+
+	return v, nil
+}
+
+// normalizeJSON rewrites a decoded JSON value destined for a value of type ty,
+// converting custom encodings of times and durations into those understood by
+// encoding/json.
+func (f *Framework) normalizeJSON(v interface{}, ty reflect.Type) (interface{}, error) {
+	for ty.Kind() == reflect.Ptr {
+		ty = ty.Elem()
+	}
+
+	if v == nil {
+		return nil, nil
+	}
+
+	switch ty {
+	case timeType:
+		return f.parseTime(v)
+	case durationType:
+		return f.parseDuration(v)
+	}
+
+	var err error
+	switch ty.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok || unmarshalerFor(reflect.New(ty).Elem()) != nil {
+			return v, nil
+		}
+
+		for key, val := range obj {
+			if field, ok := jsonField(ty, key); ok {
+				if obj[key], err = f.normalizeJSON(val, field.Type); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if arr, ok := v.([]interface{}); ok {
+			for i := range arr {
+				if arr[i], err = f.normalizeJSON(arr[i], ty.Elem()); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case reflect.Map:
+		if obj, ok := v.(map[string]interface{}); ok {
+			for key, val := range obj {
+				if obj[key], err = f.normalizeJSON(val, ty.Elem()); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return v, nil
+}
+
+// jsonField returns the field of a struct into which encoding/json decodes the
+// given key, preferring an exact match over a case-insensitive one.
+func jsonField(ty reflect.Type, key string) (reflect.StructField, bool) {
+	var folded reflect.StructField
+	var found bool
+	for i := 0; i < ty.NumField(); i++ {
+		field := ty.Field(i)
+		name, _ := parseJSONTag(field.Tag.Get("json"))
+		if name == "-" {
+			continue
+		}
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+
+			if embedded.Kind() == reflect.Struct {
+				if f, ok := jsonField(embedded, key); ok {
+					return f, true
+				}
+				continue
+			}
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		if name == key {
+			return field, true
+		}
+
+		if !found && strings.EqualFold(name, key) {
+			folded, found = field, true
+		}
+	}
+
+	return folded, found
+}
@@ -0,0 +1,92 @@
+package chopshop
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeFormat(t *testing.T) {
+	type event struct {
+		At  time.Time  `json:"at"`
+		Opt *time.Time `json:"opt"`
+	}
+
+	at := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"", `{"at":"2020-01-02T03:04:05Z","opt":"2020-01-02T03:04:05Z"}`},
+		{TimeFormatUnix, `{"at":1577934245,"opt":1577934245}`},
+		{TimeFormatUnixMilli, `{"at":1577934245000,"opt":1577934245000}`},
+		{TimeFormatRFC3339, `{"at":"2020-01-02T03:04:05Z","opt":"2020-01-02T03:04:05Z"}`},
+		{"2006-01-02 15:04:05", `{"at":"2020-01-02 03:04:05","opt":"2020-01-02 03:04:05"}`},
+	}
+
+	for _, tt := range tests {
+		f, ctx, _ := newTestContext(t)
+		f.TimeFormat = tt.format
+
+		w := httptest.NewRecorder()
+		ctx.JSONResponse(event{At: at, Opt: &at}).ServeHTTP(w, ctx.Request)
+		if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+			t.Errorf("%q: encoded %s, want %s", tt.format, got, tt.want)
+		}
+
+		ctx.Request.Body = ioutil.NopCloser(bytes.NewReader(w.Body.Bytes()))
+		var out event
+		if err := ctx.ReadJSON(&out); err != nil {
+			t.Errorf("%q: %v", tt.format, err)
+			continue
+		}
+
+		if !out.At.Equal(at) || out.Opt == nil || !out.Opt.Equal(at) {
+			t.Errorf("%q: decoded %v", tt.format, out)
+		}
+	}
+}
+
+func TestDurationFormat(t *testing.T) {
+	type job struct {
+		Every time.Duration  `json:"every"`
+		Opt   *time.Duration `json:"opt"`
+	}
+
+	d := 90*time.Minute + 500*time.Millisecond
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{DurationFormatNanoseconds, `{"every":5400500000000,"opt":5400500000000}`},
+		{DurationFormatSeconds, `{"every":5400.5,"opt":5400.5}`},
+		{DurationFormatString, `{"every":"1h30m0.5s","opt":"1h30m0.5s"}`},
+	}
+
+	for _, tt := range tests {
+		f, ctx, _ := newTestContext(t)
+		f.DurationFormat = tt.format
+
+		w := httptest.NewRecorder()
+		ctx.JSONResponse(job{Every: d, Opt: &d}).ServeHTTP(w, ctx.Request)
+		if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+			t.Errorf("%q: encoded %s, want %s", tt.format, got, tt.want)
+		}
+
+		ctx.Request.Body = ioutil.NopCloser(bytes.NewReader(w.Body.Bytes()))
+		var out job
+		if err := ctx.ReadJSON(&out); err != nil {
+			t.Errorf("%q: %v", tt.format, err)
+			continue
+		}
+
+		if out.Every != d || out.Opt == nil || *out.Opt != d {
+			t.Errorf("%q: decoded %v", tt.format, out)
+		}
+	}
+}
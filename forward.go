@@ -0,0 +1,81 @@
+package chopshop
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// PrincipalPropagation selects how ForwardHeaders conveys the principal to
+// downstream services.
+type PrincipalPropagation int
+
+const (
+	// PropagateNone forwards no identity.
+	PropagateNone PrincipalPropagation = iota
+
+	// PropagateToken forwards a short-lived token signed with the session
+	// secret in an Authorization: Bearer header.
+	PropagateToken
+
+	// PropagateHeaders forwards the principal in the X-User-ID, X-Username
+	// and X-Rights headers. Downstream services must only accept these from
+	// trusted peers.
+	PropagateHeaders
+)
+
+// DefaultForwardTokenDuration is the lifetime of forwarded tokens when
+// Framework.ForwardTokenDuration is unset.
+const DefaultForwardTokenDuration = time.Minute
+
+// ForwardTokenAudience is the aud claim of forwarded tokens, which marks them
+// as meant for downstream services so that they are not accepted as sessions.
+const ForwardTokenAudience = "forward"
+
+// ForwardHeaders returns the headers with which a request to a downstream
+// service should be decorated in order to propagate the current request id
+// and, according to Framework.Propagation, the principal.
+func (ctx *RequestContext) ForwardHeaders() http.Header {
+	h := make(http.Header)
+	h.Set("X-Request-ID", ctx.RequestID())
+
+	if !ctx.IsAuthenticated() {
+		return h
+	}
+
+	switch ctx.framework.Propagation {
+	case PropagateToken:
+		token, err := ctx.forwardToken()
+		if err != nil {
+			ctx.AddError(err)
+			break
+		}
+		h.Set("Authorization", "Bearer "+token)
+	case PropagateHeaders:
+		h.Set("X-User-ID", strconv.FormatUint(ctx.UserID(), 10))
+		h.Set("X-Username", ctx.Username())
+		h.Set("X-Rights", strings.Join(ctx.principal.Rights, ","))
+	}
+
+	return h
+}
+
+func (ctx *RequestContext) forwardToken() (string, error) {
+	f := ctx.framework
+	ttl := f.ForwardTokenDuration
+	if ttl == 0 {
+		ttl = DefaultForwardTokenDuration
+	}
+
+	token := jwt.New(f.signingMethod())
+	token.Claims["iss"] = f.IssuerName
+	token.Claims["aud"] = ForwardTokenAudience
+	token.Claims["sub"] = ctx.principal
+	token.Claims["jti"] = ctx.RequestID()
+	token.Claims["iat"] = ctx.requestTime.Unix()
+	token.Claims["exp"] = ctx.requestTime.Add(ttl).Unix()
+	return f.signToken(token)
+}
@@ -0,0 +1,79 @@
+package chopshop
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardHeaders(t *testing.T) {
+	tests := []struct {
+		name        string
+		propagation PrincipalPropagation
+		want        map[string]string
+	}{
+		{"none", PropagateNone, map[string]string{"X-User-ID": "", "Authorization": ""}},
+		{"headers", PropagateHeaders, map[string]string{"X-User-ID": "1", "X-Username": "u", "X-Rights": "a,b", "Authorization": ""}},
+	}
+
+	for _, tt := range tests {
+		f, ctx, _ := newTestContext(t, "a", "b")
+		f.Propagation = tt.propagation
+		h := ctx.ForwardHeaders()
+		if h.Get("X-Request-ID") != ctx.RequestID() {
+			t.Errorf("%s: X-Request-ID = %q", tt.name, h.Get("X-Request-ID"))
+		}
+
+		for name, want := range tt.want {
+			if got := h.Get(name); got != want {
+				t.Errorf("%s: %s = %q, want %q", tt.name, name, got, want)
+			}
+		}
+	}
+}
+
+func TestForwardTokenNotAcceptedAsSession(t *testing.T) {
+	f, ctx, _ := newTestContext(t, "a")
+	f.Propagation = PropagateToken
+	f.AllowBearerToken = true
+
+	auth := ctx.ForwardHeaders().Get("Authorization")
+	if len(auth) < 8 || auth[:7] != "Bearer " {
+		t.Fatalf("Authorization = %q", auth)
+	}
+
+	tests := []struct {
+		name string
+		req  func(*http.Request)
+	}{
+		{"bearer", func(r *http.Request) { r.Header.Set("Authorization", auth) }},
+		{"cookie", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: f.JWTCookieName, Value: auth[7:]}) }},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		tt.req(r)
+		if token, err := f.ReadToken(r); err != ErrNotSessionToken {
+			t.Errorf("%s: got %v, %v, want ErrNotSessionToken", tt.name, token, err)
+		}
+	}
+}
+
+func TestSessionVarsWithoutClaim(t *testing.T) {
+	_, ctx, _ := newTestContext(t)
+	delete(ctx.token.Claims, "vars")
+
+	if _, ok := ctx.GetSession("k"); ok {
+		t.Fatal("GetSession found a value")
+	}
+
+	if ctx.HasSession("k") {
+		t.Fatal("HasSession found a value")
+	}
+
+	ctx.DeleteSession("k")
+	ctx.PutSession("k", "v")
+	if v, ok := ctx.GetSession("k"); !ok || v != "v" {
+		t.Fatalf("GetSession = %v, %t", v, ok)
+	}
+}
@@ -1,24 +1,39 @@
 package chopshop
 
 import (
+	stdcontext "context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
+	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/alderanalytics/snitch"
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/context"
-	"github.com/twinj/uuid"
+	"github.com/gorilla/mux"
 )
 
 // Errors produced by the framework
 var (
 	ErrModelIDNotPresent          = errors.New("route missing model id")
+	ErrRouteVarNotPresent         = errors.New("route variable not present")
+	ErrQueryVarNotPresent         = errors.New("query variable not present")
 	ErrUnexpectedJWTSigningMethod = errors.New("unexpected JWT signing method")
 	ErrInvalidJWT                 = errors.New("invalid JWT")
+	ErrSessionNotAuthenticated    = errors.New("Session not authenticated.")
+	ErrTokenRevoked               = errors.New("token issued before revocation")
+	ErrUnknownSigningKey          = errors.New("unknown JWT signing key")
+	ErrReservedClaim              = errors.New("claim is reserved")
+	ErrInvalidCookieSignature     = errors.New("invalid cookie signature")
+	ErrUnknownRoute               = errors.New("unknown route")
+	ErrNotSessionToken            = errors.New("token is not a session token")
 )
 
 type key int
@@ -35,123 +50,592 @@ type Framework struct {
 	IssuerName       string
 	SessionDuration  time.Duration
 	ErrorReporter    snitch.ErrorReporter
+	ErrorLog         *log.Logger
 	CookieDomain     string
-	jwtCookieName    string
-	xsrfCookieName   string
-	userCookieName   string
 	DefaultErrorText string
 	RightRevealError string
+
+	// JWTCookieName, XSRFCookieName and UserCookieName name the session
+	// cookies. NewFramework derives them from the issuer as _<issuer>_token,
+	// _<issuer>_xsrf and _<issuer>_user; they may be overridden to avoid
+	// collisions between applications sharing a domain.
+	JWTCookieName  string
+	XSRFCookieName string
+	UserCookieName string
+
+	// SignUserCookie appends an HMAC signature, keyed with SessionSecret, to
+	// the user cookie (see RequestContext.SetSignedBase64JSONCookie), so that
+	// the server may trust its contents. It changes the cookie's format from
+	// plain base64 encoded json, which clients reading the cookie must expect.
+	// If SessionSecret is empty the cookie cannot be signed; the error is
+	// reported and the cookie omitted.
+	SignUserCookie bool
+
+	// UnauthorizedResponse, if set, produces the response returned by the
+	// middleware when a request is not authorized. By default
+	// EmptyJSONResponse(401) is returned.
+	UnauthorizedResponse func(*RequestContext) Response
+
+	// ForbiddenResponse, if set, produces the response returned by the
+	// middleware when an authenticated request lacks a required right. By
+	// default EmptyJSONResponse(403) is returned.
+	ForbiddenResponse func(*RequestContext) Response
+
+	// PayloadTooLargeResponse, HeadersTooLargeResponse and
+	// UnsupportedMediaTypeResponse, if set, produce the responses returned by
+	// middleware enforcing limits on the request. By default an ErrorMessage
+	// with status 413, 431 and 415 respectively is returned.
+	PayloadTooLargeResponse      func(*RequestContext) Response
+	HeadersTooLargeResponse      func(*RequestContext) Response
+	UnsupportedMediaTypeResponse func(*RequestContext) Response
+
+	// AuditLogger, if set, records logins, logouts, changes to rights and
+	// the access decisions of RightCheckMiddleware.
+	AuditLogger AuditLogger
+
+	// PanicResponse, if set, produces the response served when a handler
+	// panics. ref is the request id, which is included in the error report
+	// so that it may be quoted to support staff. By default a JSON 500 error
+	// response is served.
+	PanicResponse func(ctx *RequestContext, recovered interface{}, ref string) Response
+
+	// TokensInvalidBefore, if set, returns the time before which tokens
+	// authenticating the given user are no longer accepted, such as the
+	// time of the user's last password change, or the zero time if all are.
+	TokensInvalidBefore func(userID uint64) time.Time
+
+	// BeforeHandler, if set, is called with the request context before the
+	// handler and its middleware, for setup common to every request. If it
+	// returns a non-nil Response, that is served and the handler is skipped.
+	BeforeHandler func(*RequestContext) Response
+
+	// AfterResponse, if set, is called once the response has been written, with
+	// its status, for cleanup and logging. It is called even if the handler
+	// panicked, after the panic response has been written.
+	AfterResponse func(ctx *RequestContext, status int)
+
+	// UserLoader, if set, loads the record of the authenticated user for
+	// RequestContext.User.
+	UserLoader func(ctx *RequestContext, id uint64) (interface{}, error)
+
+	// UserAgentParser, if set, parses the DeviceInfo of requests in place of
+	// the built-in heuristics.
+	UserAgentParser UserAgentParser
+
+	// AllowBearerToken permits clients without the session cookie to present
+	// the session token in an "Authorization: Bearer" header instead. The
+	// cookie takes precedence when both are present.
+	AllowBearerToken bool
+
+	// IsAPIRequest, if set, determines whether a request was made by an API
+	// client rather than a browser, overriding the default detection of
+	// RequestContext.IsAPIRequest.
+	IsAPIRequest func(*RequestContext) bool
+
+	// JSONAcceptIsAPI treats requests which accept application/json and carry
+	// no session cookie as API requests, so that API clients need not send an
+	// Authorization header to sign in. As a browser script's first request may
+	// look the same, it should not be set by applications serving browsers.
+	JSONAcceptIsAPI bool
+
+	// TenantErrorResponse, if set, produces the response returned by
+	// TenantMiddleware when the tenant cannot be resolved. By default a 404
+	// error response is returned.
+	TenantErrorResponse func(*RequestContext, error) Response
+
+	// ErrorTemplate, if set, renders the error responses of
+	// RequestContext.ErrorResponse and CustomErrorResponse as HTML pages for
+	// clients preferring HTML to JSON, such as browsers. The template named
+	// after the status, such as "404", is executed if defined, and otherwise
+	// the one named "error", with an ErrorPage.
+	ErrorTemplate *template.Template
+
+	// SigningMethod, SigningKey and VerifyKey configure how session tokens are
+	// signed and verified, allowing an asymmetric method such as RS256 to be
+	// used so that other services may verify tokens with only the public key.
+	// By default tokens are signed with HS512 using SessionSecret, and if
+	// VerifyKey is nil, SigningKey is used for verification as suits HMAC.
+	SigningMethod jwt.SigningMethod
+	SigningKey    interface{}
+	VerifyKey     interface{}
+
+	// SigningKeyID, if set, identifies the signing key in the kid header of
+	// new tokens. Tokens carrying another kid are verified with the matching
+	// key from VerifyKeys, allowing the signing key to be rotated without
+	// invalidating existing sessions: they remain valid until their key is
+	// removed from VerifyKeys or they expire.
+	SigningKeyID string
+	VerifyKeys   map[string]interface{}
+
+	// VerificationSecrets are further HMAC secrets, tried in order, with which
+	// a token failing verification may have been signed. They allow
+	// SessionSecret to be rotated for tokens which carry no kid header. New
+	// tokens are always signed with the primary key.
+	VerificationSecrets [][]byte
+
+	// URLSigningKey is the key used by SignedURL. If empty, SessionSecret is
+	// used instead; if both are empty, URLs cannot be signed.
+	URLSigningKey []byte
+
+	// Propagation selects how RequestContext.ForwardHeaders conveys the
+	// principal to downstream services, and ForwardTokenDuration the lifetime
+	// of forwarded tokens.
+	Propagation          PrincipalPropagation
+	ForwardTokenDuration time.Duration
+
+	// MaxRequestBody is the maximum size, in bytes, of a request body after
+	// any decompression. If zero, DefaultMaxRequestBody is used.
+	MaxRequestBody int64
+
+	// LaxJSONContentType disables the check that request bodies read by
+	// RequestContext.ReadJSON and ReadJSONUnsafe declare a JSON Content-Type,
+	// for legacy clients which send JSON under another type.
+	LaxJSONContentType bool
+
+	// UseJSONNumber decodes numbers in request bodies which are read into
+	// interface{} values, such as those of a map[string]interface{}, as
+	// json.Number rather than float64, so that 64-bit ids keep their
+	// precision.
+	UseJSONNumber bool
+
+	// MaxJSONDepth is the maximum nesting depth of a JSON request body. If
+	// zero, DefaultMaxJSONDepth is used.
+	MaxJSONDepth int
+
+	// MaxJSONTokens is the maximum number of tokens (values, keys and
+	// delimiters) in a JSON request body. If zero, the number is unlimited.
+	MaxJSONTokens int
+
+	// PreserveFieldOrder serializes structs with their fields in declaration
+	// order rather than sorted by key.
+	PreserveFieldOrder bool
+
+	// TimeFormat determines how time.Time values are encoded by JSONResponse
+	// and decoded by ReadJSON: TimeFormatUnix, TimeFormatUnixMilli or a layout
+	// accepted by time.Format. If empty, times are encoded as RFC 3339 strings
+	// with fractional seconds.
+	TimeFormat string
+
+	// DurationFormat determines how time.Duration values are encoded by
+	// JSONResponse and decoded by ReadJSON: DurationFormatNanoseconds (the
+	// default), DurationFormatSeconds or DurationFormatString.
+	DurationFormat string
+
+	// Clock, if set, provides the current time in place of time.Now, and NewID
+	// generates session and request identifiers in place of random UUIDs.
+	// They allow tests to observe deterministic tokens and cookies (see
+	// TestClock and TestIDGen).
+	Clock func() time.Time
+	NewID func() string
+
+	sessionsValidAfter atomic.Value
+	strictSlash        bool
+
+	routeTypes      map[*mux.Route]routeTypes
+	routeMiddleware map[*mux.Route]Middleware
+	decoders        map[string]BodyDecoder
+	encoders        map[string]BodyEncoder
+
+	contentEncodings []contentEncoding
+	routeStats       routeStatsRegistry
+
 	*Router
 }
 
-// PanicMonitor reports unhandled panics and optionally repanics
+// PanicError wraps a value recovered from a panicking request with the context
+// in which the panic occurred.
+type PanicError struct {
+	Value     interface{}
+	RequestID string
+	Route     string
+	reported  bool
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic in request %s (%s): %v", e.RequestID, e.Route, e.Value)
+}
+
+// PanicMonitor reports unhandled panics and optionally repanics. A
+// *PanicError which has already been reported is not reported again.
 func (f *Framework) PanicMonitor(repanic bool) {
 	if err := recover(); err != nil {
-		if f.ErrorReporter == nil {
-			return
+		if perr, ok := err.(*PanicError); !ok || !perr.reported {
+			f.Notify(&snitch.ErrorContext{
+				Error: fmt.Sprintf("panic: %s", err),
+			})
 		}
 
-		ectx := snitch.ErrorContext{
-			Error: fmt.Sprintf("panic: %s", err),
+		if repanic {
+			panic(err)
 		}
+	}
+}
 
-		f.Notify(&ectx)
+// PanicMonitorContext reports unhandled panics along with the details of the
+// request context and optionally repanics with a *PanicError carrying the
+// request id and route. The panic is reported exactly once, however many
+// monitors it passes through. If it does not repanic, the response produced by
+// Framework.PanicResponse is served.
+func (f *Framework) PanicMonitorContext(ctx *RequestContext, repanic bool) {
+	if err := recover(); err != nil {
+		perr, ok := err.(*PanicError)
+		if !ok {
+			perr = &PanicError{
+				Value:     err,
+				RequestID: ctx.RequestID(),
+				Route:     ctx.RouteTemplate(),
+			}
+		}
+
+		if !perr.reported {
+			var ectx snitch.ErrorContext
+			ctx.errorMakeErrorContext(perr, http.StatusInternalServerError, &ectx)
+			ectx.Error = fmt.Sprintf("panic: %s", perr.Value)
+			ectx.Details["route"] = perr.Route
+			f.Notify(&ectx)
+			perr.reported = true
+		}
 
 		if repanic {
-			panic(err)
+			panic(perr)
 		}
+
+		ctx.PanicResponse(perr.Value, perr.RequestID).ServeHTTP(ctx.ResponseWriter, ctx.Request)
 	}
 }
 
 // Notify invokes the attached error reporting service, if any,
-// provided that the ErrorContext pointer is not nil
+// provided that the ErrorContext pointer is not nil. A panic within the
+// reporter is contained and logged to ErrorLog.
 func (f *Framework) Notify(ectx *snitch.ErrorContext) {
 	if f.ErrorReporter != nil && ectx != nil {
+		defer func() {
+			if err := recover(); err != nil {
+				f.logf("chopshop: error reporter panicked: %v (while reporting %q)", err, ectx.Error)
+			}
+		}()
+
 		f.ErrorReporter.Notify(ectx)
 	}
 }
 
+// logf logs to ErrorLog, or the standard logger if it is nil.
+func (f *Framework) logf(format string, args ...interface{}) {
+	if f.ErrorLog != nil {
+		f.ErrorLog.Printf(format, args...)
+		return
+	}
+
+	log.Printf(format, args...)
+}
+
 // Host returns a route which matches only a specific host.
 func (f *Framework) Host(host string) *Router {
 	return wrapRouter(f.Router.r.Host(host).Subrouter(), f, nil)
 }
 
+// HostVars returns a router which matches hosts against a pattern containing
+// variables, such as "{subdomain}.example.com". The matched host variables are
+// available through RequestContext.RouteVar alongside those of the path. A
+// path variable may not share its name with a host variable; routes which do
+// so panic when their handler is mounted.
+func (f *Framework) HostVars(hostPattern string) *Router {
+	return f.Host(hostPattern)
+}
+
+// URL builds the URL of the route given the name by Route.Name, substituting
+// its variables from pairs of names and values, such as URL("user", "id",
+// "42"). It returns ErrUnknownRoute if no route has the name, or an error if a
+// variable is missing or does not match its pattern.
+func (f *Framework) URL(name string, pairs ...string) (*url.URL, error) {
+	route := f.Router.r.Get(name)
+	if route == nil {
+		return nil, ErrUnknownRoute
+	}
+
+	return route.URL(pairs...)
+}
+
+// FrameworkOption configures a framework as NewFramework constructs it.
+type FrameworkOption func(*Framework)
+
+// StrictSlash sets whether a path with a trailing slash and the same path
+// without one are treated as the same route, with requests for the variant
+// not registered redirected (with a 301) to the one that is. It is off by
+// default, so that "/foo" and "/foo/" are distinct routes.
+func StrictSlash(value bool) FrameworkOption {
+	return func(f *Framework) {
+		f.strictSlash = value
+	}
+}
+
 // NewFramework constructs a new framework.
-func NewFramework(issuer string, cookieDomain string) (*Framework, error) {
+func NewFramework(issuer string, cookieDomain string, opts ...FrameworkOption) (*Framework, error) {
 	f := &Framework{
 		IssuerName:       issuer,
 		CookieDomain:     cookieDomain,
-		jwtCookieName:    fmt.Sprintf("_%s_token", issuer),
-		xsrfCookieName:   fmt.Sprintf("_%s_xsrf", issuer),
-		userCookieName:   fmt.Sprintf("_%s_user", issuer),
+		JWTCookieName:    fmt.Sprintf("_%s_token", issuer),
+		XSRFCookieName:   fmt.Sprintf("_%s_xsrf", issuer),
+		UserCookieName:   fmt.Sprintf("_%s_user", issuer),
 		RightRevealError: "RevealError",
 		DefaultErrorText: "An unexpected error has occurred.",
 	}
 
+	for _, opt := range opts {
+		opt(f)
+	}
+
 	f.Router = newRouter(f)
 	return f, nil
 }
 
-// ReadToken reads the JWT token from a cookie and validates its signature.
+// ReadToken reads the JWT token from a cookie, or from the Authorization
+// header if AllowBearerToken is set, and validates its signature. A
+// token is rejected with ErrTokenRevoked if it was issued before
+// SessionsValidAfter or, if it authenticates a user, before the time given by
+// TokensInvalidBefore. It is rejected with ErrNotSessionToken if it carries an
+// audience, as forwarded tokens do, or names another issuer. An expired token
+// is treated as absent, so that a new anonymous session is started.
 func (f *Framework) ReadToken(r *http.Request) (*jwt.Token, error) {
-	tokenCookie, err := r.Cookie(f.jwtCookieName)
-	if err == http.ErrNoCookie {
+	tokenStr, _ := f.tokenString(r)
+	if tokenStr == "" {
 		return nil, nil
 	}
 
-	parser := jwt.Parser{UseJSONNumber: true}
-	token, err := parser.Parse(tokenCookie.Value,
-		func(token *jwt.Token) (interface{}, error) {
+	token, err := f.parseToken(tokenStr, f.verifyKeyFor)
+	for _, secret := range f.VerificationSecrets {
+		if verr, ok := err.(*jwt.ValidationError); !ok || verr.Errors&jwt.ValidationErrorSignatureInvalid == 0 {
+			break
+		}
+
+		secret := secret
+		token, err = f.parseToken(tokenStr, func(token *jwt.Token) (interface{}, error) {
 			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 				return nil, ErrUnexpectedJWTSigningMethod
 			}
 
-			return f.SessionSecret, nil
+			return secret, nil
 		})
+	}
 
 	if err != nil {
 		return nil, err
 	}
 
+	if expiresAt, ok := claimTime(token.Claims, "exp"); ok && !f.now().Before(expiresAt) {
+		return nil, nil
+	}
+
+	if err := f.checkSessionToken(token); err != nil {
+		return nil, err
+	}
+
+	if err := f.checkIssuedAt(token); err != nil {
+		return nil, err
+	}
+
 	return token, nil
 }
 
+// parseToken parses and verifies an encoded token with the key returned by
+// keyFunc, provided that it was signed with the configured signing method.
+func (f *Framework) parseToken(tokenStr string, keyFunc jwt.Keyfunc) (*jwt.Token, error) {
+	parser := jwt.Parser{UseJSONNumber: true}
+	token, err := parser.Parse(tokenStr,
+		func(token *jwt.Token) (interface{}, error) {
+			if token.Method.Alg() != f.signingMethod().Alg() {
+				return nil, ErrUnexpectedJWTSigningMethod
+			}
+
+			return keyFunc(token)
+		})
+
+	// jwt-go checks exp against the wall clock; defer to f.now() instead
+	if verr, ok := err.(*jwt.ValidationError); ok && verr.Errors == jwt.ValidationErrorExpired {
+		err = nil
+	}
+
+	return token, err
+}
+
+// tokenString returns the encoded session token carried by the request, and
+// whether it was taken from a bearer Authorization header.
+func (f *Framework) tokenString(r *http.Request) (string, bool) {
+	if tokenCookie, err := r.Cookie(f.JWTCookieName); err == nil {
+		return tokenCookie.Value, false
+	}
+
+	if f.AllowBearerToken {
+		auth := r.Header.Get("Authorization")
+		if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+			return strings.TrimSpace(auth[7:]), true
+		}
+	}
+
+	return "", false
+}
+
+// SetSessionsValidAfter invalidates every session whose token was issued
+// before t, forcing all users to authenticate again. It is safe to call while
+// requests are being served.
+func (f *Framework) SetSessionsValidAfter(t time.Time) {
+	f.sessionsValidAfter.Store(t)
+}
+
+// SessionsValidAfter returns the time set by SetSessionsValidAfter, or the zero
+// time if it has not been set.
+func (f *Framework) SessionsValidAfter() time.Time {
+	t, _ := f.sessionsValidAfter.Load().(time.Time)
+	return t
+}
+
+// checkSessionToken returns ErrNotSessionToken unless the token was issued by
+// the framework as a session token. Tokens for other audiences, such as those
+// forwarded to downstream services, are signed with the same key but must not
+// be presented back as sessions.
+func (f *Framework) checkSessionToken(token *jwt.Token) error {
+	if _, ok := token.Claims["aud"]; ok {
+		return ErrNotSessionToken
+	}
+
+	if iss, ok := token.Claims["iss"]; ok && iss != f.IssuerName {
+		return ErrNotSessionToken
+	}
+
+	return nil
+}
+
+// checkIssuedAt rejects a token which was issued before SessionsValidAfter, or
+// which authenticates a user and was issued before the user's tokens were
+// invalidated. As iat has a resolution of a second, the cutoff is truncated to
+// the second so that a token issued just after it is not rejected.
+func (f *Framework) checkIssuedAt(token *jwt.Token) error {
+	cutoff := f.SessionsValidAfter()
+	if f.TokensInvalidBefore != nil {
+		if principal, err := f.readPrincipal(token); err == nil && principal != nil {
+			if userCutoff := f.TokensInvalidBefore(principal.UserID); userCutoff.After(cutoff) {
+				cutoff = userCutoff
+			}
+		}
+	}
+
+	if cutoff.IsZero() {
+		return nil
+	}
+
+	issuedAt, ok := claimTime(token.Claims, "iat")
+	if !ok || issuedAt.Before(cutoff.Truncate(time.Second)) {
+		return ErrTokenRevoked
+	}
+
+	return nil
+}
+
 // BeforeResponse is a hook that fires after the context handler has finished
-// but before the response is sent.
+// but before the response is sent. Session cookies are not set on responses to
+// API requests (see RequestContext.IsAPIRequest), nor to CORS preflight
+// requests, which carry no credentials.
 func (f *Framework) BeforeResponse(ctx *RequestContext) {
+	if isPreflight(ctx.Request) {
+		return
+	}
+
+	apiRequest := ctx.IsAPIRequest()
 	if ctx.destroyingSession {
-		f.DestroySession(ctx.ResponseWriter)
+		if !apiRequest {
+			f.DestroySession(ctx.ResponseWriter)
+		}
 		return
 	}
 
 	ctx.token.Claims["sub"] = ctx.principal
+	f.setExpiry(ctx.token, ctx.requestTime)
+	if apiRequest {
+		return
+	}
+
 	if ctx.principal != nil {
-		ctx.SetBase64JSONCookie(f.userCookieName, map[string]interface{}{
-			"rights": ctx.principal.Rights,
-		})
+		user := map[string]interface{}{"rights": ctx.principal.Rights}
+		if !f.SignUserCookie {
+			ctx.SetBase64JSONCookie(f.UserCookieName, user)
+		} else if err := ctx.SetSignedBase64JSONCookie(f.UserCookieName, user); err != nil {
+			ctx.NotifyError(err, http.StatusInternalServerError)
+		}
 	} else {
-		f.DeleteCookie(ctx.ResponseWriter, f.userCookieName)
+		f.DeleteCookie(ctx.ResponseWriter, f.UserCookieName)
 	}
 
 	f.SendToken(ctx.ResponseWriter, ctx.token)
-	ctx.SetCookie(f.xsrfCookieName, ctx.XSRFToken(), false)
+	ctx.SetCookie(f.XSRFCookieName, ctx.XSRFToken(), false)
+}
+
+// signToken signs the jwt with the configured signing method and key.
+func (f *Framework) signToken(token *jwt.Token) (string, error) {
+	token.Method = f.signingMethod()
+	token.Header["alg"] = token.Method.Alg()
+	if f.SigningKeyID != "" {
+		token.Header["kid"] = f.SigningKeyID
+	} else {
+		delete(token.Header, "kid")
+	}
+	return token.SignedString(f.signingKey())
+}
+
+func (f *Framework) signingMethod() jwt.SigningMethod {
+	if f.SigningMethod == nil {
+		return jwt.SigningMethodHS512
+	}
+
+	return f.SigningMethod
+}
+
+func (f *Framework) signingKey() interface{} {
+	if f.SigningKey == nil {
+		return f.SessionSecret
+	}
+
+	return f.SigningKey
+}
+
+func (f *Framework) verifyKey() interface{} {
+	if f.VerifyKey == nil {
+		return f.signingKey()
+	}
+
+	return f.VerifyKey
+}
+
+// verifyKeyFor returns the key with which to verify a token, selected by its
+// kid header.
+func (f *Framework) verifyKeyFor(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" || kid == f.SigningKeyID && f.VerifyKeys[kid] == nil {
+		return f.verifyKey(), nil
+	}
+
+	key, ok := f.VerifyKeys[kid]
+	if !ok {
+		return nil, ErrUnknownSigningKey
+	}
+
+	return key, nil
 }
 
 // SendToken signs and sends the associated jwt to the client.
 func (f *Framework) SendToken(w http.ResponseWriter, token *jwt.Token) error {
-	tokenStr, err := token.SignedString(f.SessionSecret)
+	tokenStr, err := f.signToken(token)
 	if err != nil {
 		return err
 	}
 
 	http.SetCookie(w, &http.Cookie{
-		Name:     f.jwtCookieName,
+		Name:     f.JWTCookieName,
 		Domain:   f.CookieDomain,
 		Value:    tokenStr,
 		HttpOnly: true,
 		Secure:   f.HTTPSOnlyCookies,
 		Path:     "/",
-		Expires:  time.Now().Add(f.SessionDuration),
+		Expires:  f.now().Add(f.SessionDuration),
 	})
 
 	return nil
@@ -160,9 +644,9 @@ func (f *Framework) SendToken(w http.ResponseWriter, token *jwt.Token) error {
 // DestroySession deletes the xsrf and jwt tokens corresponding to the
 // framework IssuerName.
 func (f *Framework) DestroySession(w http.ResponseWriter) {
-	f.DeleteCookie(w, f.xsrfCookieName)
-	f.DeleteCookie(w, f.jwtCookieName)
-	f.DeleteCookie(w, f.userCookieName)
+	f.DeleteCookie(w, f.XSRFCookieName)
+	f.DeleteCookie(w, f.JWTCookieName)
+	f.DeleteCookie(w, f.UserCookieName)
 }
 
 // DeleteCookie deletes a cookie.
@@ -217,8 +701,11 @@ func (f *Framework) CreateRequestContext(w http.ResponseWriter, r *http.Request)
 		token = nil
 	}
 
+	var bearerToken bool
 	if token == nil {
 		token = f.buildToken()
+	} else {
+		_, bearerToken = f.tokenString(r)
 	}
 
 	principal, err := f.readPrincipal(token)
@@ -232,7 +719,9 @@ func (f *Framework) CreateRequestContext(w http.ResponseWriter, r *http.Request)
 		token:          token,
 		principal:      principal,
 		framework:      f,
-		requestTime:    time.Now(),
+		requestTime:    f.now(),
+		requestID:      f.newID(),
+		bearerToken:    bearerToken,
 	}, nil
 }
 
@@ -242,23 +731,41 @@ func (f *Framework) ContextFor(r *http.Request) *RequestContext {
 		return val.(*RequestContext)
 	}
 
+	// The router may pass a shallow copy of the request to the handler, which
+	// carries the context only through its standard library context.
+	if ctx, ok := r.Context().Value(keyRequestContext).(*RequestContext); ok {
+		return ctx
+	}
+
 	return nil
 }
 
 func (f *Framework) buildToken() *jwt.Token {
-	token := jwt.New(jwt.SigningMethodHS512)
+	token := jwt.New(f.signingMethod())
 	token.Claims["iss"] = f.IssuerName
 	token.Claims["sub"] = nil
-	token.Claims["jti"] = uuid.NewV4().String()
-	token.Claims["iat"] = time.Since(time.Unix(0, 0)).Seconds()
+	token.Claims["jti"] = f.newID()
+	token.Claims["iat"] = f.now().Unix()
 	token.Claims["vars"] = make(map[string]interface{})
+	f.setExpiry(token, f.now())
 	return token
 }
 
+// setExpiry sets the exp claim of token to SessionDuration after t. Tokens do
+// not expire if SessionDuration is zero.
+func (f *Framework) setExpiry(token *jwt.Token, t time.Time) {
+	if f.SessionDuration > 0 {
+		token.Claims["exp"] = t.Add(f.SessionDuration).Unix()
+	}
+}
+
 // ServeHTTP adapts Framework for use as an http.Handler
 func (f *Framework) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer f.PanicMonitor(false)
 
+	sw := &statusWriter{ResponseWriter: w}
+	w = sw
+
 	ctx, err := f.CreateRequestContext(w, r)
 	if err != nil {
 		f.DestroySession(w)
@@ -266,16 +773,54 @@ func (f *Framework) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	r = r.WithContext(stdcontext.WithValue(r.Context(), keyRequestContext, ctx))
+	ctx.Request = r
+
 	context.Set(r, keyRequestContext, ctx)
 	defer context.Clear(r)
+	defer f.afterResponse(ctx, sw)
+	defer f.PanicMonitorContext(ctx, false)
 
 	f.Router.ServeHTTP(w, r)
 }
 
+// afterResponse invokes the AfterResponse hook, if any, with the status of the
+// response written.
+func (f *Framework) afterResponse(ctx *RequestContext, sw *statusWriter) {
+	if f.AfterResponse == nil {
+		return
+	}
+
+	status := sw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	f.AfterResponse(ctx, status)
+}
+
 // ServeContext serves the request by applying the ContextHandlerFunc to the
-// current context.
+// current context, after the BeforeHandler hook if one is set. Errors recorded
+// with AddError are reported once the response has been served. If the
+// request's context is cancelled, such as by the client disconnecting, before
+// the handler returns, its response is cancelled rather than served.
 func (f *Framework) ServeContext(ctx *RequestContext, fn ContextHandlerFunc) {
-	response := fn(ctx)
+	defer ctx.flushErrors()
+
+	var response Response
+	if f.BeforeHandler != nil {
+		response = f.BeforeHandler(ctx)
+	}
+
+	if response == nil {
+		response = fn(ctx)
+	}
+
+	if ctx.Context().Err() != nil {
+		response.Cancel()
+		return
+	}
+
 	f.BeforeResponse(ctx)
 	response.ServeHTTP(ctx.ResponseWriter, ctx.Request)
 }
@@ -294,3 +839,24 @@ func jsonNumberToUint64(n json.Number) (val uint64, err error) {
 	val, err = strconv.ParseUint(n.String(), 10, 64)
 	return
 }
+
+// claimTime reads a claim holding seconds since the epoch.
+func claimTime(claims map[string]interface{}, key string) (time.Time, bool) {
+	var sec int64
+	switch v := claims[key].(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		sec = int64(f)
+	case float64:
+		sec = int64(v)
+	case int64:
+		sec = v
+	default:
+		return time.Time{}, false
+	}
+
+	return time.Unix(sec, 0), true
+}
@@ -0,0 +1,774 @@
+package chopshop
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alderanalytics/snitch"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestSessionLifecycle(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewTestClock(start)
+
+	f, _ := NewFramework("app", "")
+	f.SessionSecret = []byte("secret")
+	f.SessionDuration = time.Hour
+	f.Clock, f.NewID = clock.Now, NewTestIDGen("id").Next
+
+	f.Path("/login").Handler(func(ctx *RequestContext) Response {
+		ctx.SetPrincipal("bob", 1, []string{"a"})
+		return BlankResponse(http.StatusNoContent)
+	})
+	f.Path("/me").Handler(func(ctx *RequestContext) Response {
+		return JSONResponse(ctx.Username())
+	})
+	f.Path("/grant").Handler(func(ctx *RequestContext) Response {
+		ctx.AddRight("b")
+		return BlankResponse(http.StatusNoContent)
+	})
+	f.Path("/logout").Handler(func(ctx *RequestContext) Response {
+		ctx.DestroySession()
+		return BlankResponse(http.StatusNoContent)
+	})
+
+	type cookie struct {
+		value    string
+		expires  time.Time
+		httpOnly bool
+	}
+
+	expired := time.Unix(1, 0).UTC()
+	tests := []struct {
+		path    string
+		status  int
+		body    string
+		cookies map[string]cookie // the token cookie's value is checked by claims
+		claims  string
+	}{
+		{
+			path:   "/login",
+			status: http.StatusNoContent,
+			cookies: map[string]cookie{
+				"_app_token": {expires: start.Add(time.Hour), httpOnly: true},
+				"_app_xsrf":  {value: "id-1", expires: start.Add(time.Hour)},
+				"_app_user":  {value: "eyJyaWdodHMiOlsiYSJdfQ==", expires: start.Add(time.Hour)},
+			},
+			claims: `{"auth_time":1577836800,"exp":1577840400,"iat":1577836800,"iss":"app","jti":"id-1",` +
+				`"sub":{"rights":["a"],"user_id":1,"username":"bob"},"vars":{}}`,
+		},
+		{
+			path:   "/me",
+			status: http.StatusOK,
+			body:   "\"bob\"\n",
+			cookies: map[string]cookie{
+				"_app_token": {expires: start.Add(time.Hour + time.Minute), httpOnly: true},
+				"_app_xsrf":  {value: "id-1", expires: start.Add(time.Hour + time.Minute)},
+				"_app_user":  {value: "eyJyaWdodHMiOlsiYSJdfQ==", expires: start.Add(time.Hour + time.Minute)},
+			},
+			claims: `{"auth_time":1577836800,"exp":1577840460,"iat":1577836800,"iss":"app","jti":"id-1",` +
+				`"sub":{"rights":["a"],"user_id":1,"username":"bob"},"vars":{}}`,
+		},
+		{
+			path:   "/grant",
+			status: http.StatusNoContent,
+			cookies: map[string]cookie{
+				"_app_token": {expires: start.Add(time.Hour + 2*time.Minute), httpOnly: true},
+				"_app_xsrf":  {value: "id-1", expires: start.Add(time.Hour + 2*time.Minute)},
+				"_app_user":  {value: "eyJyaWdodHMiOlsiYSIsImIiXX0=", expires: start.Add(time.Hour + 2*time.Minute)},
+			},
+			claims: `{"auth_time":1577836800,"exp":1577840520,"iat":1577836800,"iss":"app","jti":"id-1",` +
+				`"sub":{"rights":["a","b"],"user_id":1,"username":"bob"},"vars":{}}`,
+		},
+		{
+			path:   "/logout",
+			status: http.StatusNoContent,
+			cookies: map[string]cookie{
+				"_app_token": {expires: expired},
+				"_app_xsrf":  {expires: expired},
+				"_app_user":  {expires: expired},
+			},
+		},
+	}
+
+	var jar []*http.Cookie
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", tt.path, nil)
+		for _, c := range jar {
+			r.AddCookie(c)
+		}
+		f.ServeHTTP(w, r)
+
+		if w.Code != tt.status || w.Body.String() != tt.body {
+			t.Fatalf("%s: got %d %q, want %d %q", tt.path, w.Code, w.Body.String(), tt.status, tt.body)
+		}
+
+		got := responseCookies(w)
+		if len(got) != len(tt.cookies) {
+			t.Fatalf("%s: got cookies %v", tt.path, w.Header()["Set-Cookie"])
+		}
+
+		for name, want := range tt.cookies {
+			c, ok := got[name]
+			if !ok {
+				t.Fatalf("%s: cookie %s not set", tt.path, name)
+			}
+
+			if name != "_app_token" && c.Value != want.value {
+				t.Errorf("%s: cookie %s = %q, want %q", tt.path, name, c.Value, want.value)
+			}
+
+			if !c.Expires.Equal(want.expires) || c.HttpOnly != want.httpOnly {
+				t.Errorf("%s: cookie %s expires %s (HttpOnly %t), want %s (%t)",
+					tt.path, name, c.Expires, c.HttpOnly, want.expires, want.httpOnly)
+			}
+		}
+
+		jar = jar[:0]
+		for _, c := range got {
+			if c.Value != "" {
+				jar = append(jar, c)
+			}
+		}
+
+		token, err := f.ReadToken(reqWith(jar))
+		if err != nil {
+			t.Fatalf("%s: %s", tt.path, err)
+		}
+
+		switch {
+		case tt.claims == "" && token != nil:
+			t.Errorf("%s: got claims %v, want no token", tt.path, token.Claims)
+		case tt.claims != "":
+			claims, _ := json.Marshal(token.Claims)
+			if string(claims) != tt.claims {
+				t.Errorf("%s: got claims %s, want %s", tt.path, claims, tt.claims)
+			}
+		}
+
+		clock.Advance(time.Minute)
+	}
+}
+
+func TestCheckIssuedAt(t *testing.T) {
+	cutoff := time.Unix(1000, 500*int64(time.Millisecond))
+	bob := map[string]interface{}{"username": "bob", "user_id": json.Number("9"), "rights": []interface{}{}}
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		user   time.Time
+		want   error
+	}{
+		{"same second", map[string]interface{}{"iat": json.Number("1000")}, time.Time{}, nil},
+		{"later", map[string]interface{}{"iat": json.Number("1001")}, time.Time{}, nil},
+		{"earlier", map[string]interface{}{"iat": json.Number("999")}, time.Time{}, ErrTokenRevoked},
+		{"missing", map[string]interface{}{}, time.Time{}, ErrTokenRevoked},
+		{
+			"user cutoff",
+			map[string]interface{}{"iat": json.Number("2000"), "sub": bob},
+			time.Unix(2000, 900*int64(time.Millisecond)),
+			nil,
+		},
+		{
+			"revoked user",
+			map[string]interface{}{"iat": json.Number("2000"), "sub": bob},
+			time.Unix(2001, 0),
+			ErrTokenRevoked,
+		},
+	}
+
+	for _, tt := range tests {
+		f, _ := NewFramework("app", "")
+		f.SetSessionsValidAfter(cutoff)
+		f.TokensInvalidBefore = func(uint64) time.Time { return tt.user }
+
+		token := jwt.New(jwt.SigningMethodHS256)
+		token.Claims = tt.claims
+		if err := f.checkIssuedAt(token); err != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestPanicMonitorContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		repanic bool
+	}{
+		{"repanic", true},
+		{"respond", false},
+	}
+
+	for _, tt := range tests {
+		f, ctx, w := newTestContext(t)
+		reporter := newErrorRecorder()
+		f.ErrorReporter = reporter
+
+		var recovered interface{}
+		func() {
+			defer func() { recovered = recover() }()
+			defer f.PanicMonitor(true)
+			defer f.PanicMonitorContext(ctx, tt.repanic)
+			defer f.PanicMonitorContext(ctx, true)
+			panic("boom")
+		}()
+
+		if len(reporter.errors) != 1 || reporter.errors[0].Error != "panic: boom" {
+			t.Fatalf("%s: reported %d times", tt.name, len(reporter.errors))
+		}
+
+		if !tt.repanic {
+			if recovered != nil || w.Code != http.StatusInternalServerError {
+				t.Errorf("%s: recovered %v status %d", tt.name, recovered, w.Code)
+			}
+			continue
+		}
+
+		perr, ok := recovered.(*PanicError)
+		if !ok || perr.Value != "boom" || perr.RequestID != ctx.RequestID() {
+			t.Errorf("%s: recovered %#v", tt.name, recovered)
+		}
+	}
+}
+
+// panickingReporter is an ErrorReporter which panics.
+type panickingReporter struct{}
+
+func (panickingReporter) Notify(*snitch.ErrorContext) { panic("bad reporter") }
+
+func TestNotifyContainsReporterPanic(t *testing.T) {
+	tests := []struct {
+		name     string
+		reporter snitch.ErrorReporter
+		ectx     *snitch.ErrorContext
+		log      string
+	}{
+		{"panicking reporter", panickingReporter{}, &snitch.ErrorContext{Error: "x"}, `chopshop: error reporter panicked: bad reporter (while reporting "x")` + "\n"},
+		{"nil context", panickingReporter{}, nil, ""},
+		{"no reporter", nil, &snitch.ErrorContext{Error: "x"}, ""},
+	}
+
+	for _, tt := range tests {
+		f, _ := NewFramework("app", "")
+		var buf bytes.Buffer
+		f.ErrorLog = log.New(&buf, "", 0)
+		f.ErrorReporter = tt.reporter
+
+		f.Notify(tt.ectx)
+		if buf.String() != tt.log {
+			t.Errorf("%s: logged %q, want %q", tt.name, buf.String(), tt.log)
+		}
+	}
+}
+
+func TestPanicResponse(t *testing.T) {
+	var recovered interface{}
+	var ref string
+	custom := func(ctx *RequestContext, v interface{}, r string) Response {
+		recovered, ref = v, r
+		return ErrorResponse("Please quote reference "+r, http.StatusInternalServerError)
+	}
+
+	tests := []struct {
+		name          string
+		panicResponse func(*RequestContext, interface{}, string) Response
+		quotesRef     bool
+	}{
+		{"default", nil, false},
+		{"custom", custom, true},
+	}
+
+	for _, tt := range tests {
+		f, _ := NewFramework("app", "")
+		f.SessionSecret = []byte("secret")
+		f.NewID = NewTestIDGen("id").Next
+		reporter := newErrorRecorder()
+		f.ErrorReporter = reporter
+		f.PanicResponse = tt.panicResponse
+		f.Path("/").Handler(func(*RequestContext) Response { panic("boom") })
+
+		recovered, ref = nil, ""
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("%s: status %d", tt.name, w.Code)
+		}
+
+		if len(reporter.errors) != 1 {
+			t.Fatalf("%s: reported %d times", tt.name, len(reporter.errors))
+		}
+
+		requestID := reporter.errors[0].Details["request_id"]
+		if strings.Contains(w.Body.String(), fmt.Sprint(requestID)) != tt.quotesRef {
+			t.Errorf("%s: body %q, request id %v", tt.name, w.Body.String(), requestID)
+		}
+
+		if tt.quotesRef && (recovered != "boom" || ref != requestID) {
+			t.Errorf("%s: PanicResponse given %v, %q", tt.name, recovered, ref)
+		}
+	}
+}
+
+func TestSetSessionsValidAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		cutoff time.Duration
+		err    error
+	}{
+		{"not set", 0, nil},
+		{"issued before", time.Hour, ErrTokenRevoked},
+		{"issued after", -time.Millisecond, nil},
+	}
+
+	for _, tt := range tests {
+		clock := NewTestClock(time.Unix(1000, 0))
+		f, _ := NewFramework("app", "")
+		f.SessionSecret = []byte("secret")
+		f.Clock = clock.Now
+
+		w := httptest.NewRecorder()
+		ctx, err := f.CreateRequestContext(w, httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.BeforeResponse(ctx)
+
+		if tt.cutoff != 0 {
+			clock.Advance(time.Hour)
+			f.SetSessionsValidAfter(time.Unix(1000, 0).Add(tt.cutoff))
+		}
+
+		if _, err := f.ReadToken(reqWith((&http.Response{Header: w.Header()}).Cookies())); err != tt.err {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	tests := []struct {
+		name          string
+		duration      time.Duration
+		advance       time.Duration
+		authenticated bool
+	}{
+		{"no expiry", 0, 24 * 365 * time.Hour, true},
+		{"unexpired", time.Hour, 59 * time.Minute, true},
+		{"expired", time.Hour, 61 * time.Minute, false},
+		// exp has a resolution of a second
+		{"at expiry", time.Hour, time.Hour, false},
+	}
+
+	for _, tt := range tests {
+		clock := NewTestClock(time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC))
+		f, _ := NewFramework("app", "")
+		f.SessionSecret = []byte("secret")
+		f.SessionDuration = tt.duration
+		f.Clock = clock.Now
+
+		var authenticated bool
+		f.Path("/login").Handler(func(ctx *RequestContext) Response {
+			ctx.SetPrincipal("bob", 1, nil)
+			return BlankResponse(http.StatusNoContent)
+		})
+		f.Path("/").Handler(func(ctx *RequestContext) Response {
+			authenticated = ctx.IsAuthenticated()
+			return BlankResponse(http.StatusNoContent)
+		})
+
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest("GET", "/login", nil))
+		clock.Advance(tt.advance)
+
+		w2 := httptest.NewRecorder()
+		f.ServeHTTP(w2, reqWith((&http.Response{Header: w.Header()}).Cookies()))
+		if w2.Code != http.StatusNoContent || authenticated != tt.authenticated {
+			t.Errorf("%s: status %d, authenticated %t, want %t", tt.name, w2.Code, authenticated, tt.authenticated)
+		}
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		name                string
+		allow               bool
+		cookie              string
+		bearer              string
+		status              int
+		user                string
+		bearerAuthenticated bool
+	}{
+		{"cookie needs xsrf", true, "cookie", "", http.StatusUnauthorized, "cookie", false},
+		{"bearer", true, "", "header", http.StatusOK, "header", true},
+		{"cookie preferred", true, "cookie", "header", http.StatusUnauthorized, "cookie", false},
+		{"not allowed", false, "", "header", http.StatusUnauthorized, "", false},
+	}
+
+	for _, tt := range tests {
+		f, _ := NewFramework("app", "")
+		f.SessionSecret = []byte("secret")
+		f.AllowBearerToken = tt.allow
+		sign := func(name string) string {
+			token := f.buildToken()
+			token.Claims["sub"] = NewPrincipal(name, 1, []string{})
+			s, _ := f.signToken(token)
+			return s
+		}
+
+		var user string
+		var bearerAuthenticated bool
+		f.Path("/me").Handler(func(ctx *RequestContext) Response {
+			user, bearerAuthenticated = ctx.Username(), ctx.IsBearerAuthenticated()
+			return XSRFMiddleware(func(*RequestContext) Response {
+				return BlankResponse(http.StatusOK)
+			})(ctx)
+		})
+
+		r := httptest.NewRequest("POST", "/me", nil)
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: f.JWTCookieName, Value: sign(tt.cookie)})
+		}
+		if tt.bearer != "" {
+			r.Header.Set("Authorization", "Bearer "+sign(tt.bearer))
+		}
+
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, r)
+		if w.Code != tt.status || user != tt.user || bearerAuthenticated != tt.bearerAuthenticated {
+			t.Errorf("%s: status %d, user %q, bearer %t", tt.name, w.Code, user, bearerAuthenticated)
+		}
+	}
+}
+
+func TestSigningMethod(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+
+	rs256 := func(f *Framework) {
+		f.SigningMethod = jwt.SigningMethodRS256
+		f.SigningKey, f.VerifyKey = key, &key.PublicKey
+	}
+
+	tests := []struct {
+		name      string
+		configure func(*Framework)
+		sign      func(*Framework, *jwt.Token) (string, error)
+		alg       string
+		valid     bool
+	}{
+		{"default", func(*Framework) {}, (*Framework).signToken, "HS512", true},
+		{"rsa", rs256, (*Framework).signToken, "RS256", true},
+		{
+			"hmac forgery", rs256,
+			func(f *Framework, token *jwt.Token) (string, error) {
+				token.Method, token.Header["alg"] = jwt.SigningMethodHS256, "HS256"
+				return token.SignedString(f.SessionSecret)
+			},
+			"", false,
+		},
+		{
+			"other key", rs256,
+			func(f *Framework, token *jwt.Token) (string, error) {
+				token.Method, token.Header["alg"] = jwt.SigningMethodRS256, "RS256"
+				return token.SignedString(otherKey)
+			},
+			"", false,
+		},
+	}
+
+	for _, tt := range tests {
+		f, ctx, _ := newTestContext(t, "user")
+		tt.configure(f)
+		s, err := tt.sign(f, ctx.token)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		token, err := f.ReadToken(reqWith([]*http.Cookie{{Name: f.JWTCookieName, Value: s}}))
+		if valid := err == nil && token != nil; valid != tt.valid {
+			t.Errorf("%s: valid %t, want %t: %v", tt.name, valid, tt.valid, err)
+			continue
+		}
+
+		if tt.valid && token.Header["alg"] != tt.alg {
+			t.Errorf("%s: alg %v, want %s", tt.name, token.Header["alg"], tt.alg)
+		}
+	}
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	// sign returns a token signed with secret and identified by kid
+	sign := func(t *testing.T, kid, secret string) string {
+		f, ctx, _ := newTestContext(t, "user")
+		f.SessionSecret, f.SigningKeyID = []byte(secret), kid
+		s, err := f.signToken(ctx.token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		verifyKeys map[string]interface{}
+		err        error
+	}{
+		{"current key", sign(t, "k2", "new"), nil, nil},
+		{"current key listed", sign(t, "k2", "new"), map[string]interface{}{"k2": []byte("new")}, nil},
+		{"retired key", sign(t, "k1", "old"), map[string]interface{}{"k1": []byte("old")}, nil},
+		{"removed key", sign(t, "k1", "old"), nil, ErrUnknownSigningKey},
+		{"no kid", sign(t, "", "new"), nil, nil},
+	}
+
+	for _, tt := range tests {
+		f, _ := NewFramework("test", "")
+		f.SessionSecret, f.SigningKeyID = []byte("new"), "k2"
+		f.VerifyKeys = tt.verifyKeys
+
+		token, err := f.ReadToken(reqWith([]*http.Cookie{{Name: f.JWTCookieName, Value: tt.token}}))
+		if verr, ok := err.(*jwt.ValidationError); ok && verr.Inner != nil {
+			err = verr.Inner
+		}
+
+		if err != tt.err || err == nil && token == nil {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestCookieNames(t *testing.T) {
+	tests := []struct {
+		name                        string
+		jwt, xsrf, user             string
+		wantJWT, wantXSRF, wantUser string
+	}{
+		{"defaults", "", "", "", "_app_token", "_app_xsrf", "_app_user"},
+		{"configured", "tok", "xs", "usr", "tok", "xs", "usr"},
+	}
+
+	for _, tt := range tests {
+		f, _ := NewFramework("app", "")
+		f.SessionSecret = []byte("secret")
+		if tt.jwt != "" {
+			f.JWTCookieName, f.XSRFCookieName, f.UserCookieName = tt.jwt, tt.xsrf, tt.user
+		}
+
+		var user string
+		f.Path("/login").Handler(func(ctx *RequestContext) Response {
+			ctx.SetPrincipal("bob", 1, nil)
+			return BlankResponse(http.StatusNoContent)
+		})
+		f.Path("/me").Handler(XSRFMiddleware(func(ctx *RequestContext) Response {
+			user = ctx.Username()
+			return BlankResponse(http.StatusNoContent)
+		}))
+		f.Path("/logout").Handler(func(ctx *RequestContext) Response {
+			ctx.DestroySession()
+			return BlankResponse(http.StatusNoContent)
+		})
+
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest("POST", "/login", nil))
+		cookies := responseCookies(w)
+		want := map[string]bool{tt.wantJWT: true, tt.wantXSRF: true, tt.wantUser: true}
+		for name := range want {
+			if cookies[name] == nil || cookies[name].Value == "" {
+				t.Errorf("%s: cookie %s not set", tt.name, name)
+			}
+		}
+
+		if len(cookies) != len(want) {
+			t.Errorf("%s: cookies %v", tt.name, cookies)
+		}
+
+		var sent []*http.Cookie
+		for _, c := range cookies {
+			sent = append(sent, c)
+		}
+
+		r := reqWith(sent)
+		r.Method, r.URL.Path = "POST", "/me"
+		r.Header.Set("X-XSRF-Token", cookies[tt.wantXSRF].Value)
+		f.ServeHTTP(httptest.NewRecorder(), r)
+		if user != "bob" {
+			t.Errorf("%s: session not read from renamed cookies", tt.name)
+		}
+
+		r.URL.Path = "/logout"
+		w = httptest.NewRecorder()
+		f.ServeHTTP(w, r)
+		for name := range responseCookies(w) {
+			if !want[name] {
+				t.Errorf("%s: logout set unexpected cookie %s", tt.name, name)
+			}
+		}
+	}
+}
+
+func TestVerificationSecrets(t *testing.T) {
+	sign := func(t *testing.T, secret string) string {
+		f, ctx, _ := newTestContext(t, "user")
+		f.SessionSecret = []byte(secret)
+		s, err := f.signToken(ctx.token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"primary", sign(t, "new"), true},
+		{"first additional", sign(t, "other"), true},
+		{"second additional", sign(t, "old"), true},
+		{"unlisted", sign(t, "unlisted"), false},
+	}
+
+	for _, tt := range tests {
+		f, _ := NewFramework("test", "")
+		f.SessionSecret = []byte("new")
+		f.VerificationSecrets = [][]byte{[]byte("other"), []byte("old")}
+
+		token, err := f.ReadToken(reqWith([]*http.Cookie{{Name: f.JWTCookieName, Value: tt.token}}))
+		if valid := err == nil && token != nil; valid != tt.valid {
+			t.Errorf("%s: valid %t, want %t: %v", tt.name, valid, tt.valid, err)
+		}
+	}
+}
+
+func TestBeforeHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		order  []string
+	}{
+		{"continue", "", http.StatusNoContent, []string{"before", "handler"}},
+		{"short circuit", "?stop=1", http.StatusTeapot, []string{"before"}},
+	}
+
+	for _, tt := range tests {
+		f, _ := NewFramework("app", "")
+		f.SessionSecret = []byte("secret")
+
+		var order []string
+		f.BeforeHandler = func(ctx *RequestContext) Response {
+			if ctx.RequestID() == "" || ctx.Request == nil {
+				t.Errorf("%s: context not populated", tt.name)
+			}
+
+			order = append(order, "before")
+			ctx.PutSession("seen", true)
+			if ctx.QueryVar("stop") != "" {
+				return BlankResponse(http.StatusTeapot)
+			}
+			return nil
+		}
+		f.Path("/").Handler(func(ctx *RequestContext) Response {
+			order = append(order, "handler")
+			if !ctx.HasSession("seen") {
+				t.Errorf("%s: hook's session change not visible", tt.name)
+			}
+			return BlankResponse(http.StatusNoContent)
+		})
+
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest("GET", "/"+tt.query, nil))
+		if w.Code != tt.status || !reflect.DeepEqual(order, tt.order) {
+			t.Errorf("%s: status %d, order %q", tt.name, w.Code, order)
+		}
+	}
+}
+
+func TestAfterResponse(t *testing.T) {
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/ok", http.StatusOK},
+		{"/teapot", http.StatusTeapot},
+		{"/panic", http.StatusInternalServerError},
+		{"/missing", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		f, _ := NewFramework("app", "")
+		f.SessionSecret = []byte("secret")
+		f.Path("/ok").Handler(func(ctx *RequestContext) Response { return JSONResponse(1) })
+		f.Path("/teapot").Handler(func(ctx *RequestContext) Response { return BlankResponse(http.StatusTeapot) })
+		f.Path("/panic").Handler(func(ctx *RequestContext) Response { panic("boom") })
+
+		w := httptest.NewRecorder()
+		var statuses []int
+		f.AfterResponse = func(ctx *RequestContext, status int) {
+			if w.Code != status {
+				t.Errorf("%s: hook ran before the response was written", tt.path)
+			}
+			statuses = append(statuses, status)
+		}
+
+		f.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if !reflect.DeepEqual(statuses, []int{tt.status}) {
+			t.Errorf("%s: statuses %v, want [%d]", tt.path, statuses, tt.status)
+		}
+	}
+}
+
+func TestURL(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.Path("/users/{id:[0-9]+}").Name("user").Handler(func(ctx *RequestContext) Response {
+		return BlankResponse(http.StatusNoContent)
+	})
+	f.Subrouter("/api").Path("/posts/{slug}").Name("post").Get(func(ctx *RequestContext) Response {
+		return BlankResponse(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name  string
+		route string
+		pairs []string
+		url   string
+		err   bool
+	}{
+		{"route", "user", []string{"id", "42"}, "/users/42", false},
+		{"subrouter", "post", []string{"slug", "hello"}, "/api/posts/hello", false},
+		{"missing var", "user", nil, "", true},
+		{"mismatched var", "user", []string{"id", "abc"}, "", true},
+		{"unknown", "nope", nil, "", true},
+	}
+
+	for _, tt := range tests {
+		u, err := f.URL(tt.route, tt.pairs...)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v", tt.name, err)
+			continue
+		}
+
+		if err == nil && u.String() != tt.url {
+			t.Errorf("%s: url %q, want %q", tt.name, u, tt.url)
+		}
+	}
+
+	if _, err := f.URL("nope"); err != ErrUnknownRoute {
+		t.Errorf("unknown route: error %v, want %v", err, ErrUnknownRoute)
+	}
+}
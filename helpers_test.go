@@ -0,0 +1,72 @@
+package chopshop
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/alderanalytics/snitch"
+)
+
+// newTestContext constructs a framework and a context for a GET request to
+// it, authenticated with the given rights if any are given.
+func newTestContext(t *testing.T, rights ...string) (*Framework, *RequestContext, *httptest.ResponseRecorder) {
+	f, _ := NewFramework("test", "")
+	f.SessionSecret = []byte("secret")
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com/", nil)
+	ctx, err := f.CreateRequestContext(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rights != nil {
+		ctx.SetPrincipal("u", 1, rights)
+	}
+
+	return f, ctx, w
+}
+
+// reqWith constructs a GET request carrying the given cookies.
+func reqWith(cookies []*http.Cookie) *http.Request {
+	r, _ := http.NewRequest("GET", "/", nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+
+	return r
+}
+
+// responseCookies returns the cookies set by a recorded response, by name.
+func responseCookies(w *httptest.ResponseRecorder) map[string]*http.Cookie {
+	cookies := make(map[string]*http.Cookie)
+	for _, c := range (&http.Response{Header: w.Header()}).Cookies() {
+		cookies[c.Name] = c
+	}
+
+	return cookies
+}
+
+// errorRecorder is an ErrorReporter which records the errors it is notified
+// of, signalling notify for each.
+type errorRecorder struct {
+	mu     sync.Mutex
+	errors []*snitch.ErrorContext
+	notify chan struct{}
+}
+
+func newErrorRecorder() *errorRecorder {
+	return &errorRecorder{notify: make(chan struct{}, 100)}
+}
+
+func (r *errorRecorder) Notify(ectx *snitch.ErrorContext) {
+	r.mu.Lock()
+	r.errors = append(r.errors, ectx)
+	r.mu.Unlock()
+
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,31 @@
+package chopshop
+
+import (
+	stdcontext "context"
+	"net/http"
+	"time"
+)
+
+// LongPollResponse constructs a response which waits up to timeout for wait to
+// produce data, serving it as JSON once available or a 204 response if the
+// timeout passes first. The context given to wait is derived from the
+// request's, so it is cancelled if the client disconnects, in which case
+// nothing is written. If wait fails otherwise, a 500 error is served.
+func LongPollResponse(wait func(ctx stdcontext.Context) (interface{}, error), timeout time.Duration) Response {
+	return ResponseFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, cancel := stdcontext.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		v, err := wait(c)
+		switch {
+		case r.Context().Err() != nil:
+			return
+		case err == nil:
+			JSONResponse(v).ServeHTTP(w, r)
+		case c.Err() == stdcontext.DeadlineExceeded:
+			NoContentResponse().ServeHTTP(w, r)
+		default:
+			ErrorResponse("The request could not be completed.", http.StatusInternalServerError).ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package chopshop
+
+import (
+	stdcontext "context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLongPollResponse(t *testing.T) {
+	blocked := func(c stdcontext.Context) (interface{}, error) {
+		<-c.Done()
+		return nil, c.Err()
+	}
+
+	disconnected, cancel := stdcontext.WithCancel(stdcontext.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		wait    func(stdcontext.Context) (interface{}, error)
+		timeout time.Duration
+		ctx     stdcontext.Context
+		status  int
+		body    string
+	}{
+		{
+			name: "data",
+			wait: func(c stdcontext.Context) (interface{}, error) {
+				time.Sleep(5 * time.Millisecond)
+				return map[string]string{"v": "x"}, nil
+			},
+			timeout: time.Second,
+			ctx:     stdcontext.Background(),
+			status:  http.StatusOK,
+			body:    "{\"v\":\"x\"}\n",
+		},
+		{
+			name:    "timeout",
+			wait:    blocked,
+			timeout: 10 * time.Millisecond,
+			ctx:     stdcontext.Background(),
+			status:  http.StatusNoContent,
+		},
+		{
+			name: "failure",
+			wait: func(c stdcontext.Context) (interface{}, error) {
+				return nil, errors.New("failed")
+			},
+			timeout: time.Second,
+			ctx:     stdcontext.Background(),
+			status:  http.StatusInternalServerError,
+		},
+		{
+			name:    "disconnected",
+			wait:    blocked,
+			timeout: time.Second,
+			ctx:     disconnected,
+			status:  http.StatusOK, // nothing written
+		},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil).WithContext(tt.ctx)
+		LongPollResponse(tt.wait, tt.timeout).ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+
+		if tt.status != http.StatusInternalServerError && w.Body.String() != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.name, w.Body.String(), tt.body)
+		}
+	}
+}
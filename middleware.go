@@ -1,7 +1,16 @@
 package chopshop
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
 	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/alderanalytics/snitch"
 )
 
 // Middleware is a function which consumes a ContextHandlerFunc producing a
@@ -36,30 +45,444 @@ func extendMiddleware(mw Middleware, mws ...Middleware) Middleware {
 	return composeMiddleware(append([]Middleware{mw}, mws...)...)
 }
 
-// XSRFMiddleware returns EmptyJSONResponse(401) unless the X-XSRF-Token header
-// is present and its content matches the context XSRF token.
+// isPreflight returns true if the request is a CORS preflight request. As a
+// browser sends preflight requests without credentials, the middleware which
+// authenticates requests passes them through unchecked so that they reach the
+// CORS middleware answering them, wherever it lies in the chain. They never
+// reach the handler of a route which does not serve OPTIONS requests
+// explicitly (see Route.Handler).
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// ExemptPaths constructs a middleware which applies mw except to requests
+// matching any of the patterns, such as login pages, webhooks and health checks.
+// A pattern matches if it equals the path template of the matched route (e.g.
+// "/hooks/{provider}") or if it matches the request path as a glob understood
+// by path.Match (e.g. "/hooks/*").
+func ExemptPaths(mw Middleware, patterns ...string) Middleware {
+	return func(fn ContextHandlerFunc) ContextHandlerFunc {
+		wrapped := mw(fn)
+		return func(ctx *RequestContext) Response {
+			if pathMatches(ctx.Request, patterns) {
+				return fn(ctx)
+			}
+
+			return wrapped(ctx)
+		}
+	}
+}
+
+func pathMatches(r *http.Request, patterns []string) bool {
+	tpl := routeTemplate(r)
+	for _, pattern := range patterns {
+		if pattern == tpl {
+			return true
+		}
+
+		if ok, _ := path.Match(pattern, r.URL.Path); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// XSRFMiddleware returns ctx.UnauthorizedResponse() unless the X-XSRF-Token header
+// is present and its content matches the context XSRF token. Form submissions
+// may instead carry the token in the XSRFFormField field (see
+// RequestContext.CSRFField). CORS preflight requests are passed through (see
+// isPreflight), as are requests authenticated by a bearer token, which
+// browsers do not attach to cross-site requests.
 func XSRFMiddleware(fn ContextHandlerFunc) ContextHandlerFunc {
 	return func(ctx *RequestContext) Response {
+		if isPreflight(ctx.Request) || ctx.IsBearerAuthenticated() {
+			return fn(ctx)
+		}
+
 		xsrfHeader := ctx.Request.Header.Get("X-XSRF-Token")
+		if xsrfHeader == "" && ctx.decodeBody() == nil {
+			xsrfHeader = ctx.Request.PostFormValue(XSRFFormField)
+		}
+
 		if xsrfHeader == "" || ctx.XSRFToken() != xsrfHeader {
-			return EmptyJSONResponse(http.StatusUnauthorized)
+			return ctx.UnauthorizedResponse()
 		}
 
 		return fn(ctx)
 	}
 }
 
+// SignedURLMiddleware returns ctx.ForbiddenResponse() unless the request URL
+// carries a valid and unexpired signature produced by Framework.SignedURL.
+func SignedURLMiddleware(fn ContextHandlerFunc) ContextHandlerFunc {
+	return func(ctx *RequestContext) Response {
+		if err := ctx.framework.VerifySignedURL(ctx.Request.URL); err != nil {
+			return ctx.ForbiddenResponse()
+		}
+
+		return fn(ctx)
+	}
+}
+
+// ContentLengthLimitMiddleware constructs a middleware that returns
+// ctx.PayloadTooLargeResponse() when the declared Content-Length exceeds limit, before
+// any of the body is read. As the header may be absent or dishonest, the body
+// is also limited so that reading past limit bytes fails.
+func ContentLengthLimitMiddleware(limit int64) Middleware {
+	return func(fn ContextHandlerFunc) ContextHandlerFunc {
+		return func(ctx *RequestContext) Response {
+			if ctx.Request.ContentLength > limit {
+				return ctx.PayloadTooLargeResponse()
+			}
+
+			if ctx.Request.Body != nil {
+				ctx.Request.Body = http.MaxBytesReader(ctx.ResponseWriter, ctx.Request.Body, limit)
+			}
+
+			return fn(ctx)
+		}
+	}
+}
+
+// HeaderSizeLimitMiddleware constructs a middleware that returns
+// ctx.HeadersTooLargeResponse() when the request headers, measured as their
+// names and values, exceed limit bytes.
+func HeaderSizeLimitMiddleware(limit int) Middleware {
+	return func(fn ContextHandlerFunc) ContextHandlerFunc {
+		return func(ctx *RequestContext) Response {
+			size := 0
+			for name, values := range ctx.Request.Header {
+				for _, value := range values {
+					size += len(name) + len(value)
+				}
+			}
+
+			if size > limit {
+				return ctx.HeadersTooLargeResponse()
+			}
+
+			return fn(ctx)
+		}
+	}
+}
+
 // RightCheckMiddleware constructs a middleware that returns
-// EmptyJSONResponse(401) if the session not authenticated or if it does not
-// posseses the specified right.
+// ctx.UnauthorizedResponse() if the session is not authenticated, or
+// ctx.ForbiddenResponse() if it does not posseses the specified right. CORS
+// preflight requests are passed through (see isPreflight).
 func RightCheckMiddleware(right string) Middleware {
+	return rightsMiddleware(right, func(ctx *RequestContext) bool {
+		return ctx.HasRight(right)
+	})
+}
+
+// AllRightsMiddleware constructs a middleware which behaves as
+// RightCheckMiddleware but requires every one of the specified rights.
+func AllRightsMiddleware(rights ...string) Middleware {
+	return rightsMiddleware(strings.Join(rights, ","), func(ctx *RequestContext) bool {
+		return ctx.HasAllRights(rights...)
+	})
+}
+
+// AnyRightsMiddleware constructs a middleware which behaves as
+// RightCheckMiddleware but requires at least one of the specified rights.
+func AnyRightsMiddleware(rights ...string) Middleware {
+	return rightsMiddleware(strings.Join(rights, "|"), func(ctx *RequestContext) bool {
+		return ctx.HasAnyRights(rights...)
+	})
+}
+
+// rightsMiddleware constructs a middleware which admits authenticated requests
+// for which check returns true, auditing access to target.
+func rightsMiddleware(target string, check func(*RequestContext) bool) Middleware {
 	return func(fn ContextHandlerFunc) ContextHandlerFunc {
 		return func(ctx *RequestContext) Response {
-			if !ctx.IsAuthenticated() || !ctx.HasRight(right) {
-				return EmptyJSONResponse(http.StatusUnauthorized)
+			if isPreflight(ctx.Request) {
+				return fn(ctx)
+			}
+
+			if !ctx.IsAuthenticated() {
+				ctx.audit(AuditAccess, target, AuditDenied)
+				return ctx.UnauthorizedResponse()
 			}
 
+			if !check(ctx) {
+				ctx.audit(AuditAccess, target, AuditDenied)
+				return ctx.ForbiddenResponse()
+			}
+
+			ctx.audit(AuditAccess, target, AuditSuccess)
+
 			return fn(ctx)
 		}
 	}
 }
+
+// DryRunMiddleware constructs a middleware which, for dry run requests (see
+// RequestContext.IsDryRun), reduces the handler's response to the validation
+// result: a successful response is replaced by BlankResponse(204), while an
+// unsuccessful one (such as a validation error) is returned unchanged.
+func DryRunMiddleware(fn ContextHandlerFunc) ContextHandlerFunc {
+	return func(ctx *RequestContext) Response {
+		if !ctx.IsDryRun() {
+			return fn(ctx)
+		}
+
+		response := BufferResponse(fn(ctx), ctx.Request)
+		if response.Successful() {
+			return BlankResponse(http.StatusNoContent)
+		}
+
+		return response
+	}
+}
+
+// ResponseTransform rewrites a response rendered into memory, for example to
+// inject content into an HTML page or to wrap a JSON body. The response may be
+// modified in place and returned. Its Content-Length header is removed as the
+// body is expected to change.
+type ResponseTransform func(ctx *RequestContext, response *BufferedResponse) Response
+
+// TransformMiddleware constructs a middleware which renders the handler's
+// response into memory and passes it to transform, serving the result in its
+// place. As the whole body is held in memory, a response whose body exceeds
+// maxSize bytes (if maxSize is positive) is written through unbuffered and
+// without being transformed.
+func TransformMiddleware(maxSize int64, transform ResponseTransform) Middleware {
+	return func(fn ContextHandlerFunc) ContextHandlerFunc {
+		return func(ctx *RequestContext) Response {
+			return &transformedResponse{
+				ctx:       ctx,
+				response:  fn(ctx),
+				transform: transform,
+				maxSize:   maxSize,
+			}
+		}
+	}
+}
+
+// FreshSessionMiddleware constructs a middleware that returns
+// ctx.UnauthorizedResponse() if the session is not authenticated, and a 401 error
+// coded ErrorCodeReauthenticationRequired if the principal authenticated more
+// than maxAge ago. CORS preflight requests are passed through (see
+// isPreflight).
+func FreshSessionMiddleware(maxAge time.Duration) Middleware {
+	return func(fn ContextHandlerFunc) ContextHandlerFunc {
+		return func(ctx *RequestContext) Response {
+			if isPreflight(ctx.Request) {
+				return fn(ctx)
+			}
+
+			if !ctx.IsAuthenticated() {
+				return ctx.UnauthorizedResponse()
+			}
+
+			authTime, ok := ctx.AuthTime()
+			if !ok || ctx.requestTime.Sub(authTime) > maxAge {
+				return CodedErrorResponse(ErrorCodeReauthenticationRequired,
+					"Please sign in again to continue.", http.StatusUnauthorized)
+			}
+
+			return fn(ctx)
+		}
+	}
+}
+
+// TwoFactorMiddleware constructs a middleware which requires a principal
+// holding any of the specified rights, or any principal at all if none are
+// specified, to have completed two-factor authentication within maxAge. It
+// returns ctx.UnauthorizedResponse() if the session is not authenticated, and a
+// 403 error coded ErrorCodeTwoFactorRequired if the verification is missing or
+// stale. CORS preflight requests are passed through (see isPreflight).
+func TwoFactorMiddleware(maxAge time.Duration, rights ...string) Middleware {
+	return func(fn ContextHandlerFunc) ContextHandlerFunc {
+		return func(ctx *RequestContext) Response {
+			if isPreflight(ctx.Request) {
+				return fn(ctx)
+			}
+
+			if !ctx.IsAuthenticated() {
+				return ctx.UnauthorizedResponse()
+			}
+
+			if len(rights) > 0 && !ctx.HasAnyRights(rights...) {
+				return fn(ctx)
+			}
+
+			verified, ok := ctx.TwoFactorTime()
+			if !ok || ctx.requestTime.Sub(verified) > maxAge {
+				return CodedErrorResponse(ErrorCodeTwoFactorRequired,
+					"Please complete two-factor authentication to continue.", http.StatusForbidden)
+			}
+
+			return fn(ctx)
+		}
+	}
+}
+
+// SingleFlightMiddleware constructs a middleware which coalesces concurrent GET
+// requests sharing the key produced by keyFn, so that the handler runs once and
+// every caller receives the same buffered response. If keyFn is nil the request
+// URI is used. Keys are always qualified by the principal and its rights, and
+// cookies set by the response are not passed on to the other callers. Callers
+// only share a successful response; otherwise each runs the handler itself.
+func SingleFlightMiddleware(keyFn func(*RequestContext) string) Middleware {
+	if keyFn == nil {
+		keyFn = func(ctx *RequestContext) string {
+			return ctx.Request.URL.RequestURI()
+		}
+	}
+
+	group := &flightGroup{}
+	return func(fn ContextHandlerFunc) ContextHandlerFunc {
+		return func(ctx *RequestContext) Response {
+			if ctx.Request.Method != "GET" {
+				return fn(ctx)
+			}
+
+			key := ctx.principalCacheKey() + keyFn(ctx)
+			response, leader := group.do(key, func() *BufferedResponse {
+				return BufferResponse(fn(ctx), ctx.Request)
+			})
+
+			if leader {
+				return response
+			}
+
+			if response == nil || !response.Successful() {
+				return fn(ctx)
+			}
+
+			return response.withoutCookies()
+		}
+	}
+}
+
+// DuplicateSubmitMiddleware constructs a middleware which catches accidental
+// double submissions of POST requests: a request with the same principal (or
+// anonymous session), path and body as one made within window receives the
+// earlier response without the handler running again. Concurrent duplicates
+// wait for the first to complete. Only successful responses are replayed.
+func DuplicateSubmitMiddleware(window time.Duration) Middleware {
+	stores := &frameworkMemoryCache{}
+	group := &flightGroup{}
+	return func(fn ContextHandlerFunc) ContextHandlerFunc {
+		return func(ctx *RequestContext) Response {
+			if ctx.Request.Method != "POST" {
+				return fn(ctx)
+			}
+
+			body, err := ctx.CaptureBody()
+			if err != nil {
+				return ctx.bodyErrorResponse(err)
+			}
+
+			store := stores.get(ctx)
+			key := ctx.submissionKey(body)
+			if response, _, ok := store.Get(key); ok {
+				return response
+			}
+
+			response, leader := group.do(key, func() *BufferedResponse {
+				response := BufferResponse(fn(ctx), ctx.Request)
+				if response.Successful() {
+					store.Set(key, response, window)
+				}
+				return response
+			})
+
+			if !leader && (response == nil || !response.Successful()) {
+				return fn(ctx)
+			}
+
+			return response
+		}
+	}
+}
+
+// submissionKey identifies a request by its principal, path and body.
+func (ctx *RequestContext) submissionKey(body []byte) string {
+	principal := "session:" + ctx.SessionID()
+	if ctx.IsAuthenticated() {
+		principal = fmt.Sprintf("user:%d", ctx.UserID())
+	}
+
+	sum := sha256.Sum256(body)
+	return principal + ":" + ctx.Request.URL.Path + ":" + hex.EncodeToString(sum[:])
+}
+
+// LoggingMiddleware constructs a middleware which logs the method, path, route
+// template, status, response size, duration, session ID and user ID of each
+// request.
+func LoggingMiddleware(logger *log.Logger) Middleware {
+	return func(fn ContextHandlerFunc) ContextHandlerFunc {
+		return func(ctx *RequestContext) Response {
+			return &loggedResponse{
+				ctx:      ctx,
+				logger:   logger,
+				start:    ctx.framework.now(),
+				response: fn(ctx),
+			}
+		}
+	}
+}
+
+// loggedResponse is a Response which logs another once it has been served.
+type loggedResponse struct {
+	ctx      *RequestContext
+	logger   *log.Logger
+	start    time.Time
+	response Response
+}
+
+func (l *loggedResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sw := &statusWriter{ResponseWriter: w}
+	l.response.ServeHTTP(sw, r)
+
+	status := sw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	l.logger.Printf("method=%s path=%q route=%q status=%d size=%d duration=%s session=%s user=%d",
+		r.Method, r.URL.Path, l.ctx.RouteTemplate(), status, sw.size, l.ctx.framework.now().Sub(l.start),
+		l.ctx.SessionID(), l.ctx.UserID())
+}
+
+func (l *loggedResponse) Cancel() {
+	l.response.Cancel()
+}
+
+// SlowRequestMiddleware constructs a middleware which reports a warning via the
+// framework's error reporter whenever the handler takes longer than threshold.
+func SlowRequestMiddleware(threshold time.Duration) Middleware {
+	return func(fn ContextHandlerFunc) ContextHandlerFunc {
+		return func(ctx *RequestContext) Response {
+			start := ctx.framework.now()
+			response := fn(ctx)
+			if elapsed := ctx.framework.now().Sub(start); elapsed > threshold {
+				ctx.notifySlowRequest(elapsed, threshold)
+			}
+
+			return response
+		}
+	}
+}
+
+func (ctx *RequestContext) notifySlowRequest(elapsed, threshold time.Duration) {
+	route := ctx.RouteTemplate()
+	if route == "" {
+		route = ctx.Request.URL.Path
+	}
+
+	var ectx snitch.ErrorContext
+	ctx.errorMakeErrorContext(nil, 0, &ectx)
+	ectx.Error = fmt.Sprintf("Slow Request: %s %s took %s (threshold %s)",
+		ctx.Request.Method, route, elapsed, threshold)
+	delete(ectx.Details, "status")
+	ectx.Details["level"] = "warning"
+	ectx.Details["route"] = route
+	ectx.Details["duration"] = elapsed.String()
+	ctx.framework.Notify(&ectx)
+}
@@ -0,0 +1,767 @@
+package chopshop
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDuplicateSubmitMiddleware(t *testing.T) {
+	f, ctx, _ := newTestContext(t, "a")
+	clock := NewTestClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	f.Clock = clock.Now
+
+	runs := 0
+	h := DuplicateSubmitMiddleware(time.Second)(func(ctx *RequestContext) Response {
+		runs++
+		return JSONResponse(runs)
+	})
+
+	tests := []struct {
+		advance time.Duration
+		method  string
+		body    string
+		want    string
+	}{
+		{0, "POST", `{"x":1}`, "1\n"},
+		{0, "POST", `{"x":1}`, "1\n"},
+		{0, "POST", `{"x":2}`, "2\n"},
+		{0, "PUT", `{"x":2}`, "3\n"},
+		{2 * time.Second, "POST", `{"x":1}`, "4\n"},
+	}
+
+	for i, tt := range tests {
+		clock.Advance(tt.advance)
+		ctx.capturedBody = nil
+		ctx.Request, _ = http.NewRequest(tt.method, "/orders", strings.NewReader(tt.body))
+
+		w := httptest.NewRecorder()
+		h(ctx).ServeHTTP(w, ctx.Request)
+		if w.Body.String() != tt.want {
+			t.Errorf("%d: body %q, want %q", i, w.Body.String(), tt.want)
+		}
+	}
+}
+
+func TestFreshSessionMiddleware(t *testing.T) {
+	h := FreshSessionMiddleware(time.Minute)(func(ctx *RequestContext) Response {
+		return BlankResponse(http.StatusOK)
+	})
+
+	tests := []struct {
+		name   string
+		rights []string
+		age    time.Duration
+		status int
+	}{
+		{"anonymous", nil, 0, http.StatusUnauthorized},
+		{"fresh", []string{"a"}, 0, http.StatusOK},
+		{"within limit", []string{"a"}, 59 * time.Second, http.StatusOK},
+		{"stale", []string{"a"}, time.Hour, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t, tt.rights...)
+		ctx.requestTime = ctx.requestTime.Add(tt.age)
+
+		w := httptest.NewRecorder()
+		h(ctx).ServeHTTP(w, ctx.Request)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
+
+func TestTwoFactorMiddleware(t *testing.T) {
+	handler := func(ctx *RequestContext) Response { return BlankResponse(http.StatusOK) }
+	sensitive := TwoFactorMiddleware(time.Hour, "admin")(handler)
+	everyone := TwoFactorMiddleware(time.Hour)(handler)
+
+	tests := []struct {
+		name     string
+		h        ContextHandlerFunc
+		rights   []string
+		verified time.Duration // how long ago, if positive
+		relogin  bool
+		status   int
+		contains string
+	}{
+		{"anonymous", sensitive, nil, 0, false, http.StatusUnauthorized, ""},
+		{"unverified", sensitive, []string{"admin"}, 0, false, http.StatusForbidden, ErrorCodeTwoFactorRequired},
+		{"verified", sensitive, []string{"admin"}, time.Minute, false, http.StatusOK, ""},
+		{"stale", sensitive, []string{"admin"}, 2 * time.Hour, false, http.StatusForbidden, ErrorCodeTwoFactorRequired},
+		{"principal replaced", sensitive, []string{"admin"}, time.Minute, true, http.StatusForbidden, ErrorCodeTwoFactorRequired},
+		{"other rights", sensitive, []string{"a"}, 0, false, http.StatusOK, ""},
+		{"any principal", everyone, []string{"a"}, 0, false, http.StatusForbidden, ErrorCodeTwoFactorRequired},
+		{"any principal verified", everyone, []string{"a"}, time.Minute, false, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t, tt.rights...)
+		if tt.verified > 0 {
+			if err := ctx.SetTwoFactorVerified(); err != nil {
+				t.Fatal(err)
+			}
+			ctx.token.Claims["tfa_time"] = ctx.requestTime.Add(-tt.verified).Unix()
+		}
+
+		if tt.relogin {
+			ctx.SetPrincipal("v", 2, tt.rights)
+		}
+
+		w := httptest.NewRecorder()
+		tt.h(ctx).ServeHTTP(w, ctx.Request)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+
+		if !strings.Contains(w.Body.String(), tt.contains) {
+			t.Errorf("%s: body %q does not contain %q", tt.name, w.Body.String(), tt.contains)
+		}
+	}
+
+	_, ctx, _ := newTestContext(t)
+	if err := ctx.SetTwoFactorVerified(); err != ErrSessionNotAuthenticated {
+		t.Errorf("anonymous SetTwoFactorVerified: %v", err)
+	}
+}
+
+func TestContentLengthLimitMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		declared bool
+		status   int
+		readErr  bool
+	}{
+		{"within limit", "abcd", true, http.StatusOK, false},
+		{"declared too large", "abcdefgh", true, http.StatusRequestEntityTooLarge, false},
+		{"undeclared within limit", "abcd", false, http.StatusOK, false},
+		{"undeclared too large", "abcdefgh", false, http.StatusOK, true},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t)
+		ctx.Request, _ = http.NewRequest("POST", "/", strings.NewReader(tt.body))
+		if !tt.declared {
+			ctx.Request.ContentLength = -1
+		}
+
+		var readErr error
+		ran := false
+		h := ContentLengthLimitMiddleware(4)(func(ctx *RequestContext) Response {
+			ran = true
+			_, readErr = ioutil.ReadAll(ctx.Request.Body)
+			return BlankResponse(http.StatusOK)
+		})
+
+		w := httptest.NewRecorder()
+		h(ctx).ServeHTTP(w, ctx.Request)
+		if w.Code != tt.status || ran != (tt.status == http.StatusOK) {
+			t.Errorf("%s: status %d ran %t, want %d", tt.name, w.Code, ran, tt.status)
+		}
+
+		if (readErr != nil) != tt.readErr {
+			t.Errorf("%s: read error %v", tt.name, readErr)
+		}
+	}
+}
+
+func TestSlowRequestMiddleware(t *testing.T) {
+	tests := []struct {
+		elapsed time.Duration
+		want    string
+	}{
+		{time.Second, ""},
+		{2 * time.Second, "Slow Request: GET /items/{id} took 2s (threshold 1s)"},
+	}
+
+	for _, tt := range tests {
+		f, _ := NewFramework("app", "")
+		f.SessionSecret = []byte("secret")
+		clock := NewTestClock(time.Unix(1000, 0))
+		f.Clock = clock.Now
+		reporter := newErrorRecorder()
+		f.ErrorReporter = reporter
+
+		f.Path("/items/{id}").Middleware(SlowRequestMiddleware(time.Second)).Handler(func(ctx *RequestContext) Response {
+			clock.Advance(tt.elapsed)
+			return BlankResponse(http.StatusOK)
+		})
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/items/1", nil))
+
+		if tt.want == "" {
+			if len(reporter.errors) != 0 {
+				t.Errorf("%s: reported %q", tt.elapsed, reporter.errors[0].Error)
+			}
+			continue
+		}
+
+		if len(reporter.errors) != 1 {
+			t.Fatalf("%s: %d reports, want 1", tt.elapsed, len(reporter.errors))
+		}
+
+		ectx := reporter.errors[0]
+		if ectx.Error != tt.want || ectx.Details["level"] != "warning" || ectx.Details["route"] != "/items/{id}" {
+			t.Errorf("%s: reported %q %v", tt.elapsed, ectx.Error, ectx.Details)
+		}
+	}
+}
+
+func TestXSRFMiddleware(t *testing.T) {
+	custom := func(ctx *RequestContext) Response { return BlankResponse(http.StatusTeapot) }
+
+	tests := []struct {
+		name         string
+		header       string
+		form         string
+		unauthorized func(*RequestContext) Response
+		status       int
+	}{
+		{"missing", "", "", nil, http.StatusUnauthorized},
+		{"mismatched", "other", "", nil, http.StatusUnauthorized},
+		{"custom", "", "", custom, http.StatusTeapot},
+		{"matching", "xsrf", "", nil, http.StatusOK},
+		{"form field", "", "xsrf", nil, http.StatusOK},
+		{"mismatched form field", "", "other", nil, http.StatusUnauthorized},
+		{"header preferred", "other", "xsrf", nil, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		f, ctx, _ := newTestContext(t)
+		f.UnauthorizedResponse = tt.unauthorized
+		token := func(v string) string {
+			if v == "xsrf" {
+				return ctx.XSRFToken()
+			}
+			return v
+		}
+
+		form := url.Values{"name": {"bob"}}
+		if tt.form != "" {
+			form.Set(XSRFFormField, token(tt.form))
+		}
+
+		ctx.Request, _ = http.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		ctx.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx.Request.Header.Set("X-XSRF-Token", token(tt.header))
+
+		w := httptest.NewRecorder()
+		XSRFMiddleware(func(ctx *RequestContext) Response {
+			if ctx.FormValue("name") != "bob" {
+				t.Errorf("%s: form not available to handler", tt.name)
+			}
+			return BlankResponse(http.StatusOK)
+		})(ctx).ServeHTTP(w, ctx.Request)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
+
+func TestCSRFField(t *testing.T) {
+	_, ctx, _ := newTestContext(t)
+	want := `<input type="hidden" name="` + XSRFFormField + `" value="` + ctx.XSRFToken() + `">`
+	if field := string(ctx.CSRFField()); field != want {
+		t.Errorf("CSRFField() = %q, want %q", field, want)
+	}
+}
+
+func TestRightCheckMiddleware(t *testing.T) {
+	custom := func(ctx *RequestContext) Response { return BlankResponse(http.StatusTeapot) }
+
+	tests := []struct {
+		name      string
+		rights    []string
+		forbidden func(*RequestContext) Response
+		status    int
+	}{
+		{"anonymous", nil, nil, http.StatusUnauthorized},
+		{"lacking", []string{"b"}, nil, http.StatusForbidden},
+		{"custom", []string{"b"}, custom, http.StatusTeapot},
+		{"holding", []string{"a"}, nil, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		f, ctx, _ := newTestContext(t, tt.rights...)
+		f.ForbiddenResponse = tt.forbidden
+
+		w := httptest.NewRecorder()
+		RightCheckMiddleware("a")(func(ctx *RequestContext) Response {
+			return BlankResponse(http.StatusOK)
+		})(ctx).ServeHTTP(w, ctx.Request)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
+
+func TestSignedURLMiddleware(t *testing.T) {
+	f, ctx, _ := newTestContext(t)
+	h := SignedURLMiddleware(func(ctx *RequestContext) Response {
+		return BlankResponse(http.StatusOK)
+	})
+
+	signed, _ := f.SignedURL("/files/a", time.Minute)
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{signed, http.StatusOK},
+		{"/files/a", http.StatusForbidden},
+		{strings.Replace(signed, "/files/a", "/files/b", 1), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		ctx.Request = httptest.NewRequest("GET", tt.url, nil)
+		w := httptest.NewRecorder()
+		h(ctx).ServeHTTP(w, ctx.Request)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.url, w.Code, tt.status)
+		}
+	}
+}
+
+func TestDryRunMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		response Response
+		status   int
+		body     string
+	}{
+		{"not a dry run", "", JSONResponse("created"), http.StatusOK, "\"created\"\n"},
+		{"disabled", "?dry_run=false", JSONResponse("created"), http.StatusOK, "\"created\"\n"},
+		{"successful", "?dry_run=1", JSONResponse("created"), http.StatusNoContent, ""},
+		{"invalid", "?dry_run=true", ErrorResponse("invalid", http.StatusBadRequest), http.StatusBadRequest, "invalid"},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t)
+		ctx.Request, _ = http.NewRequest("POST", "/items"+tt.query, nil)
+
+		w := httptest.NewRecorder()
+		DryRunMiddleware(func(ctx *RequestContext) Response {
+			return tt.response
+		})(ctx).ServeHTTP(w, ctx.Request)
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.body) || tt.body == "" && w.Body.Len() != 0 {
+			t.Errorf("%s: status %d body %q, want %d %q", tt.name, w.Code, w.Body.String(), tt.status, tt.body)
+		}
+	}
+}
+
+func TestLimitResponses(t *testing.T) {
+	ok := func(ctx *RequestContext) Response { return BlankResponse(http.StatusNoContent) }
+	custom := func(*RequestContext) Response { return ErrorResponse("custom", http.StatusTeapot) }
+
+	tests := []struct {
+		name   string
+		mw     Middleware
+		setup  func(f *Framework, r *http.Request)
+		hook   func(f *Framework)
+		status int
+		body   string
+	}{
+		{
+			name:   "content length",
+			mw:     ContentLengthLimitMiddleware(5),
+			setup:  func(f *Framework, r *http.Request) { r.ContentLength = 10 },
+			status: http.StatusRequestEntityTooLarge,
+			body:   "too large",
+		},
+		{
+			name:   "custom content length",
+			mw:     ContentLengthLimitMiddleware(5),
+			setup:  func(f *Framework, r *http.Request) { r.ContentLength = 10 },
+			hook:   func(f *Framework) { f.PayloadTooLargeResponse = custom },
+			status: http.StatusTeapot,
+			body:   "custom",
+		},
+		{
+			name:   "headers",
+			mw:     HeaderSizeLimitMiddleware(50),
+			setup:  func(f *Framework, r *http.Request) { r.Header.Set("X-Big", strings.Repeat("a", 100)) },
+			status: http.StatusRequestHeaderFieldsTooLarge,
+			body:   "headers are too large",
+		},
+		{
+			name:   "custom headers",
+			mw:     HeaderSizeLimitMiddleware(50),
+			setup:  func(f *Framework, r *http.Request) { r.Header.Set("X-Big", strings.Repeat("a", 100)) },
+			hook:   func(f *Framework) { f.HeadersTooLargeResponse = custom },
+			status: http.StatusTeapot,
+			body:   "custom",
+		},
+		{
+			name:   "headers within limit",
+			mw:     HeaderSizeLimitMiddleware(50),
+			setup:  func(f *Framework, r *http.Request) {},
+			status: http.StatusNoContent,
+		},
+		{
+			name:   "encoding",
+			mw:     JSONSchemaMiddleware([]byte(`{}`)),
+			setup:  func(f *Framework, r *http.Request) { r.Header.Set("Content-Encoding", "br") },
+			status: http.StatusUnsupportedMediaType,
+			body:   "unsupported format",
+		},
+		{
+			name:   "custom encoding",
+			mw:     JSONSchemaMiddleware([]byte(`{}`)),
+			setup:  func(f *Framework, r *http.Request) { r.Header.Set("Content-Encoding", "br") },
+			hook:   func(f *Framework) { f.UnsupportedMediaTypeResponse = custom },
+			status: http.StatusTeapot,
+			body:   "custom",
+		},
+		{
+			name:   "body",
+			mw:     JSONSchemaMiddleware([]byte(`{}`)),
+			setup:  func(f *Framework, r *http.Request) { f.MaxRequestBody = 2 },
+			hook:   func(f *Framework) { f.PayloadTooLargeResponse = custom },
+			status: http.StatusTeapot,
+			body:   "custom",
+		},
+	}
+
+	for _, tt := range tests {
+		f, ctx, _ := newTestContext(t)
+		ctx.Request, _ = http.NewRequest("POST", "/", strings.NewReader("12345"))
+		tt.setup(f, ctx.Request)
+		if tt.hook != nil {
+			tt.hook(f)
+		}
+
+		w := httptest.NewRecorder()
+		tt.mw(ok)(ctx).ServeHTTP(w, ctx.Request)
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.body) {
+			t.Errorf("%s: status %d body %q, want %d %q", tt.name, w.Code, w.Body.String(), tt.status, tt.body)
+		}
+	}
+}
+
+func TestTransformMiddleware(t *testing.T) {
+	envelope := TransformMiddleware(100, func(ctx *RequestContext, r *BufferedResponse) Response {
+		r.Body = append(append([]byte(`{"data":`), bytes.TrimSpace(r.Body)...), '}')
+		r.Header.Set("X-Transformed", "1")
+		return r
+	})
+
+	tests := []struct {
+		name        string
+		value       interface{}
+		body        string
+		transformed bool
+	}{
+		{"small", map[string]int{"a": 1}, `{"data":{"a":1}}`, true},
+		{"large", strings.Repeat("x", 200), `"` + strings.Repeat("x", 200) + `"`, false},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t)
+		w := httptest.NewRecorder()
+		envelope(func(ctx *RequestContext) Response {
+			return JSONResponse(tt.value)
+		})(ctx).ServeHTTP(w, ctx.Request)
+
+		if got := strings.TrimSpace(w.Body.String()); got != tt.body {
+			t.Errorf("%s: body %s, want %s", tt.name, got, tt.body)
+		}
+
+		if transformed := w.Header().Get("X-Transformed") == "1"; transformed != tt.transformed {
+			t.Errorf("%s: transformed %t", tt.name, transformed)
+		}
+
+		if w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s: Content-Type %q", tt.name, w.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestAuthMiddlewarePassesPreflight(t *testing.T) {
+	middlewares := map[string]Middleware{
+		"XSRFMiddleware":         XSRFMiddleware,
+		"RightCheckMiddleware":   RightCheckMiddleware("a"),
+		"FreshSessionMiddleware": FreshSessionMiddleware(time.Minute),
+		"TwoFactorMiddleware":    TwoFactorMiddleware(time.Minute),
+	}
+
+	tests := []struct {
+		name          string
+		method        string
+		requestMethod string
+		status        int
+	}{
+		{"preflight", "OPTIONS", "POST", http.StatusOK},
+		{"plain options", "OPTIONS", "", http.StatusUnauthorized},
+		{"post", "POST", "POST", http.StatusUnauthorized},
+	}
+
+	for name, mw := range middlewares {
+		h := mw(func(ctx *RequestContext) Response { return BlankResponse(http.StatusOK) })
+		for _, tt := range tests {
+			_, ctx, _ := newTestContext(t)
+			ctx.Request, _ = http.NewRequest(tt.method, "/", nil)
+			if tt.requestMethod != "" {
+				ctx.Request.Header.Set("Access-Control-Request-Method", tt.requestMethod)
+			}
+
+			w := httptest.NewRecorder()
+			h(ctx).ServeHTTP(w, ctx.Request)
+			if w.Code != tt.status {
+				t.Errorf("%s %s: status %d, want %d", name, tt.name, w.Code, tt.status)
+			}
+		}
+	}
+}
+
+func TestExemptPaths(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.SessionSecret = []byte("secret")
+	f.Router.Middleware(ExemptPaths(XSRFMiddleware, "/login", "/hooks/{provider}", "/health/*"))
+	for _, p := range []string{"/login", "/hooks/{provider}", "/health/live", "/health/live/deep", "/api"} {
+		f.Path(p).Handler(func(*RequestContext) Response { return BlankResponse(http.StatusNoContent) })
+	}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/login", http.StatusNoContent},
+		{"/hooks/stripe", http.StatusNoContent},
+		{"/health/live", http.StatusNoContent},
+		{"/health/live/deep", http.StatusUnauthorized},
+		{"/api", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest("POST", tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.path, w.Code, tt.status)
+		}
+	}
+}
+
+func TestRotateXSRFToken(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.SessionSecret = []byte("secret")
+	f.NewID = NewTestIDGen("id").Next
+
+	var sessionIDs []string
+	handler := func(rotate bool) ContextHandlerFunc {
+		return XSRFMiddleware(func(ctx *RequestContext) Response {
+			if rotate {
+				ctx.RotateXSRFToken()
+			}
+			sessionIDs = append(sessionIDs, ctx.SessionID())
+			return BlankResponse(http.StatusOK)
+		})
+	}
+	f.Path("/rotate").Handler(handler(true))
+	f.Path("/check").Handler(handler(false))
+
+	// establish a session and its first token
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("GET", "/check", nil))
+	cookies := responseCookies(w)
+	tokens := map[string]string{"old": cookies[f.XSRFCookieName].Value}
+
+	tests := []struct {
+		path   string
+		token  string
+		status int
+	}{
+		{"/check", "old", http.StatusOK},
+		{"/rotate", "old", http.StatusOK},
+		{"/check", "old", http.StatusUnauthorized},
+		{"/check", "new", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		var sent []*http.Cookie
+		for _, c := range cookies {
+			sent = append(sent, c)
+		}
+
+		r := reqWith(sent)
+		r.Method, r.URL.Path = "POST", tt.path
+		r.Header.Set("X-XSRF-Token", tokens[tt.token])
+
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s with %s token: status %d, want %d", tt.path, tt.token, w.Code, tt.status)
+		}
+
+		for name, c := range responseCookies(w) {
+			cookies[name] = c
+		}
+
+		if tt.path == "/rotate" {
+			tokens["new"] = cookies[f.XSRFCookieName].Value
+			if tokens["new"] == tokens["old"] {
+				t.Error("token not rotated")
+			}
+		}
+	}
+
+	for _, id := range sessionIDs {
+		if id != sessionIDs[0] {
+			t.Errorf("session changed: %q", sessionIDs)
+			break
+		}
+	}
+}
+
+func TestRightsSets(t *testing.T) {
+	ok := func(*RequestContext) Response { return BlankResponse(http.StatusOK) }
+
+	tests := []struct {
+		held     []string
+		rights   []string
+		all, any bool
+	}{
+		{[]string{"a", "b"}, nil, true, false},
+		{[]string{"a", "b"}, []string{"a"}, true, true},
+		{[]string{"a", "b"}, []string{"a", "b"}, true, true},
+		{[]string{"a", "b"}, []string{"a", "c"}, false, true},
+		{[]string{"a", "b"}, []string{"c", "d"}, false, false},
+		{nil, nil, false, false},
+		{nil, []string{"a"}, false, false},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t, tt.held...)
+		if got := ctx.HasAllRights(tt.rights...); got != tt.all {
+			t.Errorf("%q HasAllRights(%q) = %t", tt.held, tt.rights, got)
+		}
+
+		if got := ctx.HasAnyRights(tt.rights...); got != tt.any {
+			t.Errorf("%q HasAnyRights(%q) = %t", tt.held, tt.rights, got)
+		}
+
+		middlewares := []struct {
+			name    string
+			mw      Middleware
+			allowed bool
+		}{
+			{"AllRightsMiddleware", AllRightsMiddleware(tt.rights...), tt.all},
+			{"AnyRightsMiddleware", AnyRightsMiddleware(tt.rights...), tt.any},
+		}
+
+		for _, m := range middlewares {
+			status := http.StatusOK
+			switch {
+			case tt.held == nil:
+				status = http.StatusUnauthorized
+			case !m.allowed:
+				status = http.StatusForbidden
+			}
+
+			w := httptest.NewRecorder()
+			m.mw(ok)(ctx).ServeHTTP(w, ctx.Request)
+			if w.Code != status {
+				t.Errorf("%s(%q) for %q: status %d, want %d", m.name, tt.rights, tt.held, w.Code, status)
+			}
+		}
+	}
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		login   bool
+		handler func(http.ResponseWriter, *http.Request)
+		want    string
+	}{
+		{
+			"written",
+			true,
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte("hello"))
+			},
+			`method=POST path="/x/7" route="/x/{id}" status=201 size=5 duration=1.5s session=id-1 user=42`,
+		},
+		{
+			"implicit status",
+			false,
+			func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hi")) },
+			`method=POST path="/x/7" route="/x/{id}" status=200 size=2 duration=1.5s session=id-1 user=0`,
+		},
+	}
+
+	for _, tt := range tests {
+		f, _ := NewFramework("app", "")
+		f.SessionSecret = []byte("secret")
+		clock := NewTestClock(time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC))
+		f.Clock, f.NewID = clock.Now, NewTestIDGen("id").Next
+
+		var buf bytes.Buffer
+		f.Path("/x/{id}").Middleware(LoggingMiddleware(log.New(&buf, "", 0))).Handler(func(ctx *RequestContext) Response {
+			if tt.login {
+				ctx.SetPrincipal("bob", 42, nil)
+			}
+			clock.Advance(1500 * time.Millisecond)
+			return ResponseFunc(tt.handler)
+		})
+
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/x/7", nil))
+		if got := strings.TrimSpace(buf.String()); got != tt.want {
+			t.Errorf("%s: logged %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPreflightDoesNotReachGuardedHandler(t *testing.T) {
+	cors := CORSMiddleware(CORSOptions{AllowedOrigins: []string{"https://app.example.com"}})
+
+	tests := []struct {
+		name    string
+		methods []string
+		cors    bool
+		origin  string
+		status  int
+		ran     bool
+	}{
+		{"any method", nil, false, "https://evil.example.com", http.StatusMethodNotAllowed, false},
+		{"restricted", []string{"POST"}, false, "https://evil.example.com", http.StatusMethodNotAllowed, false},
+		{"inner cors", nil, true, "https://app.example.com", http.StatusNoContent, false},
+		{"inner cors other origin", nil, true, "https://evil.example.com", http.StatusNoContent, false},
+		{"explicit options", []string{"POST", "OPTIONS"}, false, "https://app.example.com", http.StatusOK, true},
+	}
+
+	for _, tt := range tests {
+		f, _ := NewFramework("app", "")
+		f.SessionSecret = []byte("secret")
+		if tt.cors {
+			f.Middleware(cors)
+		}
+
+		var ran bool
+		route := f.Path("/admin/delete").Middleware(RightCheckMiddleware("admin"), XSRFMiddleware)
+		if tt.methods != nil {
+			route.Methods(tt.methods...)
+		}
+		route.Handler(func(ctx *RequestContext) Response {
+			ran = true
+			return JSONResponse("deleted")
+		})
+
+		r := httptest.NewRequest("OPTIONS", "/admin/delete", nil)
+		r.Header.Set("Origin", tt.origin)
+		r.Header.Set("Access-Control-Request-Method", "POST")
+
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, r)
+		if w.Code != tt.status || ran != tt.ran {
+			t.Errorf("%s: status %d ran %t, want %d %t", tt.name, w.Code, ran, tt.status, tt.ran)
+		}
+	}
+}
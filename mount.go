@@ -0,0 +1,48 @@
+package chopshop
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Mux dispatches requests between several Frameworks, each with its own
+// issuer, secret, cookies and error reporter, by host or by path prefix.
+type Mux struct {
+	r       *mux.Router
+	mounted []*Framework
+}
+
+// NewMux constructs an empty Mux.
+func NewMux() *Mux {
+	return &Mux{r: mux.NewRouter()}
+}
+
+// Mount serves requests whose path begins with prefix by f, with the prefix
+// removed so that f's routes are relative to it. As frameworks mounted on
+// the same host share cookies, Mount panics if f's cookie names collide with
+// those of a framework already mounted by prefix.
+func (m *Mux) Mount(prefix string, f *Framework) {
+	for _, other := range m.mounted {
+		for _, name := range []string{f.JWTCookieName, f.XSRFCookieName, f.UserCookieName} {
+			if name == other.JWTCookieName || name == other.XSRFCookieName || name == other.UserCookieName {
+				panic(fmt.Sprintf("chopshop: mounted frameworks share the cookie name %q", name))
+			}
+		}
+	}
+
+	m.mounted = append(m.mounted, f)
+	m.r.PathPrefix(prefix).Handler(http.StripPrefix(prefix, f))
+}
+
+// MountHost serves requests for the given host, which may contain variables as
+// in Framework.HostVars, by f.
+func (m *Mux) MountHost(host string, f *Framework) {
+	m.r.Host(host).Handler(f)
+}
+
+// ServeHTTP adapts Mux for use as an http.Handler.
+func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.r.ServeHTTP(w, r)
+}
@@ -0,0 +1,72 @@
+package chopshop
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newMountedFramework constructs a framework whose /login route authenticates
+// "<issuer>-user" and whose /me route reports the authenticated user.
+func newMountedFramework(issuer, secret string) *Framework {
+	f, _ := NewFramework(issuer, "")
+	f.SessionSecret = []byte(secret)
+	f.Path("/login").Handler(func(ctx *RequestContext) Response {
+		ctx.SetPrincipal(issuer+"-user", 1, nil)
+		return BlankResponse(http.StatusNoContent)
+	})
+	f.Path("/me").Handler(func(ctx *RequestContext) Response {
+		return JSONResponse(ctx.Username())
+	})
+	return f
+}
+
+func TestMux(t *testing.T) {
+	m := NewMux()
+	m.Mount("/one", newMountedFramework("one", "s1"))
+	m.Mount("/two", newMountedFramework("two", "s2"))
+	m.MountHost("three.example.com", newMountedFramework("three", "s3"))
+
+	var cookies []*http.Cookie
+	serve := func(host, path string) *httptest.ResponseRecorder {
+		r := reqWith(cookies)
+		r.Host, r.URL.Path = host, path
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, r)
+		cookies = append(cookies, (&http.Response{Header: w.Header()}).Cookies()...)
+		return w
+	}
+
+	serve("example.com", "/one/login")
+
+	tests := []struct {
+		host   string
+		path   string
+		status int
+		body   string
+	}{
+		{"example.com", "/one/me", http.StatusOK, "\"one-user\"\n"},
+		{"example.com", "/two/me", http.StatusOK, "\"\"\n"},
+		{"three.example.com", "/me", http.StatusOK, "\"\"\n"},
+		{"example.com", "/me", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		w := serve(tt.host, tt.path)
+		if w.Code != tt.status || tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("%s%s: status %d, body %q", tt.host, tt.path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestMuxCookieCollision(t *testing.T) {
+	m := NewMux()
+	m.Mount("/one", newMountedFramework("one", "s1"))
+
+	defer func() {
+		if recover() == nil {
+			t.Error("frameworks sharing cookie names mounted")
+		}
+	}()
+	m.Mount("/again", newMountedFramework("one", "s4"))
+}
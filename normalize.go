@@ -0,0 +1,96 @@
+package chopshop
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// PathNormalizationOptions configures PathNormalizationMiddleware.
+type PathNormalizationOptions struct {
+	// Redirect answers requests for non-canonical paths with a redirect to the
+	// canonical path, rather than serving them as though it had been
+	// requested.
+	Redirect bool
+
+	// Reject answers requests for non-canonical paths with a 400 error. It
+	// takes precedence over Redirect.
+	Reject bool
+
+	// Lowercase folds paths to lower case. It should only be set if no route
+	// distinguishes paths by case.
+	Lowercase bool
+}
+
+// PathNormalizationMiddleware wraps a handler, such as a Framework or a Mux,
+// so that request paths are made canonical before they are routed: empty, "."
+// and ".." segments are resolved, trailing dots are removed from segments and,
+// optionally, the path is folded to lower case. This prevents paths such as
+// "//admin/" or "/public/../admin" from slipping past checks made on prefixes.
+// Encoded slashes are left encoded, so they remain part of their segment.
+func PathNormalizationMiddleware(opts PathNormalizationOptions) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			escaped := r.URL.EscapedPath()
+			canonical, ok := normalizePath(escaped, opts.Lowercase)
+			if ok && canonical == escaped {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			if !ok || opts.Reject {
+				ErrorResponse("The request path is not canonical.", http.StatusBadRequest).ServeHTTP(w, r)
+				return
+			}
+
+			u := *r.URL
+			u.Path, _ = url.PathUnescape(canonical)
+			u.RawPath = canonical
+
+			if opts.Redirect {
+				status := http.StatusMovedPermanently
+				if r.Method != http.MethodGet && r.Method != http.MethodHead {
+					status = http.StatusPermanentRedirect
+				}
+
+				http.Redirect(w, r, u.RequestURI(), status)
+				return
+			}
+
+			normalized := r.WithContext(r.Context())
+			normalized.URL = &u
+			h.ServeHTTP(w, normalized)
+		})
+	}
+}
+
+// normalizePath returns the canonical form of an escaped path, or false if it
+// is not validly escaped. Dot segments are recognized even when escaped.
+func normalizePath(escaped string, lowercase bool) (string, bool) {
+	segments := strings.Split(escaped, "/")
+	for i, segment := range segments {
+		decoded, err := url.PathUnescape(segment)
+		if err != nil {
+			return "", false
+		}
+
+		switch {
+		case decoded == "." || decoded == "..":
+			segments[i] = decoded
+		case strings.HasSuffix(decoded, "."):
+			segments[i] = url.PathEscape(strings.TrimRight(decoded, "."))
+		}
+
+		if lowercase {
+			segments[i] = strings.ToLower(segments[i])
+		}
+	}
+
+	canonical := path.Clean("/" + strings.Join(segments, "/"))
+	if strings.HasSuffix(escaped, "/") && canonical != "/" {
+		canonical += "/"
+	}
+
+	return canonical, true
+}
@@ -0,0 +1,100 @@
+package chopshop
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPathNormalizationMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     PathNormalizationOptions
+		method   string
+		target   string
+		status   int
+		path     string
+		rawPath  string
+		location string
+	}{
+		{
+			name:   "cleaned",
+			method: "GET",
+			target: "//a//b/../c?x=1",
+			status: http.StatusOK,
+			path:   "/a/c",
+		},
+		{
+			name:   "escaped dots",
+			method: "GET",
+			target: "/a/%2e%2e/admin./",
+			status: http.StatusOK,
+			path:   "/admin/",
+		},
+		{
+			name:    "lowercase",
+			opts:    PathNormalizationOptions{Lowercase: true},
+			method:  "GET",
+			target:  "/A%2Fb//C",
+			status:  http.StatusOK,
+			path:    "/a/b/c",
+			rawPath: "/a%2fb/c",
+		},
+		{
+			name:     "redirect",
+			opts:     PathNormalizationOptions{Redirect: true},
+			method:   "GET",
+			target:   "//a//b/../c?x=1",
+			status:   http.StatusMovedPermanently,
+			location: "/a/c?x=1",
+		},
+		{
+			name:     "redirect post",
+			opts:     PathNormalizationOptions{Redirect: true},
+			method:   "POST",
+			target:   "/a/./b",
+			status:   http.StatusPermanentRedirect,
+			location: "/a/b",
+		},
+		{
+			name:   "reject",
+			opts:   PathNormalizationOptions{Reject: true},
+			method: "GET",
+			target: "/a//b",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "reject clean",
+			opts:   PathNormalizationOptions{Reject: true},
+			method: "GET",
+			target: "/a/b/",
+			status: http.StatusOK,
+			path:   "/a/b/",
+		},
+	}
+
+	for _, tt := range tests {
+		var path, rawPath string
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path, rawPath = r.URL.Path, r.URL.EscapedPath()
+		})
+
+		w := httptest.NewRecorder()
+		PathNormalizationMiddleware(tt.opts)(h).ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+
+		if path != tt.path {
+			t.Errorf("%s: path %q, want %q", tt.name, path, tt.path)
+		}
+
+		if tt.rawPath != "" && rawPath != tt.rawPath {
+			t.Errorf("%s: escaped path %q, want %q", tt.name, rawPath, tt.rawPath)
+		}
+
+		if got := w.Header().Get("Location"); got != tt.location {
+			t.Errorf("%s: location %q, want %q", tt.name, got, tt.location)
+		}
+	}
+}
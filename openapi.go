@@ -0,0 +1,200 @@
+package chopshop
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/serenize/snaker"
+)
+
+// GenerateOpenAPI produces an OpenAPI 3 document describing the registered
+// routes. Path parameters are inferred from the route templates, and request
+// and response schemas from the types recorded by Route.WithTypes, annotating
+// properties guarded by readWrite and writeRight tags with the x-read-right and
+// x-write-right extensions. Routes without a method constraint are documented
+// as GET.
+func (f *Framework) GenerateOpenAPI() ([]byte, error) {
+	schemas := make(map[string]interface{})
+	paths := make(map[string]map[string]interface{})
+
+	for _, route := range f.Routes() {
+		if route.PathTemplate == "" {
+			continue
+		}
+
+		methods := route.Methods
+		if len(methods) == 0 {
+			methods = []string{http.MethodGet}
+		}
+
+		path, vars := templateVars(route.PathTemplate)
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+
+		types := routeTypes{request: route.RequestType, response: route.ResponseType}
+		for _, method := range methods {
+			paths[path][strings.ToLower(method)] = openAPIOperation(method, vars, types, schemas)
+		}
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   f.IssuerName,
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"session": map[string]interface{}{
+					"type": "apiKey",
+					"in":   "cookie",
+					"name": f.JWTCookieName,
+				},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"session": []string{}},
+		},
+	})
+}
+
+func openAPIOperation(method string, vars []string, types routeTypes, schemas map[string]interface{}) map[string]interface{} {
+	op := make(map[string]interface{})
+
+	if len(vars) > 0 {
+		params := make([]interface{}, len(vars))
+		for i, name := range vars {
+			params[i] = map[string]interface{}{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			}
+		}
+		op["parameters"] = params
+	}
+
+	if types.request != nil && method != http.MethodGet && method != http.MethodHead {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  openAPIContent(types.request, schemas),
+		}
+	}
+
+	response := map[string]interface{}{"description": "Success"}
+	if types.response != nil {
+		response["content"] = openAPIContent(types.response, schemas)
+	}
+	op["responses"] = map[string]interface{}{"default": response}
+
+	return op
+}
+
+func openAPIContent(ty reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{
+			"schema": openAPISchema(ty, schemas),
+		},
+	}
+}
+
+// openAPISchema describes the serialized form of ty, adding named structs to
+// schemas and referring to them by name.
+func openAPISchema(ty reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	if ty == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch ty.Kind() {
+	case reflect.Ptr:
+		schema := openAPISchema(ty.Elem(), schemas)
+		if _, ok := schema["$ref"]; ok {
+			return schema
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": openAPISchema(ty.Elem(), schemas),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": openAPISchema(ty.Elem(), schemas),
+		}
+	case reflect.Struct:
+		if ty.Name() == "" {
+			return openAPIStructSchema(ty, schemas)
+		}
+
+		if _, ok := schemas[ty.Name()]; !ok {
+			schemas[ty.Name()] = nil // guards against recursive types
+			schemas[ty.Name()] = openAPIStructSchema(ty, schemas)
+		}
+
+		return map[string]interface{}{"$ref": "#/components/schemas/" + ty.Name()}
+	}
+
+	return map[string]interface{}{}
+}
+
+func openAPIStructSchema(ty reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for i := 0; i < ty.NumField(); i++ {
+		field := ty.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name, _ := parseJSONTag(field.Tag.Get("json"))
+		if name == "-" {
+			continue
+		}
+
+		if name == "" {
+			name = snaker.CamelToSnake(field.Name)
+		}
+
+		schema := openAPISchema(field.Type, schemas)
+		if r := field.Tag.Get("readWrite"); r != "" {
+			schema = openAPIExtend(schema, "x-read-right", r)
+		}
+		if w := field.Tag.Get("writeRight"); w != "" {
+			schema = openAPIExtend(schema, "x-write-right", w)
+		}
+
+		properties[name] = schema
+	}
+
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+}
+
+// openAPIExtend adds an extension to a schema, wrapping references (whose
+// siblings are ignored) in allOf.
+func openAPIExtend(schema map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	if _, ok := schema["$ref"]; ok {
+		schema = map[string]interface{}{"allOf": []interface{}{schema}}
+	}
+
+	schema[key] = value
+	return schema
+}
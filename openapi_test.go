@@ -0,0 +1,82 @@
+package chopshop
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type openAPIUser struct {
+	ID     uint64       `json:"id"`
+	Email  string       `json:"email" readWrite:"admin" writeRight:"admin"`
+	Boss   *openAPIUser `json:"boss" readWrite:"admin"`
+	Tags   []string     `json:"tags"`
+	hidden int
+}
+
+// jsonPointer returns the JSON encoding of the value at the slash separated
+// path within doc, in which "~1" stands for "/".
+func jsonPointer(t *testing.T, doc interface{}, path string) string {
+	for _, key := range strings.Split(path, "/") {
+		key = strings.Replace(key, "~1", "/", -1)
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			doc = v[key]
+		case []interface{}:
+			var i int
+			if err := json.Unmarshal([]byte(key), &i); err != nil || i >= len(v) {
+				return ""
+			}
+			doc = v[i]
+		default:
+			return ""
+		}
+	}
+
+	b, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func TestGenerateOpenAPI(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.Path("/users/{id:[0-9]+}").Methods("GET", "PUT").WithTypes(openAPIUser{}, &openAPIUser{}).Handler(func(ctx *RequestContext) Response { return nil })
+	f.Path("/ping").Handler(func(ctx *RequestContext) Response { return nil })
+
+	b, err := f.GenerateOpenAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"info/title", `"app"`},
+		{"paths/~1ping/get/responses/default", `{"description":"Success"}`},
+		{"paths/~1users~1{id}/get/parameters/0", `{"in":"path","name":"id","required":true,"schema":{"type":"string"}}`},
+		{"paths/~1users~1{id}/get/requestBody", `null`},
+		{"paths/~1users~1{id}/put/requestBody/content/application~1json/schema", `{"$ref":"#/components/schemas/openAPIUser"}`},
+		{"paths/~1users~1{id}/put/responses/default/content/application~1json/schema", `{"$ref":"#/components/schemas/openAPIUser"}`},
+		{"components/schemas/openAPIUser/properties/id", `{"minimum":0,"type":"integer"}`},
+		{"components/schemas/openAPIUser/properties/email", `{"type":"string","x-read-right":"admin","x-write-right":"admin"}`},
+		{"components/schemas/openAPIUser/properties/boss", `{"allOf":[{"$ref":"#/components/schemas/openAPIUser"}],"x-read-right":"admin"}`},
+		{"components/schemas/openAPIUser/properties/tags", `{"items":{"type":"string"},"type":"array"}`},
+		{"components/schemas/openAPIUser/properties/hidden", `null`},
+		{"components/securitySchemes/session/name", `"_app_token"`},
+	}
+
+	for _, tt := range tests {
+		if got := jsonPointer(t, doc, tt.path); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package chopshop
+
+import (
+	"io"
+	"net/http"
+)
+
+// proxiedHeaders are the headers of an upstream response which ProxyResponse
+// relays to the client. Hop-by-hop headers and cookies are never relayed.
+var proxiedHeaders = []string{
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Language",
+	"Content-Length",
+	"Content-Type",
+	"ETag",
+	"Expires",
+	"Last-Modified",
+	"Location",
+	"Retry-After",
+	"Vary",
+}
+
+// ProxyResponse constructs a response which sends req to an upstream service
+// using client (or http.DefaultClient if nil) and relays the status, a
+// safelist of headers and the body of its response. The upstream request is
+// made with the context of the request being served, so that it is abandoned
+// if the client goes away. If the upstream service cannot be reached a 502
+// error is returned.
+func ProxyResponse(client *http.Client, req *http.Request) Response {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &proxyResponse{client: client, req: req}
+}
+
+type proxyResponse struct {
+	client *http.Client
+	req    *http.Request
+}
+
+func (p *proxyResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	resp, err := p.client.Do(p.req.WithContext(r.Context()))
+	if err != nil {
+		ErrorResponse("The upstream service could not be reached.", http.StatusBadGateway).ServeHTTP(w, r)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, name := range proxiedHeaders {
+		name = http.CanonicalHeaderKey(name)
+		if values, ok := resp.Header[name]; ok {
+			w.Header()[name] = values
+		}
+	}
+
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func (p *proxyResponse) Cancel() {
+	if p.req.Body != nil {
+		p.req.Body.Close()
+	}
+}
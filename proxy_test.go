@@ -0,0 +1,82 @@
+package chopshop
+
+import (
+	stdcontext "context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxyResponse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Set-Cookie", "a=b")
+		w.Header().Set("X-Internal", "secret")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello " + r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	cancelled, cancel := stdcontext.WithCancel(stdcontext.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		url     string
+		ctx     stdcontext.Context
+		status  int
+		body    string
+		headers map[string]string
+	}{
+		{
+			name:   "relayed",
+			url:    upstream.URL + "/thing",
+			ctx:    stdcontext.Background(),
+			status: http.StatusCreated,
+			body:   "hello /thing",
+			headers: map[string]string{
+				"Content-Type": "text/plain",
+				"ETag":         `"v1"`,
+				"Set-Cookie":   "",
+				"X-Internal":   "",
+			},
+		},
+		{
+			name:   "unreachable",
+			url:    closed.URL,
+			ctx:    stdcontext.Background(),
+			status: http.StatusBadGateway,
+		},
+		{
+			name:   "cancelled",
+			url:    upstream.URL,
+			ctx:    cancelled,
+			status: http.StatusBadGateway,
+		},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.url, nil)
+		r := httptest.NewRequest("GET", "/", nil).WithContext(tt.ctx)
+
+		w := httptest.NewRecorder()
+		ProxyResponse(nil, req).ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.name, w.Body.String(), tt.body)
+		}
+
+		for k, v := range tt.headers {
+			if got := w.Header().Get(k); got != v {
+				t.Errorf("%s: %s %q, want %q", tt.name, k, got, v)
+			}
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package chopshop
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"reflect"
 	"strings"
+	"sync"
 )
 
 var (
@@ -28,6 +31,55 @@ func isEmpty(v reflect.Value) bool {
 	return false
 }
 
+// readRightsCache records the result of hasReadRights by type.
+var readRightsCache = struct {
+	sync.RWMutex
+	types map[reflect.Type]bool
+}{types: make(map[reflect.Type]bool)}
+
+// hasReadRights returns true if ty has a struct field guarded by a readWrite
+// tag, either directly or within the structs, pointers, slices, arrays and maps
+// it contains.
+func hasReadRights(ty reflect.Type) bool {
+	readRightsCache.RLock()
+	has, ok := readRightsCache.types[ty]
+	readRightsCache.RUnlock()
+	if ok {
+		return has
+	}
+
+	has = typeHasReadRights(ty, make(map[reflect.Type]bool))
+
+	readRightsCache.Lock()
+	readRightsCache.types[ty] = has
+	readRightsCache.Unlock()
+
+	return has
+}
+
+// typeHasReadRights implements hasReadRights, skipping the types already
+// visited so that recursive types terminate.
+func typeHasReadRights(ty reflect.Type, visited map[reflect.Type]bool) bool {
+	if visited[ty] {
+		return false
+	}
+	visited[ty] = true
+
+	switch ty.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return typeHasReadRights(ty.Elem(), visited)
+	case reflect.Struct:
+		for i := 0; i < ty.NumField(); i++ {
+			field := ty.Field(i)
+			if field.Tag.Get("readWrite") != "" || typeHasReadRights(field.Type, visited) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 func ifSliceToStrSlice(v []interface{}) ([]string, error) {
 	var list []string
 	for i := range v {
@@ -65,3 +117,63 @@ func parseJSONTag(tag string) (string, string) {
 	}
 	return tag, ""
 }
+
+// orderedField is a single key/value pair of an orderedObject.
+type orderedField struct {
+	Key   string
+	Value interface{}
+}
+
+// orderedObject is a JSON object which preserves the insertion order of its
+// keys when encoded.
+type orderedObject []orderedField
+
+// Set assigns the value of key, appending it if it is not already present.
+func (o *orderedObject) Set(key string, value interface{}) {
+	for i := range *o {
+		if (*o)[i].Key == key {
+			(*o)[i].Value = value
+			return
+		}
+	}
+
+	*o = append(*o, orderedField{Key: key, Value: value})
+}
+
+// Map converts the object into an unordered map.
+func (o orderedObject) Map() map[string]interface{} {
+	m := make(map[string]interface{}, len(o))
+	for _, f := range o {
+		m[f.Key] = f.Value
+	}
+
+	return m
+}
+
+// MarshalJSON implements json.Marshaler.
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, err := json.Marshal(f.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		val, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
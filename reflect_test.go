@@ -0,0 +1,235 @@
+package chopshop
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type customInt int
+
+func (c customInt) MarshalJSON() ([]byte, error) { return []byte(`"custom"`), nil }
+
+type customPtr struct{ X int }
+
+func (c *customPtr) MarshalJSON() ([]byte, error) { return []byte(`"pcustom"`), nil }
+
+type guarded struct {
+	Public string `json:"public"`
+	Secret string `json:"secret" readWrite:"admin"`
+}
+
+// guardedMarshaler would leak its nested guarded field if its MarshalJSON were
+// honored.
+type guardedMarshaler struct {
+	Items []guarded `json:"items"`
+}
+
+func (g guardedMarshaler) MarshalJSON() ([]byte, error) { return []byte(`"leaked"`), nil }
+
+type tree struct {
+	Children []tree           `json:"children"`
+	Next     *tree            `json:"next"`
+	Index    map[string]*tree `json:"index"`
+}
+
+type guardedTree struct {
+	Children []guardedTree `json:"children"`
+	Leaf     *guarded      `json:"leaf"`
+}
+
+func TestHasReadRights(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  bool
+	}{
+		{0, false},
+		{customInt(0), false},
+		{guarded{}, true},
+		{&guarded{}, true},
+		{[]guarded{}, true},
+		{[2]*guarded{}, true},
+		{map[string][]guarded{}, true},
+		{struct{ G *guarded }{}, true},
+		{guardedMarshaler{}, true},
+		{tree{}, false},
+		{guardedTree{}, true},
+	}
+
+	for _, tt := range tests {
+		if got := hasReadRights(reflect.TypeOf(tt.value)); got != tt.want {
+			t.Errorf("%T: got %t, want %t", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSafeSerializeMarshalers(t *testing.T) {
+	type holder struct {
+		List    []customInt      `json:"list"`
+		PList   []customPtr      `json:"plist"`
+		Guarded guardedMarshaler `json:"guarded"`
+		Secret  string           `json:"secret" readWrite:"admin"`
+	}
+
+	value := holder{
+		List:    []customInt{1, 2},
+		PList:   []customPtr{{1}},
+		Guarded: guardedMarshaler{Items: []guarded{{Public: "p", Secret: "s"}}},
+		Secret:  "x",
+	}
+
+	tests := []struct {
+		rights []string
+		want   string
+	}{
+		{[]string{"user"}, `{"guarded":{"items":[{"public":"p"}]},"list":["custom","custom"],"plist":["pcustom"]}`},
+		{[]string{"admin"}, `{"guarded":{"items":[{"public":"p","secret":"s"}]},"list":["custom","custom"],"plist":["pcustom"],"secret":"x"}`},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t, tt.rights...)
+		out, err := ctx.safeSerialize(reflect.ValueOf(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var b bytes.Buffer
+		json.NewEncoder(&b).Encode(out)
+		if got := bytes.TrimSpace(b.Bytes()); string(got) != tt.want {
+			t.Errorf("%v: got %s, want %s", tt.rights, got, tt.want)
+		}
+	}
+}
+
+func TestPreserveFieldOrder(t *testing.T) {
+	type ordered struct {
+		Zeta  int `json:"zeta"`
+		Alpha int `json:"alpha"`
+		Mid   struct {
+			B int `json:"b"`
+			A int `json:"a"`
+		} `json:"mid"`
+	}
+
+	tests := []struct {
+		preserve bool
+		want     string
+	}{
+		{true, `{"zeta":0,"alpha":0,"mid":{"b":0,"a":0}}`},
+		{false, `{"alpha":0,"mid":{"a":0,"b":0},"zeta":0}`},
+	}
+
+	for _, tt := range tests {
+		f, ctx, _ := newTestContext(t)
+		f.PreserveFieldOrder = tt.preserve
+		out, err := ctx.safeSerialize(reflect.ValueOf(ordered{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, _ := json.Marshal(out)
+		if string(b) != tt.want {
+			t.Errorf("preserve %t: got %s, want %s", tt.preserve, b, tt.want)
+		}
+	}
+}
+
+func TestSafeSerializeMaps(t *testing.T) {
+	tests := []struct {
+		name   string
+		rights []string
+		value  interface{}
+		want   string
+	}{
+		{"values", []string{"user"}, map[string]guarded{"a": {"p", "s"}},
+			`{"a":{"public":"p"}}`},
+		{"pointer values", []string{"user"}, map[int]*guarded{3: {"p", "s"}},
+			`{"3":{"public":"p"}}`},
+		{"nested", []string{"user"}, struct {
+			M map[string][]guarded `json:"m"`
+		}{map[string][]guarded{"a": {{"p", "s"}}}},
+			`{"m":{"a":[{"public":"p"}]}}`},
+		{"permitted", []string{"admin"}, map[string]guarded{"a": {"p", "s"}},
+			`{"a":{"public":"p","secret":"s"}}`},
+		{"interface values", []string{"user"}, map[string]interface{}{"u": guarded{"p", "s"}, "n": 1, "nil": nil},
+			`{"n":1,"nil":null,"u":{"public":"p"}}`},
+		{"interface elements", []string{"user"}, []interface{}{&guarded{"p", "s"}, "x"},
+			`[{"public":"p"},"x"]`},
+		{"interface field", []string{"user"}, struct {
+			V interface{} `json:"v"`
+		}{map[string]interface{}{"u": guarded{"p", "s"}}},
+			`{"v":{"u":{"public":"p"}}}`},
+		{"guarded marshaler", []string{"user"}, map[string]json.Marshaler{"g": guardedMarshaler{[]guarded{{"p", "s"}}}},
+			`{"g":{"items":[{"public":"p"}]}}`},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t, tt.rights...)
+		out, err := ctx.safeSerialize(reflect.ValueOf(tt.value))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if b, _ := json.Marshal(out); string(b) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, b, tt.want)
+		}
+	}
+}
+
+func TestSafeSerializeNilPointers(t *testing.T) {
+	type parent struct {
+		Child *guarded   `json:"child"`
+		List  []*guarded `json:"list"`
+	}
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"field", parent{}, `{"child":null,"list":[]}`},
+		{"element", parent{List: []*guarded{nil, {Public: "p"}}}, `{"child":null,"list":[null,{"public":"p"}]}`},
+		{"top level", (*guarded)(nil), `null`},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t, "user")
+		out, err := ctx.safeSerialize(reflect.ValueOf(tt.value))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if b, _ := json.Marshal(out); string(b) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, b, tt.want)
+		}
+	}
+}
+
+func TestSafeSerializeMarshalerInterfaces(t *testing.T) {
+	type holder struct {
+		M json.Marshaler `json:"m"`
+	}
+
+	tests := []struct {
+		name  string
+		value holder
+		want  string
+	}{
+		{"nil", holder{}, `{"m":null}`},
+		{"value", holder{M: customInt(1)}, `{"m":"custom"}`},
+		{"pointer", holder{M: &customPtr{1}}, `{"m":"pcustom"}`},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t, "user")
+		out, err := ctx.safeSerialize(reflect.ValueOf(tt.value))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if b, _ := json.Marshal(out); string(b) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, b, tt.want)
+		}
+	}
+}
@@ -1,17 +1,18 @@
 package chopshop
 
 import (
+	stdcontext "context"
+	"crypto/hmac"
 	"encoding"
 	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
 	"net/url"
 	"reflect"
-	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/alderanalytics/snitch"
@@ -32,9 +33,19 @@ type RequestContext struct {
 	principal         *Principal
 	framework         *Framework
 	requestTime       time.Time
+	requestID         string
+	tenantID          string
+	deviceInfo        *DeviceInfo
+	bearerToken       bool
+	user              interface{}
+	userErr           error
+	userLoaded        bool
+	errors            []error
+	errorStatus       int
 	destroyingSession bool
 	routeVars         map[string]string
 	queryValues       url.Values
+	capturedBody      []byte
 }
 
 // Principal defines a user identity.
@@ -61,20 +72,86 @@ var (
 		reflect.TypeOf(new(json.Unmarshaler)).Elem(),
 		reflect.TypeOf(new(encoding.TextUnmarshaler)).Elem(),
 	}
+
+	marshalerType = reflect.TypeOf(new(json.Marshaler)).Elem()
 )
 
-// AddRight endows the current session with the specified right. The current
-// session must be authenticated.
+// AddRight endows the current session with the specified right, unless it
+// already holds it. The current session must be authenticated.
 func (ctx *RequestContext) AddRight(right string) error {
 	if !ctx.IsAuthenticated() {
-		return errors.New("Session not authenticated.")
+		return ErrSessionNotAuthenticated
+	}
+
+	if hasItem(right, ctx.principal.Rights) {
+		return nil
 	}
 
 	ctx.principal.Rights = append(ctx.principal.Rights, right)
+	ctx.audit(AuditAddRight, right, AuditSuccess)
+	return nil
+}
+
+// SetRights replaces the rights of the current session with a copy of the
+// given rights, omitting duplicates. The current session must be
+// authenticated.
+func (ctx *RequestContext) SetRights(rights []string) error {
+	if !ctx.IsAuthenticated() {
+		return ErrSessionNotAuthenticated
+	}
+
+	set := make([]string, 0, len(rights))
+	for _, right := range rights {
+		if !hasItem(right, set) {
+			set = append(set, right)
+		}
+	}
+
+	for _, right := range set {
+		if !hasItem(right, ctx.principal.Rights) {
+			ctx.audit(AuditAddRight, right, AuditSuccess)
+		}
+	}
+
+	for _, right := range ctx.principal.Rights {
+		if !hasItem(right, set) {
+			ctx.audit(AuditRemoveRight, right, AuditSuccess)
+		}
+	}
+
+	ctx.principal.Rights = set
 	return nil
 }
 
-// RemoveRight removes the specified right from the current session.
+// AddTemporaryRight endows the current session with the specified right until
+// ttl has elapsed. The current session must be authenticated.
+func (ctx *RequestContext) AddTemporaryRight(right string, ttl time.Duration) error {
+	if !ctx.IsAuthenticated() {
+		return ErrSessionNotAuthenticated
+	}
+
+	rights := ctx.temporaryRights()
+	rights[right] = ctx.requestTime.Add(ttl).Unix()
+	ctx.token.Claims["temp_rights"] = rights
+	ctx.audit(AuditAddRight, right, AuditSuccess)
+	return nil
+}
+
+func (ctx *RequestContext) temporaryRights() map[string]interface{} {
+	if rights, ok := ctx.token.Claims["temp_rights"].(map[string]interface{}); ok {
+		return rights
+	}
+
+	return make(map[string]interface{})
+}
+
+func (ctx *RequestContext) hasTemporaryRight(right string) bool {
+	expiry, ok := claimTime(ctx.temporaryRights(), right)
+	return ok && ctx.requestTime.Before(expiry)
+}
+
+// RemoveRight removes every occurrence of the specified right from the current
+// session, preserving the order of the remaining rights.
 // If the session is unauthenticated, the session has no rights and this call
 // has no effect.
 func (ctx *RequestContext) RemoveRight(right string) {
@@ -82,27 +159,60 @@ func (ctx *RequestContext) RemoveRight(right string) {
 		return
 	}
 
-	rights := ctx.principal.Rights
+	delete(ctx.temporaryRights(), right)
 
-	i := sort.Search(len(rights), func(i int) bool {
-		return rights[i] == right
-	})
-
-	if i >= len(rights) {
-		return
+	rights := make([]string, 0, len(ctx.principal.Rights))
+	removed := false
+	for _, r := range ctx.principal.Rights {
+		if r == right {
+			removed = true
+			continue
+		}
+		rights = append(rights, r)
 	}
 
-	ctx.principal.Rights = append(rights[:i], rights[i+1:]...)
+	ctx.principal.Rights = rights
+	if removed {
+		ctx.audit(AuditRemoveRight, right, AuditSuccess)
+	}
 }
 
 // HasRight returns true if the current request context has been granted the
-// specified right.
+// specified right, either permanently or by an unexpired temporary grant.
 func (ctx *RequestContext) HasRight(right string) bool {
 	if ctx.principal == nil {
 		return false
 	}
 
-	return hasItem(right, ctx.principal.Rights)
+	return hasItem(right, ctx.principal.Rights) || ctx.hasTemporaryRight(right)
+}
+
+// HasAllRights returns true if the session is authenticated and has been
+// granted every one of the specified rights. It is true for an empty list.
+func (ctx *RequestContext) HasAllRights(rights ...string) bool {
+	if ctx.principal == nil {
+		return false
+	}
+
+	for _, right := range rights {
+		if !ctx.HasRight(right) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// HasAnyRights returns true if the session is authenticated and has been
+// granted at least one of the specified rights. It is false for an empty list.
+func (ctx *RequestContext) HasAnyRights(rights ...string) bool {
+	for _, right := range rights {
+		if ctx.HasRight(right) {
+			return true
+		}
+	}
+
+	return false
 }
 
 // RouteVar returns a value matching a variable portion of the route, or the
@@ -119,19 +229,36 @@ func (ctx *RequestContext) RouteVar(k string) string {
 	return ""
 }
 
+// RouteVarUint parses a route variable as a uint64, returning
+// ErrRouteVarNotPresent if it is absent, or the parse error if it is malformed.
+func (ctx *RequestContext) RouteVarUint(name string) (uint64, error) {
+	s := ctx.RouteVar(name)
+	if s == "" {
+		return 0, ErrRouteVarNotPresent
+	}
+
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// RouteVarInt parses a route variable as an int64, returning
+// ErrRouteVarNotPresent if it is absent, or the parse error if it is malformed.
+func (ctx *RequestContext) RouteVarInt(name string) (int64, error) {
+	s := ctx.RouteVar(name)
+	if s == "" {
+		return 0, ErrRouteVarNotPresent
+	}
+
+	return strconv.ParseInt(s, 10, 64)
+}
+
 // RouteModelID returns the id from the route, or an error if this fails.
 func (ctx *RequestContext) RouteModelID() (uint64, error) {
-	idstr := ctx.RouteVar("id")
-	if idstr == "" {
+	id, err := ctx.RouteVarUint("id")
+	if err == ErrRouteVarNotPresent {
 		return 0, ErrModelIDNotPresent
 	}
 
-	id, err := strconv.ParseUint(idstr, 10, 64)
-	if err != nil {
-		return 0, err
-	}
-
-	return id, nil
+	return id, err
 }
 
 // IsAuthenticated returns true if the session is authenticated.
@@ -155,6 +282,20 @@ func (ctx *RequestContext) UserID() uint64 {
 	return ctx.principal.UserID
 }
 
+// User returns the record of the authenticated user loaded by
+// Framework.UserLoader, which is called at most once per request. It returns
+// nil if the session is not authenticated or no loader is configured.
+func (ctx *RequestContext) User() (interface{}, error) {
+	if !ctx.userLoaded {
+		ctx.userLoaded = true
+		if loader := ctx.framework.UserLoader; loader != nil && ctx.IsAuthenticated() {
+			ctx.user, ctx.userErr = loader(ctx, ctx.UserID())
+		}
+	}
+
+	return ctx.user, ctx.userErr
+}
+
 // QueryVar returns the value of a query variable or the empty string if it is
 // not present.
 func (ctx *RequestContext) QueryVar(v string) string {
@@ -165,48 +306,373 @@ func (ctx *RequestContext) QueryVar(v string) string {
 	return ctx.queryValues.Get(v)
 }
 
-// SetPrincipal sets the security principal.
+// QueryVars returns every value of a repeated query variable in the order in
+// which they appear, or an empty slice if it is not present.
+func (ctx *RequestContext) QueryVars(name string) []string {
+	if ctx.queryValues == nil {
+		ctx.queryValues = ctx.Request.URL.Query()
+	}
+
+	if values := ctx.queryValues[name]; values != nil {
+		return values
+	}
+
+	return []string{}
+}
+
+// QueryVarIntErr parses a query variable as an int, returning
+// ErrQueryVarNotPresent if it is absent or empty, or the parse error if it is
+// malformed.
+func (ctx *RequestContext) QueryVarIntErr(name string) (int, error) {
+	s := ctx.QueryVar(name)
+	if s == "" {
+		return 0, ErrQueryVarNotPresent
+	}
+
+	return strconv.Atoi(s)
+}
+
+// QueryVarInt parses a query variable as an int, returning def if it is
+// absent or malformed.
+func (ctx *RequestContext) QueryVarInt(name string, def int) int {
+	n, err := ctx.QueryVarIntErr(name)
+	if err != nil {
+		return def
+	}
+
+	return n
+}
+
+// QueryVarUint parses a query variable as a uint64, returning def if it is
+// absent or malformed.
+func (ctx *RequestContext) QueryVarUint(name string, def uint64) uint64 {
+	n, err := strconv.ParseUint(ctx.QueryVar(name), 10, 64)
+	if err != nil {
+		return def
+	}
+
+	return n
+}
+
+// QueryVarBool parses a query variable as a bool (see strconv.ParseBool),
+// returning def if it is absent or malformed.
+func (ctx *RequestContext) QueryVarBool(name string, def bool) bool {
+	b, err := strconv.ParseBool(ctx.QueryVar(name))
+	if err != nil {
+		return def
+	}
+
+	return b
+}
+
+// QueryVarFloat parses a query variable as a float64, returning def if it is
+// absent or malformed.
+func (ctx *RequestContext) QueryVarFloat(name string, def float64) float64 {
+	f, err := strconv.ParseFloat(ctx.QueryVar(name), 64)
+	if err != nil {
+		return def
+	}
+
+	return f
+}
+
+// RequireExactlyOne returns an error unless exactly one of the named query
+// variables is present, for parameters which are mutually exclusive but of
+// which one is required. The error is a ValidationErrors, suitable for
+// ValidationErrorResponse.
+func (ctx *RequestContext) RequireExactlyOne(params ...string) error {
+	if len(ctx.presentQueryVars(params)) == 0 {
+		return ValidationErrors{{
+			Message: fmt.Sprintf("one of %s is required", strings.Join(params, ", ")),
+		}}
+	}
+
+	return ctx.RequireAtMostOne(params...)
+}
+
+// RequireAtMostOne returns an error if more than one of the named query
+// variables is present, for parameters which are mutually exclusive. The
+// error is a ValidationErrors, suitable for ValidationErrorResponse.
+func (ctx *RequestContext) RequireAtMostOne(params ...string) error {
+	present := ctx.presentQueryVars(params)
+	if len(present) > 1 {
+		return ValidationErrors{{
+			Message: fmt.Sprintf("only one of %s may be given, not %s",
+				strings.Join(params, ", "), strings.Join(present, " and ")),
+		}}
+	}
+
+	return nil
+}
+
+// presentQueryVars returns those of the named query variables which are
+// present and not empty.
+func (ctx *RequestContext) presentQueryVars(params []string) []string {
+	var present []string
+	for _, name := range params {
+		if ctx.QueryVar(name) != "" {
+			present = append(present, name)
+		}
+	}
+
+	return present
+}
+
+// IsDryRun returns true if the client requested that the handler validate the
+// request without committing side effects, by setting the dry_run query
+// parameter to a true value (e.g. ?dry_run=1). Handlers must opt in by
+// skipping persistence; dry runs are otherwise subject to the same middleware,
+// including XSRF and right checks.
+func (ctx *RequestContext) IsDryRun() bool {
+	return ctx.QueryVarBool("dry_run", false)
+}
+
+// SetPrincipal sets the security principal, recording the request time as the
+// time at which the session was authenticated and the token issued. Any
+// earlier two-factor verification is forgotten.
 func (ctx *RequestContext) SetPrincipal(username string, user_id uint64, rights []string) {
 	ctx.principal = NewPrincipal(username, user_id, rights)
+	ctx.token.Claims["auth_time"] = ctx.requestTime.Unix()
+	ctx.token.Claims["iat"] = ctx.requestTime.Unix()
+	delete(ctx.token.Claims, "tfa_time")
+	ctx.audit(AuditLogin, username, AuditSuccess)
 }
 
 // DestroyPrincipal removes the security principal from the session.
 func (ctx *RequestContext) DestroyPrincipal() {
+	if ctx.IsAuthenticated() && !ctx.destroyingSession {
+		ctx.audit(AuditLogout, ctx.Username(), AuditSuccess)
+	}
+
 	ctx.principal = nil
+	delete(ctx.token.Claims, "auth_time")
+	delete(ctx.token.Claims, "tfa_time")
+	delete(ctx.token.Claims, "temp_rights")
+}
+
+// SetTwoFactorVerified records that the principal has completed two-factor
+// authentication, such as by submitting a code accepted by auth.VerifyTOTP.
+// The session must be authenticated.
+func (ctx *RequestContext) SetTwoFactorVerified() error {
+	if !ctx.IsAuthenticated() {
+		return ErrSessionNotAuthenticated
+	}
+
+	ctx.token.Claims["tfa_time"] = ctx.requestTime.Unix()
+	return nil
+}
+
+// TwoFactorVerified returns true if the principal has completed two-factor
+// authentication since it was set.
+func (ctx *RequestContext) TwoFactorVerified() bool {
+	if !ctx.IsAuthenticated() {
+		return false
+	}
+
+	_, ok := ctx.TwoFactorTime()
+	return ok
+}
+
+// TwoFactorTime returns the time at which the principal last completed
+// two-factor authentication. The boolean is false if the session is not
+// authenticated or two-factor authentication has not been completed.
+func (ctx *RequestContext) TwoFactorTime() (time.Time, bool) {
+	if !ctx.IsAuthenticated() {
+		return time.Time{}, false
+	}
+
+	return claimTime(ctx.token.Claims, "tfa_time")
+}
+
+// AuthTime returns the time at which the principal last authenticated. The
+// boolean is false if the session is not authenticated or the time is unknown.
+func (ctx *RequestContext) AuthTime() (time.Time, bool) {
+	if !ctx.IsAuthenticated() {
+		return time.Time{}, false
+	}
+
+	return claimTime(ctx.token.Claims, "auth_time")
 }
 
 // GetSession retrives an item from the session store.
 func (ctx *RequestContext) GetSession(key string) (interface{}, bool) {
-	vars := ctx.token.Claims["vars"].(map[string]interface{})
-	val, ok := vars[key]
+	val, ok := ctx.sessionVars(false)[key]
 	return val, ok
 }
 
 // HasSession tests for an item in the session store.
 func (ctx *RequestContext) HasSession(key string) bool {
-	vars := ctx.token.Claims["vars"].(map[string]interface{})
-	_, ok := vars[key]
+	_, ok := ctx.sessionVars(false)[key]
 	return ok
 }
 
 // PutSession sets an item in the session store.
 func (ctx *RequestContext) PutSession(key string, value interface{}) {
-	vars := ctx.token.Claims["vars"].(map[string]interface{})
-	vars[key] = value
+	ctx.sessionVars(true)[key] = value
 }
 
 // DeleteSession deletes an item from the session store.
 func (ctx *RequestContext) DeleteSession(key string) {
-	vars := ctx.token.Claims["vars"].(map[string]interface{})
-	delete(vars, key)
+	delete(ctx.sessionVars(false), key)
 }
 
-// XSRFToken gets the session XSRF token.
+// sessionVars returns the session store held in the vars claim. If the claim
+// is missing or malformed, nil is returned, or if create is set, an empty store
+// replaces it.
+func (ctx *RequestContext) sessionVars(create bool) map[string]interface{} {
+	vars, ok := ctx.token.Claims["vars"].(map[string]interface{})
+	if !ok && create {
+		vars = make(map[string]interface{})
+		ctx.token.Claims["vars"] = vars
+	}
+
+	return vars
+}
+
+// reservedClaims are the claims maintained by the framework, which may not be
+// modified through SetClaim or DeleteClaim.
+var reservedClaims = map[string]bool{
+	"iss": true, "sub": true, "aud": true, "exp": true, "nbf": true,
+	"iat": true, "jti": true, "vars": true, "auth_time": true,
+	"tfa_time": true, "temp_rights": true, "xsrf": true,
+}
+
+// Claim returns a top-level claim of the session token.
+func (ctx *RequestContext) Claim(key string) (interface{}, bool) {
+	val, ok := ctx.token.Claims[key]
+	return val, ok
+}
+
+// SetClaim sets a top-level claim of the session token, which is signed and
+// sent with the response. Claims maintained by the framework, such as sub,
+// exp and jti, are reserved and cannot be set; ErrReservedClaim is returned.
+func (ctx *RequestContext) SetClaim(key string, value interface{}) error {
+	if reservedClaims[key] {
+		return ErrReservedClaim
+	}
+
+	ctx.token.Claims[key] = value
+	return nil
+}
+
+// DeleteClaim deletes a top-level claim of the session token, returning
+// ErrReservedClaim if the claim is reserved.
+func (ctx *RequestContext) DeleteClaim(key string) error {
+	if reservedClaims[key] {
+		return ErrReservedClaim
+	}
+
+	delete(ctx.token.Claims, key)
+	return nil
+}
+
+// IsAPIRequest returns true if the request was made by an API client, to which
+// session cookies are not sent. Unless overridden by Framework.IsAPIRequest, a
+// request is considered an API request if it carries an Authorization header,
+// or if Framework.JSONAcceptIsAPI is set and it accepts application/json and
+// carries no session cookie.
+func (ctx *RequestContext) IsAPIRequest() bool {
+	if fn := ctx.framework.IsAPIRequest; fn != nil {
+		return fn(ctx)
+	}
+
+	r := ctx.Request
+	if r.Header.Get("Authorization") != "" {
+		return true
+	}
+
+	if !ctx.framework.JSONAcceptIsAPI {
+		return false
+	}
+
+	if _, err := r.Cookie(ctx.framework.JWTCookieName); err == nil {
+		return false
+	}
+
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// IsBearerAuthenticated returns true if the session token was presented in an
+// Authorization header rather than a cookie (see Framework.AllowBearerToken).
+func (ctx *RequestContext) IsBearerAuthenticated() bool {
+	return ctx.bearerToken
+}
+
+// RedirectOrJSON serves both browsers and API clients from one handler,
+// following the post/redirect/get pattern: browsers are redirected to path
+// with the given status (such as 303), while API requests (see IsAPIRequest)
+// receive v as ctx.JSONResponse does.
+func (ctx *RequestContext) RedirectOrJSON(path string, status int, v interface{}) Response {
+	if ctx.IsAPIRequest() {
+		return ctx.JSONResponse(v)
+	}
+
+	return RedirectResponse(path, status)
+}
+
+// TokenMessage is the body of a TokenResponse.
+type TokenMessage struct {
+	Token     string    `json:"token"`
+	TokenType string    `json:"token_type"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// TokenResponse returns a response containing the signed session token and its
+// expiry, allowing API clients, to which no session cookie is sent, to obtain
+// the token after signing in. The token reflects the session as it stands
+// once the handler has finished. Requests other than API requests (see
+// IsAPIRequest) receive ctx.ForbiddenResponse() instead.
+func (ctx *RequestContext) TokenResponse() Response {
+	if !ctx.IsAPIRequest() {
+		return ctx.ForbiddenResponse()
+	}
+
+	return ResponseFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := ctx.framework.signToken(ctx.token)
+		if err != nil {
+			ctx.ErrorResponse(err, http.StatusInternalServerError).ServeHTTP(w, r)
+			return
+		}
+
+		JSONResponse(TokenMessage{
+			Token:     token,
+			TokenType: "Bearer",
+			ExpiresAt: ctx.requestTime.Add(ctx.framework.SessionDuration),
+		}).ServeHTTP(w, r)
+	})
+}
+
+// XSRFFormField is the name of the form field from which XSRFMiddleware reads
+// the XSRF token when the X-XSRF-Token header is absent.
+const XSRFFormField = "xsrf_token"
+
+// XSRFToken gets the session XSRF token. Until it is first rotated it is the
+// session ID.
 func (ctx *RequestContext) XSRFToken() string {
+	if token, ok := ctx.token.Claims["xsrf"].(string); ok {
+		return token
+	}
+
 	return ctx.SessionID()
 }
 
-// SessionID gets the session identifier.
+// RotateXSRFToken replaces the XSRF token with a new one, leaving the session
+// intact. The new token is sent in the XSRF cookie with the response, and the
+// old one is no longer accepted. Handlers may call it after sensitive changes
+// of state.
+func (ctx *RequestContext) RotateXSRFToken() {
+	ctx.token.Claims["xsrf"] = ctx.framework.newID()
+}
+
+// CSRFField renders a hidden input holding the session XSRF token, for
+// inclusion in HTML forms submitted to routes guarded by XSRFMiddleware.
+func (ctx *RequestContext) CSRFField() template.HTML {
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+		XSRFFormField, template.HTMLEscapeString(ctx.XSRFToken())))
+}
+
+// SessionID gets the session identifier, which is stable across all requests
+// made within the session.
 func (ctx *RequestContext) SessionID() string {
 	if sessionID, ok := ctx.token.Claims["jti"]; ok {
 		return sessionID.(string)
@@ -215,9 +681,100 @@ func (ctx *RequestContext) SessionID() string {
 	return ""
 }
 
-// ReadJSONUnsafe deserializes a JSON encoded request body.
+// RouteTemplate returns the path template of the route which matched the
+// request, such as "/users/{id}", or the empty string if none did. Unlike the
+// path, it is suitable as a low-cardinality label for metrics.
+func (ctx *RequestContext) RouteTemplate() string {
+	return routeTemplate(ctx.Request)
+}
+
+// RequestID gets the identifier unique to the current request.
+func (ctx *RequestContext) RequestID() string {
+	return ctx.requestID
+}
+
+// Context returns the context of the request, which is cancelled when the
+// client disconnects or when a deadline set by Route.Timeout or
+// TimeoutMiddleware passes. Handlers should pass it to database and HTTP calls
+// so that they are abandoned along with the request.
+func (ctx *RequestContext) Context() stdcontext.Context {
+	return ctx.Request.Context()
+}
+
+// WithValue replaces the context of the request with one carrying val under
+// key.
+func (ctx *RequestContext) WithValue(key, val interface{}) {
+	ctx.Request = ctx.Request.WithContext(stdcontext.WithValue(ctx.Context(), key, val))
+}
+
+// Deadline returns the time at which the context of the request will be
+// cancelled, if one has been set.
+func (ctx *RequestContext) Deadline() (time.Time, bool) {
+	return ctx.Context().Deadline()
+}
+
+// ReadJSONUnsafe deserializes a JSON encoded request body, decompressing it
+// first if it has a gzip or deflate content encoding. Bodies larger than
+// Framework.MaxRequestBody are rejected with ErrRequestBodyTooLarge. Bodies
+// nested more deeply than Framework.MaxJSONDepth, or containing more tokens
+// than Framework.MaxJSONTokens, are rejected with ErrJSONTooDeep or
+// ErrJSONTooManyTokens before they are parsed. Bodies whose Content-Type is
+// not JSON are rejected with ErrUnsupportedMediaType unless
+// Framework.LaxJSONContentType is set.
 func (ctx *RequestContext) ReadJSONUnsafe(v interface{}) error {
-	return json.NewDecoder(ctx.Request.Body).Decode(v)
+	if err := ctx.checkJSONContentType(); err != nil {
+		return err
+	}
+
+	if err := ctx.decodeBody(); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(ctx.newJSONLimitReader(ctx.Request.Body))
+	if ctx.framework.UseJSONNumber {
+		dec.UseNumber()
+	}
+
+	return dec.Decode(v)
+}
+
+// readJSONFormatted deserializes a JSON encoded request body, accepting values
+// in the formats configured on the framework.
+func (ctx *RequestContext) readJSONFormatted(v interface{}) error {
+	if !ctx.framework.hasCustomFormats() {
+		return ctx.ReadJSONUnsafe(v)
+	}
+
+	if err := ctx.checkJSONContentType(); err != nil {
+		return err
+	}
+
+	if err := ctx.decodeBody(); err != nil {
+		return err
+	}
+
+	var doc interface{}
+	dec := json.NewDecoder(ctx.newJSONLimitReader(ctx.Request.Body))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return err
+	}
+
+	doc, err := ctx.framework.normalizeJSON(doc, reflect.TypeOf(v))
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	if ctx.framework.UseJSONNumber {
+		return decodeJSONNumbers(data, v)
+	}
+
+	return json.Unmarshal(data, v)
 }
 
 func unmarshalerFor(rv reflect.Value) reflect.Type {
@@ -231,6 +788,36 @@ func unmarshalerFor(rv reflect.Value) reflect.Type {
 	return nil
 }
 
+// marshalerFor returns the json.Marshaler implemented by rv (or by its address
+// when rv is addressable), provided that its type carries no read rights which
+// would be bypassed by the custom marshaling.
+func marshalerFor(rv reflect.Value) (json.Marshaler, bool) {
+	ty := rv.Type()
+	if hasReadRights(ty) {
+		return nil, false
+	}
+
+	if ty.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, false
+		}
+		return marshalerFor(rv.Elem())
+	}
+
+	if ty.Implements(marshalerType) {
+		if ty.Kind() == reflect.Ptr && rv.IsNil() {
+			return nil, false
+		}
+		return rv.Interface().(json.Marshaler), true
+	}
+
+	if rv.CanAddr() && reflect.PtrTo(ty).Implements(marshalerType) {
+		return rv.Addr().Interface().(json.Marshaler), true
+	}
+
+	return nil, false
+}
+
 // This is wrong but works well enough for our app.
 func isRecursibleType(rv reflect.Value) bool {
 	ty := rv.Type()
@@ -248,15 +835,9 @@ func (ctx *RequestContext) safeMerge(src, dst reflect.Value) (err error) {
 	for i := 0; i < dst.NumField(); i++ {
 		w := ty.Field(i).Tag.Get("writeRight")
 		if w == "" || ctx.HasRight(w) {
-			srcField := src.Field(i)
-			dstField := dst.Field(i)
-			if isRecursibleType(srcField) {
-				err = ctx.safeMerge(srcField, dstField)
-				if err != nil {
-					return
-				}
-			} else {
-				dstField.Set(srcField)
+			err = ctx.safeMergeValue(src.Field(i), dst.Field(i))
+			if err != nil {
+				return
 			}
 		}
 	}
@@ -264,11 +845,41 @@ func (ctx *RequestContext) safeMerge(src, dst reflect.Value) (err error) {
 	return nil
 }
 
-func (ctx *RequestContext) safeSerializeStruct(src reflect.Value, out map[string]interface{}) (interface{}, error) {
-	if out == nil {
-		out = make(map[string]interface{})
+// safeMergeValue merges src into dst, recursing into structs and the elements
+// of fixed-size arrays so that nested writeRight tags are respected.
+func (ctx *RequestContext) safeMergeValue(src, dst reflect.Value) error {
+	if isRecursibleType(src) {
+		return ctx.safeMerge(src, dst)
 	}
 
+	if src.Kind() == reflect.Array && unmarshalerFor(src) == nil {
+		for i := 0; i < src.Len(); i++ {
+			if err := ctx.safeMergeValue(src.Index(i), dst.Index(i)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	dst.Set(src)
+	return nil
+}
+
+func (ctx *RequestContext) safeSerializeStruct(src reflect.Value) (interface{}, error) {
+	var out orderedObject
+	if err := ctx.safeSerializeFields(src, &out); err != nil {
+		return nil, err
+	}
+
+	if ctx.framework.PreserveFieldOrder {
+		return out, nil
+	}
+
+	return out.Map(), nil
+}
+
+func (ctx *RequestContext) safeSerializeFields(src reflect.Value, out *orderedObject) error {
 	ty := src.Type()
 	for i := 0; i < src.NumField(); i++ {
 		field := ty.Field(i)
@@ -283,9 +894,8 @@ func (ctx *RequestContext) safeSerializeStruct(src reflect.Value, out map[string
 
 			// if its anonymous merge in the child fields
 			if name == "" && field.Name == "" {
-				_, err := ctx.safeSerializeStruct(src, out)
-				if err != nil {
-					return nil, err
+				if err := ctx.safeSerializeFields(src, out); err != nil {
+					return err
 				}
 
 				continue
@@ -297,13 +907,13 @@ func (ctx *RequestContext) safeSerializeStruct(src reflect.Value, out map[string
 
 			val, err := ctx.safeSerialize(src.Field(i))
 			if err != nil {
-				return nil, err
+				return err
 			}
 
-			out[name] = val
+			out.Set(name, val)
 		}
 	}
-	return out, nil
+	return nil
 }
 
 func (ctx *RequestContext) safeSerializeSlice(src reflect.Value) (interface{}, error) {
@@ -318,23 +928,84 @@ func (ctx *RequestContext) safeSerializeSlice(src reflect.Value) (interface{}, e
 	return slice, nil
 }
 
+func (ctx *RequestContext) safeSerializeMap(src reflect.Value) (interface{}, error) {
+	if src.IsNil() {
+		return nil, nil
+	}
+
+	out := make(map[string]interface{}, src.Len())
+	for _, key := range src.MapKeys() {
+		name, err := mapKeyString(key)
+		if err != nil {
+			return nil, err
+		}
+
+		val, err := ctx.safeSerialize(src.MapIndex(key))
+		if err != nil {
+			return nil, err
+		}
+
+		out[name] = val
+	}
+
+	return out, nil
+}
+
+// mapKeyString converts a map key to an object key as encoding/json does.
+func mapKeyString(key reflect.Value) (string, error) {
+	if key.Kind() == reflect.String {
+		return key.String(), nil
+	}
+
+	if m, ok := key.Interface().(encoding.TextMarshaler); ok {
+		text, err := m.MarshalText()
+		return string(text), err
+	}
+
+	switch key.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(key.Uint(), 10), nil
+	}
+
+	return "", ErrTypeError
+}
+
 // safeSerialize recursively converts a struct into a map[string]interface{}
 // omitting fields for which the current context lacks the "read" right.
 func (ctx *RequestContext) safeSerialize(src reflect.Value) (ifc interface{}, err error) {
+	if t, ok := timeValue(src); ok && ctx.framework.TimeFormat != "" {
+		return ctx.framework.formatTime(t), nil
+	}
+
+	if d, ok := durationValue(src); ok && ctx.framework.DurationFormat != DurationFormatNanoseconds {
+		return ctx.framework.formatDuration(d), nil
+	}
+
+	if marshaler, ok := marshalerFor(src); ok {
+		return marshaler, nil
+	}
+
 	if unmarshaler := unmarshalerFor(src); unmarshaler != nil {
 		return src.Interface(), nil
 	}
 
 	switch src.Type().Kind() {
-	case reflect.Slice:
+	case reflect.Slice, reflect.Array:
 		ifc, err = ctx.safeSerializeSlice(src)
 	case reflect.Struct:
 		if m, ok := src.Interface().(encoding.TextMarshaler); ok {
 			ifc, err = m, nil
 		} else {
-			ifc, err = ctx.safeSerializeStruct(src, nil)
+			ifc, err = ctx.safeSerializeStruct(src)
+		}
+	case reflect.Map:
+		ifc, err = ctx.safeSerializeMap(src)
+	case reflect.Ptr, reflect.Interface:
+		if src.IsNil() {
+			return nil, nil
 		}
-	case reflect.Ptr:
 		ifc, err = ctx.safeSerialize(src.Elem())
 	default:
 		ifc, err = src.Interface(), nil
@@ -345,10 +1016,16 @@ func (ctx *RequestContext) safeSerialize(src reflect.Value) (ifc interface{}, er
 
 // ReadJSON sets fields of v if the principal possesses the required rights.
 func (ctx *RequestContext) ReadJSON(v interface{}) error {
+	return ctx.readMerged(v, ctx.readJSONFormatted)
+}
+
+// readMerged decodes into a new value of the type v points to, then merges the
+// fields which the principal has the right to write into v.
+func (ctx *RequestContext) readMerged(v interface{}, decode func(interface{}) error) error {
 	rv := reflect.ValueOf(v)
 	ru := reflect.New(rv.Elem().Type()).Elem()
 
-	err := ctx.ReadJSONUnsafe(ru.Addr().Interface())
+	err := decode(ru.Addr().Interface())
 	if err != nil {
 		return err
 	}
@@ -374,6 +1051,32 @@ func (ctx *RequestContext) MakeJSONResponse(v interface{}) (Response, error) {
 	return JSONResponse(out), nil
 }
 
+// CreatedResponse returns a 201 response containing v, serialized as by
+// JSONResponse, with a Location header pointing at the created resource. A
+// relative location is resolved against the request URL.
+func (ctx *RequestContext) CreatedResponse(location string, v interface{}) Response {
+	out, err := ctx.safeSerialize(reflect.ValueOf(v))
+	if err != nil {
+		return ctx.ErrorResponse(err, http.StatusInternalServerError)
+	}
+
+	if u, err := url.Parse(location); err == nil {
+		location = ctx.Request.URL.ResolveReference(u).String()
+	}
+
+	return ResponseFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", location)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(out)
+	})
+}
+
+// NoContent returns a 204 response without a body.
+func (ctx *RequestContext) NoContent() Response {
+	return NoContentResponse()
+}
+
 // CustomErrorMessage returns an error message to be shown to the user for a
 // given error. This function acts as a hook allowing the framework to control
 // what response the user may see.
@@ -389,11 +1092,27 @@ func (ctx *RequestContext) errorMakeErrorContext(err error, status int, ectx *sn
 	ectx.Details = snitch.NewErrorDetails()
 	ectx.Details["status"] = status
 	ectx.Details["session_id"] = ctx.SessionID()
+	ectx.Details["request_id"] = ctx.RequestID()
 	ectx.Details["user_id"] = ctx.UserID()
 	ectx.Details["username"] = ctx.Username()
 	ectx.Details["is_authenticated"] = ctx.IsAuthenticated()
 	ectx.Details["url"] = ctx.Request.URL.String()
 	ectx.Details["host"] = ctx.Request.Host
+	if route := ctx.RouteTemplate(); route != "" {
+		ectx.Details["route"] = route
+	}
+	if ctx.tenantID != "" {
+		ectx.Details["tenant_id"] = ctx.tenantID
+	}
+
+	device := ctx.DeviceInfo()
+	ectx.Details["user_agent"] = ctx.UserAgent()
+	ectx.Details["device"] = map[string]interface{}{
+		"browser":         device.Browser,
+		"browser_version": device.BrowserVersion,
+		"os":              device.OS,
+		"mobile":          device.Mobile,
+	}
 }
 
 // BlankErrorResponse logs an error and returns a blank response
@@ -418,7 +1137,84 @@ func (ctx *RequestContext) CustomErrorResponse(err error, friendly string, statu
 		ctx.NotifyError(err, status)
 	}
 
-	return ErrorResponse(ctx.CustomErrorMessage(err, friendly), status)
+	message := ctx.CustomErrorMessage(err, friendly)
+	if ctx.framework.ErrorTemplate != nil {
+		return ctx.errorPageResponse(message, status)
+	}
+
+	return ErrorResponse(message, status)
+}
+
+// UnauthorizedResponse returns the response for a request which is not
+// authorized, as configured by Framework.UnauthorizedResponse.
+func (ctx *RequestContext) UnauthorizedResponse() Response {
+	if fn := ctx.framework.UnauthorizedResponse; fn != nil {
+		return fn(ctx)
+	}
+
+	return EmptyJSONResponse(http.StatusUnauthorized)
+}
+
+// ForbiddenResponse returns the response for an authenticated request lacking
+// a required right, as configured by Framework.ForbiddenResponse.
+func (ctx *RequestContext) ForbiddenResponse() Response {
+	if fn := ctx.framework.ForbiddenResponse; fn != nil {
+		return fn(ctx)
+	}
+
+	return EmptyJSONResponse(http.StatusForbidden)
+}
+
+// PanicResponse returns the response for a request whose handler panicked, as
+// configured by Framework.PanicResponse.
+func (ctx *RequestContext) PanicResponse(recovered interface{}, ref string) Response {
+	if fn := ctx.framework.PanicResponse; fn != nil {
+		return fn(ctx, recovered, ref)
+	}
+
+	return ErrorResponse(ctx.framework.DefaultErrorText, http.StatusInternalServerError)
+}
+
+// PayloadTooLargeResponse returns the response for a request whose body is too
+// large, as configured by Framework.PayloadTooLargeResponse.
+func (ctx *RequestContext) PayloadTooLargeResponse() Response {
+	if fn := ctx.framework.PayloadTooLargeResponse; fn != nil {
+		return fn(ctx)
+	}
+
+	return ErrorResponse("The request body is too large.", http.StatusRequestEntityTooLarge)
+}
+
+// HeadersTooLargeResponse returns the response for a request whose headers are
+// too large, as configured by Framework.HeadersTooLargeResponse.
+func (ctx *RequestContext) HeadersTooLargeResponse() Response {
+	if fn := ctx.framework.HeadersTooLargeResponse; fn != nil {
+		return fn(ctx)
+	}
+
+	return ErrorResponse("The request headers are too large.", http.StatusRequestHeaderFieldsTooLarge)
+}
+
+// UnsupportedMediaTypeResponse returns the response for a request whose body is
+// in an unsupported format or encoding, as configured by
+// Framework.UnsupportedMediaTypeResponse.
+func (ctx *RequestContext) UnsupportedMediaTypeResponse() Response {
+	if fn := ctx.framework.UnsupportedMediaTypeResponse; fn != nil {
+		return fn(ctx)
+	}
+
+	return ErrorResponse("The request body is in an unsupported format.", http.StatusUnsupportedMediaType)
+}
+
+// ProblemResponse returns an RFC 7807 problem response. The detail is replaced
+// by the error message if the context may see errors.
+func (ctx *RequestContext) ProblemResponse(err error, problem Problem, status int) Response {
+	if status >= 500 {
+		ctx.NotifyError(err, status)
+	}
+
+	problem.Detail = ctx.CustomErrorMessage(err, problem.Detail)
+	return ProblemResponse(problem, status)
 }
 
 // NotifyError
@@ -428,6 +1224,47 @@ func (ctx *RequestContext) NotifyError(err error, status int) {
 	ctx.framework.Notify(&ectx)
 }
 
+// AddError records a non-fatal error to be reported, together with any others
+// recorded during the request, once the response has been served.
+func (ctx *RequestContext) AddError(err error) {
+	if err != nil {
+		ctx.errors = append(ctx.errors, err)
+	}
+}
+
+// SetErrorStatus sets the status reported alongside the errors recorded by
+// AddError. If unset, 500 is reported.
+func (ctx *RequestContext) SetErrorStatus(status int) {
+	ctx.errorStatus = status
+}
+
+// flushErrors reports the errors recorded by AddError as a single aggregated
+// error.
+func (ctx *RequestContext) flushErrors() {
+	if len(ctx.errors) == 0 {
+		return
+	}
+
+	status := ctx.errorStatus
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+
+	messages := make([]string, len(ctx.errors))
+	for i, err := range ctx.errors {
+		messages[i] = err.Error()
+	}
+
+	err := fmt.Errorf("%d errors: %s", len(messages), strings.Join(messages, "; "))
+
+	var ectx snitch.ErrorContext
+	ctx.errorMakeErrorContext(err, status, &ectx)
+	ectx.Details["errors"] = messages
+	ctx.framework.Notify(&ectx)
+
+	ctx.errors = nil
+}
+
 // TemplateResponse constructs a response which renders a template.
 func (ctx *RequestContext) TemplateResponse(template *template.Template, templateName string, data interface{}) ResponseFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -450,6 +1287,60 @@ func (ctx *RequestContext) SetBase64JSONCookie(name string, value interface{}) e
 	return nil
 }
 
+// SetSignedBase64JSONCookie sets a cookie with a base64 encoded json followed
+// by a dot and an HMAC signature of the cookie's name and json, so that the
+// client may read the json but the server can detect it being altered. The
+// signature is keyed with SessionSecret; ErrNoSigningKey is returned and no
+// cookie is set if it is empty.
+func (ctx *RequestContext) SetSignedBase64JSONCookie(name string, value interface{}) error {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	data := base64.URLEncoding.EncodeToString(bytes)
+	signature, err := ctx.framework.signCookie(name, data)
+	if err != nil {
+		return err
+	}
+
+	ctx.SetCookie(name, data+"."+signature, false)
+	return nil
+}
+
+// ReadSignedBase64JSONCookie decodes into v the json of a cookie set by
+// SetSignedBase64JSONCookie, returning ErrInvalidCookieSignature if the cookie
+// is malformed or its signature does not match, and ErrNoSigningKey if
+// SessionSecret is empty.
+func (ctx *RequestContext) ReadSignedBase64JSONCookie(name string, v interface{}) error {
+	cookie, err := ctx.Request.Cookie(name)
+	if err != nil {
+		return err
+	}
+
+	i := strings.LastIndex(cookie.Value, ".")
+	if i < 0 {
+		return ErrInvalidCookieSignature
+	}
+
+	data, signature := cookie.Value[:i], cookie.Value[i+1:]
+	expected, err := ctx.framework.signCookie(name, data)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrInvalidCookieSignature
+	}
+
+	bytes, err := base64.URLEncoding.DecodeString(data)
+	if err != nil {
+		return ErrInvalidCookieSignature
+	}
+
+	return json.Unmarshal(bytes, v)
+}
+
 // SetCookie creates a cookie.
 func (ctx *RequestContext) SetCookie(name, value string, httpOnly bool) {
 	http.SetCookie(ctx.ResponseWriter, &http.Cookie{
@@ -463,6 +1354,26 @@ func (ctx *RequestContext) SetCookie(name, value string, httpOnly bool) {
 	})
 }
 
+// Cookies returns the cookies which have been set on the response so far
+// during the request, in the order they were set.
+func (ctx *RequestContext) Cookies() []*http.Cookie {
+	response := http.Response{Header: ctx.ResponseWriter.Header()}
+	return response.Cookies()
+}
+
+// ResponseCookie returns the cookie most recently set on the response with the
+// given name, or nil if there is none.
+func (ctx *RequestContext) ResponseCookie(name string) *http.Cookie {
+	var found *http.Cookie
+	for _, cookie := range ctx.Cookies() {
+		if cookie.Name == name {
+			found = cookie
+		}
+	}
+
+	return found
+}
+
 // DeleteCookie deletes a cookie on the request object.
 func (ctx *RequestContext) DeleteCookie(name string) {
 	ctx.framework.DeleteCookie(ctx.ResponseWriter, name)
@@ -471,5 +1382,9 @@ func (ctx *RequestContext) DeleteCookie(name string) {
 // DestroySession instructs the client to delete all framework cookies
 // containing session data.
 func (ctx *RequestContext) DestroySession() {
+	if ctx.IsAuthenticated() && !ctx.destroyingSession {
+		ctx.audit(AuditLogout, ctx.Username(), AuditSuccess)
+	}
+
 	ctx.destroyingSession = true
 }
@@ -0,0 +1,927 @@
+package chopshop
+
+import (
+	"bytes"
+	stdcontext "context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestContextCancel(t *testing.T) {
+	type key struct{}
+
+	tests := []struct {
+		name       string
+		middleware []Middleware
+		handler    ContextHandlerFunc
+		cancel     bool
+		cancelled  bool
+	}{
+		{
+			name: "client disconnects",
+			handler: func(ctx *RequestContext) Response {
+				if _, ok := ctx.Deadline(); ok {
+					t.Error("unexpected deadline")
+				}
+				<-ctx.Context().Done()
+				return nil
+			},
+			cancel:    true,
+			cancelled: true,
+		},
+		{
+			name: "middleware context cancelled",
+			middleware: []Middleware{func(fn ContextHandlerFunc) ContextHandlerFunc {
+				return func(ctx *RequestContext) Response {
+					c, cancel := stdcontext.WithCancel(ctx.Context())
+					ctx.Request = ctx.Request.WithContext(c)
+					defer cancel()
+					return fn(ctx)
+				}
+			}},
+			cancelled: true,
+		},
+		{
+			name: "value",
+			handler: func(ctx *RequestContext) Response {
+				ctx.WithValue(key{}, "v")
+				if ctx.Context().Value(key{}) != "v" {
+					t.Error("value not set")
+				}
+				return nil
+			},
+		},
+		{
+			name:       "deadline",
+			middleware: []Middleware{TimeoutMiddleware(time.Minute)},
+			handler: func(ctx *RequestContext) Response {
+				if d, ok := ctx.Deadline(); !ok || time.Until(d) > time.Minute {
+					t.Error("deadline", d, ok)
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		f, _ := NewFramework("app", "")
+		f.SessionSecret = []byte("secret")
+
+		response := &cancelRecorder{
+			ResponseFunc: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("served")) },
+			cancelled:    make(chan struct{}),
+		}
+
+		handler := test.handler
+		f.Path("/").Middleware(test.middleware...).Handler(func(ctx *RequestContext) Response {
+			if handler != nil {
+				handler(ctx)
+			}
+			return response
+		})
+
+		c, cancel := stdcontext.WithCancel(stdcontext.Background())
+		if test.cancel {
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				cancel()
+			}()
+		}
+
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest("GET", "/", nil).WithContext(c))
+		cancel()
+
+		cancelled := false
+		select {
+		case <-response.cancelled:
+			cancelled = true
+		default:
+		}
+
+		if cancelled != test.cancelled {
+			t.Errorf("%s: cancelled %v, want %v", test.name, cancelled, test.cancelled)
+		}
+
+		if served := w.Body.String() == "served"; served == test.cancelled {
+			t.Errorf("%s: served %v", test.name, served)
+		}
+	}
+}
+
+func TestAddTemporaryRight(t *testing.T) {
+	tests := []struct {
+		name    string
+		rights  []string
+		advance time.Duration
+		err     error
+		has     bool
+	}{
+		{"anonymous", nil, 0, ErrSessionNotAuthenticated, false},
+		{"granted", []string{"a"}, 0, nil, true},
+		{"expiring", []string{"a"}, 58 * time.Second, nil, true},
+		{"expired", []string{"a"}, 2 * time.Minute, nil, false},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t, tt.rights...)
+		if err := ctx.AddTemporaryRight("tfa", time.Minute); err != tt.err {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+
+		ctx.requestTime = ctx.requestTime.Add(tt.advance)
+		if has := ctx.HasRight("tfa"); has != tt.has {
+			t.Errorf("%s: HasRight %t, want %t", tt.name, has, tt.has)
+		}
+	}
+}
+
+func TestAddError(t *testing.T) {
+	tests := []struct {
+		name   string
+		errors []error
+		status int
+		want   string
+		report int
+	}{
+		{"none", nil, 0, "", 0},
+		{"nil", []error{nil}, 0, "", 0},
+		{"one", []error{errors.New("a")}, 0, "Server Error: 1 errors: a", http.StatusInternalServerError},
+		{"several", []error{errors.New("a"), nil, errors.New("b")}, http.StatusBadGateway, "Server Error: 2 errors: a; b", http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		f, ctx, w := newTestContext(t)
+		reporter := newErrorRecorder()
+		f.ErrorReporter = reporter
+
+		f.ServeContext(ctx, func(ctx *RequestContext) Response {
+			for _, err := range tt.errors {
+				ctx.AddError(err)
+			}
+			if tt.status != 0 {
+				ctx.SetErrorStatus(tt.status)
+			}
+			return BlankResponse(http.StatusOK)
+		})
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %d", tt.name, w.Code)
+		}
+
+		if tt.want == "" {
+			if len(reporter.errors) != 0 {
+				t.Errorf("%s: reported %q", tt.name, reporter.errors[0].Error)
+			}
+			continue
+		}
+
+		if len(reporter.errors) != 1 {
+			t.Fatalf("%s: %d reports, want 1", tt.name, len(reporter.errors))
+		}
+
+		ectx := reporter.errors[0]
+		if ectx.Error != tt.want || ectx.Details["status"] != tt.report {
+			t.Errorf("%s: reported %q with status %v", tt.name, ectx.Error, ectx.Details["status"])
+		}
+	}
+}
+
+func TestResponseCookie(t *testing.T) {
+	_, ctx, _ := newTestContext(t)
+	ctx.SetCookie("a", "1", false)
+	ctx.SetCookie("b", "x", false)
+	ctx.SetCookie("a", "2", true)
+
+	tests := []struct {
+		name     string
+		value    string
+		httpOnly bool
+		found    bool
+	}{
+		{"a", "2", true, true},
+		{"b", "x", false, true},
+		{"missing", "", false, false},
+	}
+
+	for _, tt := range tests {
+		c := ctx.ResponseCookie(tt.name)
+		if (c != nil) != tt.found {
+			t.Errorf("%s: found %t, want %t", tt.name, c != nil, tt.found)
+			continue
+		}
+
+		if c != nil && (c.Value != tt.value || c.HttpOnly != tt.httpOnly) {
+			t.Errorf("%s: got %q HttpOnly %t, want %q %t", tt.name, c.Value, c.HttpOnly, tt.value, tt.httpOnly)
+		}
+	}
+
+	if n := len(ctx.Cookies()); n != 3 {
+		t.Errorf("%d cookies, want 3", n)
+	}
+}
+
+func TestErrorDetailsIdentifyRequest(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.SessionSecret = []byte("secret")
+	f.NewID = NewTestIDGen("id").Next
+	reporter := newErrorRecorder()
+	f.ErrorReporter = reporter
+
+	f.Path("/").Handler(func(ctx *RequestContext) Response {
+		ctx.NotifyError(errors.New("failed"), http.StatusInternalServerError)
+		return BlankResponse(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	session := reqWith((&http.Response{Header: w.Header()}).Cookies())
+	f.ServeHTTP(httptest.NewRecorder(), session)
+
+	tests := []struct {
+		sessionID string
+		requestID string
+	}{
+		{"id-1", "id-2"},
+		{"id-1", "id-3"},
+	}
+
+	if len(reporter.errors) != len(tests) {
+		t.Fatalf("%d reports, want %d", len(reporter.errors), len(tests))
+	}
+
+	for i, tt := range tests {
+		details := reporter.errors[i].Details
+		if details["session_id"] != tt.sessionID || details["request_id"] != tt.requestID {
+			t.Errorf("%d: session %v request %v, want %s %s", i, details["session_id"], details["request_id"], tt.sessionID, tt.requestID)
+		}
+	}
+}
+
+func TestCreatedResponse(t *testing.T) {
+	type widget struct {
+		ID     int    `json:"id"`
+		Secret string `json:"secret" readWrite:"admin"`
+	}
+
+	tests := []struct {
+		url      string
+		location string
+		want     string
+	}{
+		{"/api/widgets/", "7", "/api/widgets/7"},
+		{"/api/widgets", "widgets/7", "/api/widgets/7"},
+		{"/api/widgets/", "/other/7", "/other/7"},
+		{"/api/widgets/", "https://cdn.example.com/7", "https://cdn.example.com/7"},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t, "user")
+		ctx.Request, _ = http.NewRequest("POST", tt.url, nil)
+
+		w := httptest.NewRecorder()
+		ctx.CreatedResponse(tt.location, widget{7, "s"}).ServeHTTP(w, ctx.Request)
+		if w.Code != http.StatusCreated || w.Header().Get("Location") != tt.want {
+			t.Errorf("%s %s: status %d Location %q, want %q", tt.url, tt.location, w.Code, w.Header().Get("Location"), tt.want)
+		}
+
+		if w.Body.String() != "{\"id\":7}\n" {
+			t.Errorf("%s %s: body %q", tt.url, tt.location, w.Body.String())
+		}
+	}
+}
+
+func TestIsAPIRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     map[string]string
+		cookie     bool
+		jsonAccept bool
+		override   func(*RequestContext) bool
+		api        bool
+	}{
+		{"browser", map[string]string{"Accept": "text/html"}, false, false, nil, false},
+		{"authorization", map[string]string{"Authorization": "Bearer x"}, false, false, nil, true},
+		{"accepts json", map[string]string{"Accept": "application/json"}, false, false, nil, false},
+		{"accepts json opted in", map[string]string{"Accept": "application/json"}, false, true, nil, true},
+		{"accepts json with session", map[string]string{"Accept": "application/json"}, true, true, nil, false},
+		{"override", map[string]string{"Accept": "text/html"}, false, false, func(*RequestContext) bool { return true }, true},
+	}
+
+	for _, tt := range tests {
+		f, _ := NewFramework("test", "")
+		f.SessionSecret = []byte("secret")
+		f.JSONAcceptIsAPI = tt.jsonAccept
+		f.IsAPIRequest = tt.override
+
+		var api bool
+		f.Path("/").Handler(func(ctx *RequestContext) Response {
+			api = ctx.IsAPIRequest()
+			return BlankResponse(http.StatusNoContent)
+		})
+
+		r, _ := http.NewRequest("GET", "/", nil)
+		for k, v := range tt.header {
+			r.Header.Set(k, v)
+		}
+
+		if tt.cookie {
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			r.AddCookie(responseCookies(w)[f.JWTCookieName])
+		}
+
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, r)
+		if api != tt.api {
+			t.Errorf("%s: IsAPIRequest() = %v, want %v", tt.name, api, tt.api)
+		}
+
+		if cookies := len(w.Header()["Set-Cookie"]) != 0; cookies == tt.api {
+			t.Errorf("%s: cookies set %v for API request %v", tt.name, cookies, tt.api)
+		}
+	}
+}
+
+func TestTokenResponse(t *testing.T) {
+	now := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		accept string
+		status int
+	}{
+		{"api", "application/json", http.StatusOK},
+		{"browser", "text/html", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		f, _ := NewFramework("test", "")
+		f.SessionSecret = []byte("secret")
+		f.SessionDuration = time.Hour
+		f.JSONAcceptIsAPI = true
+		f.Clock = NewTestClock(now).Now
+		f.Path("/login").Handler(func(ctx *RequestContext) Response {
+			ctx.SetPrincipal("bob", 7, []string{"a"})
+			return ctx.TokenResponse()
+		})
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/login", nil)
+		r.Header.Set("Accept", tt.accept)
+		f.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+
+		if tt.status != http.StatusOK {
+			continue
+		}
+
+		var msg TokenMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &msg); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if msg.TokenType != "Bearer" || !msg.ExpiresAt.Equal(now.Add(time.Hour)) {
+			t.Errorf("%s: message %+v", tt.name, msg)
+		}
+
+		ctx, err := f.CreateRequestContext(httptest.NewRecorder(),
+			reqWith([]*http.Cookie{{Name: f.JWTCookieName, Value: msg.Token}}))
+		if err != nil || ctx.UserID() != 7 {
+			t.Errorf("%s: token does not carry the principal: %v", tt.name, err)
+		}
+	}
+}
+
+func TestRemoveRight(t *testing.T) {
+	tests := []struct {
+		rights []string
+		remove string
+		want   []string
+	}{
+		{[]string{"a", "b", "c"}, "a", []string{"b", "c"}},
+		{[]string{"c", "a", "b"}, "a", []string{"c", "b"}},
+		{[]string{"z", "b", "a"}, "a", []string{"z", "b"}},
+		{[]string{"z", "b"}, "a", []string{"z", "b"}},
+		{[]string{"a", "z", "a"}, "a", []string{"z"}},
+		{[]string{"a"}, "a", []string{}},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t, tt.rights...)
+		ctx.RemoveRight(tt.remove)
+		if !reflect.DeepEqual(ctx.principal.Rights, tt.want) || ctx.HasRight(tt.remove) {
+			t.Errorf("RemoveRight(%q) from %q: %q, want %q", tt.remove, tt.rights, ctx.principal.Rights, tt.want)
+		}
+	}
+
+	// anonymous sessions have no rights, so there is nothing to remove
+	_, ctx, _ := newTestContext(t)
+	ctx.RemoveRight("a")
+}
+
+func TestAddRightDeduplicates(t *testing.T) {
+	tests := []struct {
+		name   string
+		rights []string
+		add    []string
+		want   []string
+		err    error
+	}{
+		{"new", []string{"a"}, []string{"b"}, []string{"a", "b"}, nil},
+		{"repeated", []string{"a"}, []string{"b", "b", "b", "a"}, []string{"a", "b"}, nil},
+		{"anonymous", nil, []string{"a"}, nil, ErrSessionNotAuthenticated},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t, tt.rights...)
+		for _, right := range tt.add {
+			if err := ctx.AddRight(right); err != tt.err {
+				t.Errorf("%s: AddRight(%q) = %v, want %v", tt.name, right, err, tt.err)
+			}
+		}
+
+		var got []string
+		if ctx.principal != nil {
+			got = ctx.principal.Rights
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: rights %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSetRights(t *testing.T) {
+	tests := []struct {
+		name   string
+		rights []string
+		set    []string
+		want   []string
+		err    error
+	}{
+		{"replace", []string{"a"}, []string{"x", "y"}, []string{"x", "y"}, nil},
+		{"duplicates", []string{"a"}, []string{"x", "y", "x"}, []string{"x", "y"}, nil},
+		{"empty", []string{"a"}, nil, []string{}, nil},
+		{"anonymous", nil, []string{"x"}, nil, ErrSessionNotAuthenticated},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t, tt.rights...)
+		if err := ctx.SetRights(tt.set); err != tt.err {
+			t.Errorf("%s: SetRights = %v, want %v", tt.name, err, tt.err)
+		}
+
+		var got []string
+		if ctx.principal != nil {
+			got = ctx.principal.Rights
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: rights %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// the session's rights must not alias the caller's slice
+	_, ctx, _ := newTestContext(t, "a")
+	rights := []string{"x"}
+	ctx.SetRights(rights)
+	rights[0] = "admin"
+	if ctx.HasRight("admin") {
+		t.Error("SetRights kept the caller's slice")
+	}
+}
+
+func TestFieldRightsInArrays(t *testing.T) {
+	type child struct {
+		Name   string `json:"name"`
+		Secret string `json:"secret" readWrite:"admin" writeRight:"admin"`
+	}
+
+	type parent struct {
+		Kids [2]child `json:"kids"`
+	}
+
+	original := parent{Kids: [2]child{{"a", "s1"}, {"b", "s2"}}}
+
+	tests := []struct {
+		name   string
+		rights []string
+		body   string
+		out    string
+		read   parent
+	}{
+		{
+			"user",
+			[]string{"user"},
+			`{"kids":[{"name":"x","secret":"hack"},{"name":"y","secret":"hack"}]}`,
+			`{"kids":[{"name":"a"},{"name":"b"}]}`,
+			parent{Kids: [2]child{{"x", "s1"}, {"y", "s2"}}},
+		},
+		{
+			"admin",
+			[]string{"admin"},
+			`{"kids":[{"name":"x","secret":"new"}]}`,
+			`{"kids":[{"name":"a","secret":"s1"},{"name":"b","secret":"s2"}]}`,
+			// as with encoding/json, elements missing from the body are zeroed
+			parent{Kids: [2]child{{"x", "new"}, {}}},
+		},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t, tt.rights...)
+		out, err := ctx.safeSerialize(reflect.ValueOf(original))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if b, _ := json.Marshal(out); string(b) != tt.out {
+			t.Errorf("%s: serialized %s, want %s", tt.name, b, tt.out)
+		}
+
+		v := original
+		ctx.Request, _ = http.NewRequest("POST", "/", strings.NewReader(tt.body))
+		if err := ctx.ReadJSON(&v); err != nil {
+			t.Fatal(err)
+		}
+
+		if v != tt.read {
+			t.Errorf("%s: read %+v, want %+v", tt.name, v, tt.read)
+		}
+	}
+}
+
+func TestClaims(t *testing.T) {
+	tests := []struct {
+		key string
+		err error
+	}{
+		{"tenant", nil},
+		{"sub", ErrReservedClaim},
+		{"exp", ErrReservedClaim},
+		{"jti", ErrReservedClaim},
+		{"vars", ErrReservedClaim},
+		{"xsrf", ErrReservedClaim},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t)
+		before, existed := ctx.Claim(tt.key)
+		if err := ctx.SetClaim(tt.key, "acme"); err != tt.err {
+			t.Errorf("SetClaim(%q) = %v, want %v", tt.key, err, tt.err)
+		}
+
+		if v, _ := ctx.Claim(tt.key); tt.err == nil && v != "acme" || tt.err != nil && !reflect.DeepEqual(v, before) {
+			t.Errorf("%q: claim %v after SetClaim", tt.key, v)
+		}
+
+		if err := ctx.DeleteClaim(tt.key); err != tt.err {
+			t.Errorf("DeleteClaim(%q) = %v, want %v", tt.key, err, tt.err)
+		}
+
+		if _, ok := ctx.Claim(tt.key); ok != (tt.err != nil && existed) {
+			t.Errorf("%q: present %t after DeleteClaim", tt.key, ok)
+		}
+	}
+}
+
+func TestClaimPersists(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.SessionSecret = []byte("secret")
+
+	var tenant interface{}
+	f.Path("/set").Handler(func(ctx *RequestContext) Response {
+		ctx.SetClaim("tenant", "acme")
+		return BlankResponse(http.StatusNoContent)
+	})
+	f.Path("/get").Handler(func(ctx *RequestContext) Response {
+		tenant, _ = ctx.Claim("tenant")
+		return BlankResponse(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("GET", "/set", nil))
+	r := reqWith((&http.Response{Header: w.Header()}).Cookies())
+	r.URL.Path = "/get"
+	f.ServeHTTP(httptest.NewRecorder(), r)
+	if tenant != "acme" {
+		t.Errorf("claim %v in next request", tenant)
+	}
+}
+
+func TestTypedQueryVars(t *testing.T) {
+	_, ctx, _ := newTestContext(t)
+	ctx.Request = httptest.NewRequest("GET", "/?i=-5&u=7&b=true&f=1.5&g=zz", nil)
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"int", ctx.QueryVarInt("i", 1), -5},
+		{"int absent", ctx.QueryVarInt("x", 1), 1},
+		{"int malformed", ctx.QueryVarInt("g", 1), 1},
+		{"uint", ctx.QueryVarUint("u", 1), uint64(7)},
+		{"uint absent", ctx.QueryVarUint("x", 1), uint64(1)},
+		{"uint negative", ctx.QueryVarUint("i", 1), uint64(1)},
+		{"bool", ctx.QueryVarBool("b", false), true},
+		{"bool absent", ctx.QueryVarBool("x", true), true},
+		{"bool malformed", ctx.QueryVarBool("g", false), false},
+		{"float", ctx.QueryVarFloat("f", 0), 1.5},
+		{"float absent", ctx.QueryVarFloat("x", 2), 2.0},
+		{"float malformed", ctx.QueryVarFloat("g", 2), 2.0},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	errTests := []struct {
+		name string
+		want int
+		err  bool
+	}{
+		{"i", -5, false},
+		{"x", 0, true},
+		{"g", 0, true},
+	}
+
+	for _, tt := range errTests {
+		n, err := ctx.QueryVarIntErr(tt.name)
+		if n != tt.want || (err != nil) != tt.err {
+			t.Errorf("QueryVarIntErr(%q) = %d, %v", tt.name, n, err)
+		}
+	}
+
+	if _, err := ctx.QueryVarIntErr("x"); err != ErrQueryVarNotPresent {
+		t.Errorf("absent: %v, want ErrQueryVarNotPresent", err)
+	}
+}
+
+func TestQueryVars(t *testing.T) {
+	tests := []struct {
+		query string
+		name  string
+		want  []string
+	}{
+		{"tag=a&x=1&tag=b&tag=c", "tag", []string{"a", "b", "c"}},
+		{"tag=a", "tag", []string{"a"}},
+		{"tag=", "tag", []string{""}},
+		{"x=1", "tag", []string{}},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t)
+		ctx.Request = httptest.NewRequest("GET", "/?"+tt.query, nil)
+		if got := ctx.QueryVars(tt.name); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: QueryVars(%q) = %#v, want %#v", tt.query, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRouteVarNumbers(t *testing.T) {
+	vars := map[string]string{"user_id": "12", "post_id": "abc", "offset": "-3"}
+
+	tests := []struct {
+		name    string
+		uint    uint64
+		uintErr bool
+		int     int64
+		intErr  bool
+	}{
+		{"user_id", 12, false, 12, false},
+		{"offset", 0, true, -3, false},
+		{"post_id", 0, true, 0, true},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t)
+		ctx.routeVars = vars
+
+		n, err := ctx.RouteVarUint(tt.name)
+		if n != tt.uint || (err != nil) != tt.uintErr || err == ErrRouteVarNotPresent {
+			t.Errorf("RouteVarUint(%q) = %d, %v", tt.name, n, err)
+		}
+
+		i, err := ctx.RouteVarInt(tt.name)
+		if i != tt.int || (err != nil) != tt.intErr || err == ErrRouteVarNotPresent {
+			t.Errorf("RouteVarInt(%q) = %d, %v", tt.name, i, err)
+		}
+	}
+
+	_, ctx, _ := newTestContext(t)
+	ctx.routeVars = vars
+	if _, err := ctx.RouteVarUint("missing"); err != ErrRouteVarNotPresent {
+		t.Errorf("RouteVarUint(missing): %v", err)
+	}
+
+	if _, err := ctx.RouteVarInt("missing"); err != ErrRouteVarNotPresent {
+		t.Errorf("RouteVarInt(missing): %v", err)
+	}
+}
+
+func TestUser(t *testing.T) {
+	errLoad := errors.New("no such user")
+
+	tests := []struct {
+		name   string
+		rights []string
+		loader func(*RequestContext, uint64) (interface{}, error)
+		user   interface{}
+		err    error
+		calls  int
+	}{
+		{"loaded", []string{"a"}, func(_ *RequestContext, id uint64) (interface{}, error) { return id, nil }, uint64(1), nil, 1},
+		{"failed", []string{"a"}, func(*RequestContext, uint64) (interface{}, error) { return nil, errLoad }, nil, errLoad, 1},
+		{"anonymous", nil, func(_ *RequestContext, id uint64) (interface{}, error) { return id, nil }, nil, nil, 0},
+		{"no loader", []string{"a"}, nil, nil, nil, 0},
+	}
+
+	for _, tt := range tests {
+		f, ctx, _ := newTestContext(t, tt.rights...)
+		calls := 0
+		if tt.loader != nil {
+			f.UserLoader = func(ctx *RequestContext, id uint64) (interface{}, error) {
+				calls++
+				return tt.loader(ctx, id)
+			}
+		}
+
+		for i := 0; i < 3; i++ {
+			if user, err := ctx.User(); user != tt.user || err != tt.err {
+				t.Errorf("%s: User() = %v, %v, want %v, %v", tt.name, user, err, tt.user, tt.err)
+			}
+		}
+
+		if calls != tt.calls {
+			t.Errorf("%s: loader called %d times, want %d", tt.name, calls, tt.calls)
+		}
+	}
+}
+
+func TestRedirectOrJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   map[string]string
+		status   int
+		location string
+		body     string
+	}{
+		{"browser", map[string]string{"Accept": "text/html,application/xhtml+xml"}, http.StatusSeeOther, "/done", ""},
+		{"accepts json", map[string]string{"Accept": "application/json"}, http.StatusSeeOther, "/done", ""},
+		{"bearer", map[string]string{"Authorization": "Bearer x"}, http.StatusOK, "", "{\"id\":1}\n"},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t)
+		for k, v := range tt.header {
+			ctx.Request.Header.Set(k, v)
+		}
+
+		w := httptest.NewRecorder()
+		ctx.RedirectOrJSON("/done", http.StatusSeeOther, map[string]int{"id": 1}).ServeHTTP(w, ctx.Request)
+		if w.Code != tt.status || w.Header().Get("Location") != tt.location {
+			t.Errorf("%s: status %d, location %q", tt.name, w.Code, w.Header().Get("Location"))
+		}
+
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.name, w.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestRouteTemplate(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.SessionSecret = []byte("secret")
+
+	var handled, hooked string
+	f.AfterResponse = func(ctx *RequestContext, status int) { hooked = ctx.RouteTemplate() }
+
+	var buf bytes.Buffer
+	f.Path("/users/{id}").Middleware(LoggingMiddleware(log.New(&buf, "", 0))).Handler(func(ctx *RequestContext) Response {
+		handled = ctx.RouteTemplate()
+		return BlankResponse(http.StatusNoContent)
+	})
+	f.NotFound(func(ctx *RequestContext) Response {
+		handled = ctx.RouteTemplate()
+		return BlankResponse(http.StatusNotFound)
+	})
+
+	tests := []struct {
+		name     string
+		path     string
+		template string
+		logged   string
+	}{
+		{"matched", "/users/42", "/users/{id}", `route="/users/{id}"`},
+		{"not found", "/nope", "", ""},
+	}
+
+	for _, tt := range tests {
+		handled, hooked = "unset", "unset"
+		buf.Reset()
+
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.path, nil))
+		if handled != tt.template {
+			t.Errorf("%s: handler saw %q, want %q", tt.name, handled, tt.template)
+		}
+
+		if hooked != tt.template {
+			t.Errorf("%s: AfterResponse saw %q, want %q", tt.name, hooked, tt.template)
+		}
+
+		if !strings.Contains(buf.String(), tt.logged) {
+			t.Errorf("%s: logged %q, want %q", tt.name, buf.String(), tt.logged)
+		}
+	}
+}
+
+func TestUseJSONNumber(t *testing.T) {
+	type body struct {
+		ID    interface{}            `json:"id" writeRight:"public"`
+		Extra map[string]interface{} `json:"extra" writeRight:"public"`
+	}
+
+	tests := []struct {
+		name      string
+		useNumber bool
+		id        interface{}
+		extra     interface{}
+	}{
+		{"numbers", true, json.Number("9007199254740993"), json.Number("18446744073709551615")},
+		{"floats", false, float64(9007199254740993), float64(18446744073709551615)},
+	}
+
+	for _, tt := range tests {
+		f, _ := NewFramework("app", "")
+		f.SessionSecret = []byte("secret")
+		f.UseJSONNumber = tt.useNumber
+
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{"id": 9007199254740993, "extra": {"n": 18446744073709551615}}`))
+		r.Header.Set("Content-Type", "application/json")
+		ctx, _ := f.CreateRequestContext(httptest.NewRecorder(), r)
+		ctx.SetPrincipal("u", 1, []string{"public"})
+
+		var b body
+		if err := ctx.ReadJSON(&b); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if b.ID != tt.id {
+			t.Errorf("%s: id %T %v, want %T %v", tt.name, b.ID, b.ID, tt.id, tt.id)
+		}
+
+		if b.Extra["n"] != tt.extra {
+			t.Errorf("%s: extra %T %v, want %T %v", tt.name, b.Extra["n"], b.Extra["n"], tt.extra, tt.extra)
+		}
+	}
+}
+
+func TestRequireOneOf(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.SessionSecret = []byte("secret")
+
+	exactlyOne := func(ctx *RequestContext) error { return ctx.RequireExactlyOne("since", "page") }
+	atMostOne := func(ctx *RequestContext) error { return ctx.RequireAtMostOne("since", "page") }
+
+	tests := []struct {
+		name  string
+		check func(*RequestContext) error
+		query string
+		err   string
+	}{
+		{"exactly one given", exactlyOne, "since=1", ""},
+		{"exactly one missing", exactlyOne, "since=", "one of since, page is required"},
+		{"exactly one both", exactlyOne, "since=1&page=2", "only one of since, page may be given, not since and page"},
+		{"at most one given", atMostOne, "page=2&x=1", ""},
+		{"at most one missing", atMostOne, "", ""},
+		{"at most one both", atMostOne, "since=1&page=2", "only one of since, page may be given, not since and page"},
+	}
+
+	for _, tt := range tests {
+		ctx, _ := f.CreateRequestContext(httptest.NewRecorder(), httptest.NewRequest("GET", "/?"+tt.query, nil))
+		err := tt.check(ctx)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+
+		if errs, ok := err.(ValidationErrors); !ok || errs.Error() != tt.err {
+			t.Errorf("%s: error %#v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
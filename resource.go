@@ -0,0 +1,65 @@
+package chopshop
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Controller handles the requests for a resource mounted by Router.Resource.
+// It may implement any of Indexer, Shower, Creator, Updater and Destroyer;
+// routes are only registered for those it implements.
+type Controller interface{}
+
+// Indexer lists the models of a resource, at GET /prefix.
+type Indexer interface {
+	Index(*RequestContext) Response
+}
+
+// Shower shows a model of a resource, at GET /prefix/{id}.
+type Shower interface {
+	Show(*RequestContext) Response
+}
+
+// Creator creates a model of a resource, at POST /prefix.
+type Creator interface {
+	Create(*RequestContext) Response
+}
+
+// Updater updates a model of a resource, at PUT /prefix/{id}.
+type Updater interface {
+	Update(*RequestContext) Response
+}
+
+// Destroyer deletes a model of a resource, at DELETE /prefix/{id}.
+type Destroyer interface {
+	Destroy(*RequestContext) Response
+}
+
+// Resource registers the CRUD routes of a resource under prefix for each of
+// the actions implemented by c. The id of the model is available to the
+// handlers through RequestContext.RouteModelID. The routes are subject to the
+// router's middleware.
+func (r *Router) Resource(prefix string, c Controller) {
+	collection := strings.TrimSuffix(prefix, "/")
+	member := collection + "/{id}"
+
+	if h, ok := c.(Indexer); ok {
+		r.Path(collection).Methods(http.MethodGet).Handler(h.Index)
+	}
+
+	if h, ok := c.(Shower); ok {
+		r.Path(member).Methods(http.MethodGet).Handler(h.Show)
+	}
+
+	if h, ok := c.(Creator); ok {
+		r.Path(collection).Methods(http.MethodPost).Handler(h.Create)
+	}
+
+	if h, ok := c.(Updater); ok {
+		r.Path(member).Methods(http.MethodPut).Handler(h.Update)
+	}
+
+	if h, ok := c.(Destroyer); ok {
+		r.Path(member).Methods(http.MethodDelete).Handler(h.Destroy)
+	}
+}
@@ -0,0 +1,72 @@
+package chopshop
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// widgetController implements the index, show and destroy actions only.
+type widgetController struct{}
+
+func (widgetController) Index(ctx *RequestContext) Response { return JSONResponse("index") }
+
+func (widgetController) Show(ctx *RequestContext) Response {
+	id, _ := ctx.RouteModelID()
+	return JSONResponse(fmt.Sprintf("show %d", id))
+}
+
+func (widgetController) Destroy(ctx *RequestContext) Response {
+	return BlankResponse(http.StatusNoContent)
+}
+
+func TestResource(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.SessionSecret = []byte("secret")
+
+	var seen int
+	api := f.Subrouter("/api").Middleware(func(fn ContextHandlerFunc) ContextHandlerFunc {
+		return func(ctx *RequestContext) Response {
+			seen++
+			return fn(ctx)
+		}
+	})
+	api.Resource("/widgets/", widgetController{})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		body   string
+		seen   bool
+	}{
+		{"index", "GET", "/api/widgets", http.StatusOK, "\"index\"\n", true},
+		{"show", "GET", "/api/widgets/7", http.StatusOK, "\"show 7\"\n", true},
+		{"destroy", "DELETE", "/api/widgets/7", http.StatusNoContent, "", true},
+		{"no create", "POST", "/api/widgets", http.StatusNotFound, "", false},
+		{"no update", "PUT", "/api/widgets/7", http.StatusMethodNotAllowed, "", false},
+	}
+
+	for _, tt := range tests {
+		seen = 0
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.name, w.Body.String(), tt.body)
+		}
+
+		if (seen > 0) != tt.seen {
+			t.Errorf("%s: middleware ran %d times", tt.name, seen)
+		}
+	}
+
+	if routes := f.Routes(); len(routes) != 3 {
+		t.Errorf("routes %+v", routes)
+	}
+}
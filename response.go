@@ -5,9 +5,14 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 )
 
-// Response is a http.HandlerFunc used to respond to a request.
+// Response is a http.HandlerFunc used to respond to a request. Cancel releases
+// the resources of a response which will not be served. When the client
+// disconnects, both the request's context is cancelled and, if the handler
+// returns afterwards, Cancel is called on its response, so handlers need only
+// watch the context for work they start themselves.
 type Response interface {
 	ServeHTTP(http.ResponseWriter, *http.Request)
 	Cancel()
@@ -36,6 +41,16 @@ func BlankResponse(status int) ResponseFunc {
 	}
 }
 
+// NoContentResponse constructs a 204 response without a body, removing any
+// Content-Type or Content-Length header already set.
+func NoContentResponse() ResponseFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Del("Content-Type")
+		w.Header().Del("Content-Length")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
 func EmptyJSONResponse(status int) ResponseFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -55,10 +70,40 @@ func JSONResponse(v interface{}) ResponseFunc {
 
 // ErrorMessage holds http status codes and a message.
 type ErrorMessage struct {
-	Status  int    `json:"-"`
+	Status  int              `json:"-"`
+	Code    string           `json:"code,omitempty"`
+	Message string           `json:"message"`
+	Errors  ValidationErrors `json:"errors,omitempty"`
+}
+
+// ValidationError describes why a single field of a request is invalid.
+type ValidationError struct {
+	Field   string `json:"field"`
 	Message string `json:"message"`
 }
 
+// ValidationErrors is a list of validation failures which is itself an error.
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		if err.Field == "" {
+			messages[i] = err.Message
+		} else {
+			messages[i] = err.Field + ": " + err.Message
+		}
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// Error codes allowing clients to distinguish otherwise identical statuses.
+const (
+	ErrorCodeReauthenticationRequired = "reauthentication_required"
+	ErrorCodeTwoFactorRequired        = "two_factor_required"
+)
+
 // ErrorResponse constructs a response containing a json encoded error.
 func ErrorResponse(message string, status int) ResponseFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -68,6 +113,54 @@ func ErrorResponse(message string, status int) ResponseFunc {
 	}
 }
 
+// ValidationErrorResponse constructs a 400 response containing a json encoded
+// error listing each validation failure.
+func ValidationErrorResponse(errs ValidationErrors) ResponseFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorMessage{
+			Status:  http.StatusBadRequest,
+			Message: "The request is invalid.",
+			Errors:  errs,
+		})
+	}
+}
+
+// CodedErrorResponse constructs a response containing a json encoded error
+// with a machine readable code.
+func CodedErrorResponse(code, message string, status int) ResponseFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(ErrorMessage{Status: status, Code: code, Message: message})
+	}
+}
+
+// Problem describes an error in the format of RFC 7807.
+type Problem struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// ProblemResponse constructs an application/problem+json response describing
+// the problem. The title defaults to the text of the status code.
+func ProblemResponse(problem Problem, status int) ResponseFunc {
+	problem.Status = status
+	if problem.Title == "" {
+		problem.Title = http.StatusText(status)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(problem)
+	}
+}
+
 // RedirectResponse constructs a response which performs an http redirect
 func RedirectResponse(path string, status int) ResponseFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -79,12 +172,27 @@ func RedirectResponse(path string, status int) ResponseFunc {
 type Streamer struct {
 	contentType string
 	rc          io.ReadCloser
+	trailers    []string
+	trailerFn   TrailerFunc
 }
 
+// TrailerFunc computes the values of the declared trailers once the body has
+// been written. err is the error which interrupted the copy, if any.
+type TrailerFunc func(written int64, err error) map[string]string
+
 func (s *Streamer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer s.rc.Close()
 	w.Header().Set("Content-Type", s.contentType)
-	io.Copy(w, s.rc)
+	if len(s.trailers) > 0 {
+		w.Header().Set("Trailer", strings.Join(s.trailers, ", "))
+	}
+
+	written, err := io.Copy(w, s.rc)
+	if s.trailerFn != nil {
+		for k, v := range s.trailerFn(written, err) {
+			w.Header().Set(k, v)
+		}
+	}
 }
 
 func (s *Streamer) Cancel() {
@@ -95,3 +203,16 @@ func (s *Streamer) Cancel() {
 func StreamResponse(contentType string, rc io.ReadCloser) Response {
 	return &Streamer{contentType: contentType, rc: rc}
 }
+
+// StreamResponseWithTrailers constructs a response which wraps a Reader and
+// declares the named trailers, whose values are produced by fn after the body
+// has been written. This allows a checksum or an error encountered mid-stream
+// to be reported once the status has already been sent.
+func StreamResponseWithTrailers(contentType string, rc io.ReadCloser, fn TrailerFunc, trailers ...string) Response {
+	return &Streamer{
+		contentType: contentType,
+		rc:          rc,
+		trailers:    trailers,
+		trailerFn:   fn,
+	}
+}
@@ -0,0 +1,136 @@
+package chopshop
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// failingReader returns its data followed by an error.
+type failingReader struct {
+	data string
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, r.err
+	}
+
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestStreamResponseWithTrailers(t *testing.T) {
+	trailers := func(n int64, err error) map[string]string {
+		values := map[string]string{"X-Len": strconv.FormatInt(n, 10)}
+		if err != nil {
+			values["X-Err"] = err.Error()
+		}
+		return values
+	}
+
+	tests := []struct {
+		name   string
+		reader io.Reader
+		body   string
+		length string
+		err    string
+	}{
+		{"complete", strings.NewReader("hello"), "hello", "5", ""},
+		{"interrupted", &failingReader{"hel", errors.New("broken")}, "hel", "3", "broken"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		StreamResponseWithTrailers("text/plain", ioutil.NopCloser(tt.reader), trailers, "X-Len", "X-Err").ServeHTTP(w, r)
+
+		res := w.Result()
+		if w.Body.String() != tt.body || res.Header.Get("Content-Type") != "text/plain" {
+			t.Errorf("%s: body %q content type %q", tt.name, w.Body.String(), res.Header.Get("Content-Type"))
+		}
+
+		if res.Trailer.Get("X-Len") != tt.length || res.Trailer.Get("X-Err") != tt.err {
+			t.Errorf("%s: trailers %v", tt.name, res.Trailer)
+		}
+	}
+}
+
+func TestProblemResponse(t *testing.T) {
+	problem := Problem{Type: "https://example.com/probs/out-of-stock", Detail: "Item unavailable."}
+
+	tests := []struct {
+		name     string
+		rights   []string
+		status   int
+		body     string
+		reported int
+	}{
+		{
+			"client error",
+			[]string{"a"},
+			http.StatusConflict,
+			`{"type":"https://example.com/probs/out-of-stock","title":"Conflict","status":409,"detail":"Item unavailable."}`,
+			0,
+		},
+		{
+			"revealed",
+			[]string{"RevealError"},
+			http.StatusConflict,
+			`{"type":"https://example.com/probs/out-of-stock","title":"Conflict","status":409,"detail":"stock: 0"}`,
+			0,
+		},
+		{
+			"server error",
+			nil,
+			http.StatusInternalServerError,
+			`{"type":"https://example.com/probs/out-of-stock","title":"Internal Server Error","status":500,"detail":"Item unavailable."}`,
+			1,
+		},
+	}
+
+	for _, tt := range tests {
+		f, ctx, _ := newTestContext(t, tt.rights...)
+		reporter := newErrorRecorder()
+		f.ErrorReporter = reporter
+
+		w := httptest.NewRecorder()
+		ctx.ProblemResponse(errors.New("stock: 0"), problem, tt.status).ServeHTTP(w, ctx.Request)
+		if w.Code != tt.status || w.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("%s: status %d content type %q", tt.name, w.Code, w.Header().Get("Content-Type"))
+		}
+
+		if got := strings.TrimSpace(w.Body.String()); got != tt.body {
+			t.Errorf("%s: body %s, want %s", tt.name, got, tt.body)
+		}
+
+		if len(reporter.errors) != tt.reported {
+			t.Errorf("%s: %d reports, want %d", tt.name, len(reporter.errors), tt.reported)
+		}
+	}
+}
+
+func TestNoContentResponse(t *testing.T) {
+	_, ctx, _ := newTestContext(t)
+	w := httptest.NewRecorder()
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Content-Length", "5")
+	ctx.NoContent().ServeHTTP(w, ctx.Request)
+
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Errorf("status %d body %q", w.Code, w.Body.String())
+	}
+
+	for _, header := range []string{"Content-Type", "Content-Length"} {
+		if v := w.Header().Get(header); v != "" {
+			t.Errorf("%s %q kept", header, v)
+		}
+	}
+}
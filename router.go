@@ -1,18 +1,25 @@
 package chopshop
 
 import (
+	"fmt"
 	"net/http"
+	"reflect"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
-// FIXME: use strict slashes
-
 // Route wraps Gorilla Route
 type Route struct {
-	f  *Framework
-	r  *mux.Route
-	mw Middleware
+	f       *Framework
+	r       *mux.Route
+	mw      Middleware
+	timeout time.Duration
+
+	// sibling registers another route with the same path, for mounting the
+	// handlers of further methods.
+	sibling func() *mux.Route
 }
 
 func newRoute(r *mux.Route, f *Framework, mw Middleware) *Route {
@@ -35,28 +42,139 @@ func (r *Route) Response(response Response) {
 	r.Handler(SingleResponseContextHandlerFunc(response))
 }
 
-// Handler mounts a ContextHandlerFunc at the specified endpoint.
+// Handler mounts a ContextHandlerFunc at the specified endpoint. It panics if
+// the route is invalid, for example because its host and path templates
+// declare the same variable. Requests served by the handler are counted in
+// Framework.Stats under the route's path template. Unless OPTIONS is among the
+// route's methods, CORS preflight requests which its middleware does not
+// answer receive an EmptyJSONResponse(405) rather than reaching fn, as they
+// are not authenticated.
 func (r *Route) Handler(fn ContextHandlerFunc) {
+	if err := r.r.GetError(); err != nil {
+		panic(fmt.Sprintf("chopshop: invalid route: %s", err))
+	}
+
+	if methods, _ := r.r.GetMethods(); !hasItem(http.MethodOptions, methods) {
+		fn = refusePreflight(fn)
+	}
+
 	if r.mw != nil {
 		fn = r.mw(fn)
 	}
 
+	template, _ := r.r.GetPathTemplate()
+	counters := r.f.routeCounters(template)
+	r.f.setRouteMiddleware(r.r, r.mw)
+
 	r.unsafeHandler(http.HandlerFunc(
 		func(w http.ResponseWriter, req *http.Request) {
-			r.f.ServeContext(r.f.ContextFor(req), fn)
+			ctx := r.f.ContextFor(req)
+			ctx.Request = req
+
+			sw, ok := ctx.ResponseWriter.(*statusWriter)
+			if !ok {
+				sw = &statusWriter{ResponseWriter: ctx.ResponseWriter}
+				ctx.ResponseWriter = sw
+			}
+
+			counters.begin()
+			panicked := true
+			defer func() { counters.end(sw.status, panicked) }()
+
+			if r.timeout > 0 {
+				r.f.serveWithTimeout(ctx, fn, r.timeout, sw)
+			} else {
+				r.f.ServeContext(ctx, fn)
+			}
+			panicked = false
 		}))
 }
 
+// refusePreflight wraps a handler so that CORS preflight requests receive an
+// EmptyJSONResponse(405) instead of being served by it.
+func refusePreflight(fn ContextHandlerFunc) ContextHandlerFunc {
+	return func(ctx *RequestContext) Response {
+		if isPreflight(ctx.Request) {
+			return EmptyJSONResponse(http.StatusMethodNotAllowed)
+		}
+
+		return fn(ctx)
+	}
+}
+
 func (r *Route) unsafeHandler(handler http.Handler) {
 	r.r.Handler(handler)
 }
 
+// WithTypes records the Go types of the request and response bodies of the
+// route (either of which may be nil) for introspection, such as by
+// GenerateOpenAPI. It has no effect on how requests are served.
+func (r *Route) WithTypes(request, response interface{}) *Route {
+	r.f.setRouteTypes(r.r, reflect.TypeOf(request), reflect.TypeOf(response))
+	return r
+}
+
+// Types returns the request and response types recorded by WithTypes, if any.
+func (r *Route) Types() (request, response reflect.Type) {
+	types := r.f.routeTypes[r.r]
+	return types.request, types.response
+}
+
 // Methods restrict the HTTP Verbs which match the route.
 func (r *Route) Methods(methods ...string) *Route {
 	r.r.Methods(methods...)
 	return r
 }
 
+// Get mounts a ContextHandlerFunc for GET requests to the route, returning
+// the route so that handlers for other methods may be chained.
+func (r *Route) Get(fn ContextHandlerFunc) *Route {
+	return r.method(http.MethodGet, fn)
+}
+
+// Post mounts a ContextHandlerFunc for POST requests to the route.
+func (r *Route) Post(fn ContextHandlerFunc) *Route {
+	return r.method(http.MethodPost, fn)
+}
+
+// Put mounts a ContextHandlerFunc for PUT requests to the route.
+func (r *Route) Put(fn ContextHandlerFunc) *Route {
+	return r.method(http.MethodPut, fn)
+}
+
+// Patch mounts a ContextHandlerFunc for PATCH requests to the route.
+func (r *Route) Patch(fn ContextHandlerFunc) *Route {
+	return r.method(http.MethodPatch, fn)
+}
+
+// Delete mounts a ContextHandlerFunc for DELETE requests to the route.
+func (r *Route) Delete(fn ContextHandlerFunc) *Route {
+	return r.method(http.MethodDelete, fn)
+}
+
+// method mounts fn for requests with the given method. The first handler is
+// mounted on the route itself and later ones on siblings sharing its path,
+// middleware and timeout.
+func (r *Route) method(method string, fn ContextHandlerFunc) *Route {
+	route := r
+	if r.r.GetHandler() != nil {
+		if r.sibling == nil {
+			panic("chopshop: route already has a handler")
+		}
+
+		route = &Route{f: r.f, r: r.sibling(), mw: r.mw, timeout: r.timeout}
+	}
+
+	route.Methods(method).Handler(fn)
+	return r
+}
+
+// Name names the route so that URLs for it may be built with Framework.URL.
+func (r *Route) Name(name string) *Route {
+	r.r.Name(name)
+	return r
+}
+
 // Middleware appends a middleware handler to the specified route.
 func (r *Route) Middleware(mws ...Middleware) *Route {
 	r.mw = extendMiddleware(r.mw, mws...)
@@ -71,7 +189,10 @@ type Router struct {
 }
 
 func newRouter(f *Framework) *Router {
-	return wrapRouter(mux.NewRouter(), f, nil)
+	r := wrapRouter(mux.NewRouter().StrictSlash(f.strictSlash), f, nil)
+	r.NotFound(SingleResponseContextHandlerFunc(EmptyJSONResponse(http.StatusNotFound)))
+	r.MethodNotAllowed(SingleResponseContextHandlerFunc(EmptyJSONResponse(http.StatusMethodNotAllowed)))
+	return r
 }
 
 func wrapRouter(r *mux.Router, f *Framework, mw Middleware) *Router {
@@ -80,12 +201,16 @@ func wrapRouter(r *mux.Router, f *Framework, mw Middleware) *Router {
 
 // PathPrefix returns a route relative to the specified prefix.
 func (r *Router) PathPrefix(tpl string) *Route {
-	return newRoute(r.r.PathPrefix(tpl), r.f, r.mw)
+	route := newRoute(r.r.PathPrefix(tpl), r.f, r.mw)
+	route.sibling = func() *mux.Route { return r.r.PathPrefix(tpl) }
+	return route
 }
 
 // Path returns a route for the specified prefix.
 func (r *Router) Path(path string) *Route {
-	return newRoute(r.r.Path(path), r.f, r.mw)
+	route := newRoute(r.r.Path(path), r.f, r.mw)
+	route.sibling = func() *mux.Route { return r.r.Path(path) }
+	return route
 }
 
 // Middleware appends middleware to the router to be applied on all endpoints.
@@ -94,12 +219,178 @@ func (r *Router) Middleware(mws ...Middleware) *Router {
 	return r
 }
 
+// NotFound mounts the ContextHandlerFunc serving requests which match no
+// route. By default an EmptyJSONResponse(404) is served.
+func (r *Router) NotFound(fn ContextHandlerFunc) {
+	r.r.NotFoundHandler = r.contextHandler(fn)
+}
+
+// MethodNotAllowed mounts the ContextHandlerFunc serving requests which match
+// the path of a route but none of its methods. By default an
+// EmptyJSONResponse(405) is served. A CORS preflight request asking about a
+// method served by a route is instead passed through that route's middleware
+// before reaching fn, so that CORS middleware may answer it.
+func (r *Router) MethodNotAllowed(fn ContextHandlerFunc) {
+	handler := r.contextHandler(fn)
+	r.r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mw, ok := r.preflightMiddleware(req)
+		if !ok {
+			handler.ServeHTTP(w, req)
+			return
+		}
+
+		wrapped := fn
+		if mw != nil {
+			wrapped = mw(fn)
+		}
+
+		ctx := r.f.ContextFor(req)
+		ctx.Request = req
+		r.f.ServeContext(ctx, wrapped)
+	})
+}
+
+// contextHandler adapts a ContextHandlerFunc, wrapped in the router's
+// middleware, to an http.Handler.
+func (r *Router) contextHandler(fn ContextHandlerFunc) http.Handler {
+	if r.mw != nil {
+		fn = r.mw(fn)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := r.f.ContextFor(req)
+		ctx.Request = req
+		r.f.ServeContext(ctx, fn)
+	})
+}
+
+// preflightMiddleware returns the middleware of the route which would serve
+// the request were it made with the method named by its
+// Access-Control-Request-Method header, if it is a CORS preflight request.
+func (r *Router) preflightMiddleware(req *http.Request) (Middleware, bool) {
+	if !isPreflight(req) {
+		return nil, false
+	}
+
+	probe := *req
+	probe.Method = req.Header.Get("Access-Control-Request-Method")
+
+	var match mux.RouteMatch
+	if !r.r.Match(&probe, &match) || match.MatchErr != nil {
+		return nil, false
+	}
+
+	mw, ok := r.f.routeMiddleware[match.Route]
+	return mw, ok
+}
+
 // Subrouter returns a router relative to the specified prefix.
 func (r *Router) Subrouter(tpl string) *Router {
 	return wrapRouter(r.PathPrefix(tpl).r.Subrouter(), r.f, r.mw)
 }
 
+// RouteInfo describes a registered route.
+type RouteInfo struct {
+	Name         string
+	HostTemplate string
+	PathTemplate string
+	Methods      []string
+	RequestType  reflect.Type
+	ResponseType reflect.Type
+}
+
+// Routes describes each route registered on the router, or any of its
+// subrouters, to which a handler has been attached.
+func (r *Router) Routes() []RouteInfo {
+	var routes []RouteInfo
+	r.r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if route.GetHandler() == nil {
+			return nil
+		}
+
+		info := RouteInfo{Name: route.GetName()}
+		info.HostTemplate, _ = route.GetHostTemplate()
+		info.PathTemplate, _ = route.GetPathTemplate()
+		info.Methods, _ = route.GetMethods()
+
+		types := r.f.routeTypes[route]
+		info.RequestType, info.ResponseType = types.request, types.response
+
+		routes = append(routes, info)
+		return nil
+	})
+
+	return routes
+}
+
 // ServeHTTP adapts Router to be used an http.Handler
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	r.r.ServeHTTP(w, req)
 }
+
+// routeTemplate returns the path template of the route matching the request,
+// or the empty string if there is none.
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+
+	return ""
+}
+
+// routeTypes holds the types recorded by Route.WithTypes.
+type routeTypes struct {
+	request  reflect.Type
+	response reflect.Type
+}
+
+func (f *Framework) setRouteTypes(route *mux.Route, request, response reflect.Type) {
+	if f.routeTypes == nil {
+		f.routeTypes = make(map[*mux.Route]routeTypes)
+	}
+
+	f.routeTypes[route] = routeTypes{request: request, response: response}
+}
+
+func (f *Framework) setRouteMiddleware(route *mux.Route, mw Middleware) {
+	if f.routeMiddleware == nil {
+		f.routeMiddleware = make(map[*mux.Route]Middleware)
+	}
+
+	f.routeMiddleware[route] = mw
+}
+
+// templateVars splits a mux template into its variables, returning the
+// template with any variable patterns removed along with the variable names.
+func templateVars(tpl string) (string, []string) {
+	var out strings.Builder
+	var names []string
+	level, start := 0, 0
+	for i := 0; i < len(tpl); i++ {
+		switch tpl[i] {
+		case '{':
+			if level == 0 {
+				start = i
+			}
+			level++
+		case '}':
+			level--
+			if level == 0 {
+				name := tpl[start+1 : i]
+				if idx := strings.Index(name, ":"); idx != -1 {
+					name = name[:idx]
+				}
+				names = append(names, name)
+				out.WriteString("{" + name + "}")
+			}
+		default:
+			if level == 0 {
+				out.WriteByte(tpl[i])
+			}
+		}
+	}
+
+	return out.String(), names
+}
@@ -0,0 +1,209 @@
+package chopshop
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRoutes(t *testing.T) {
+	type item struct {
+		Name string `json:"name"`
+	}
+
+	handler := func(ctx *RequestContext) Response { return nil }
+
+	f, _ := NewFramework("app", "")
+	f.Path("/items").Name("items").Methods("GET", "POST").WithTypes(item{}, []item{}).Handler(handler)
+	f.Subrouter("/api").Path("/ping").Handler(handler)
+	f.Path("/unhandled")
+
+	tests := []RouteInfo{
+		{
+			Name:         "items",
+			PathTemplate: "/items",
+			Methods:      []string{"GET", "POST"},
+			RequestType:  reflect.TypeOf(item{}),
+			ResponseType: reflect.TypeOf([]item{}),
+		},
+		{PathTemplate: "/api/ping"},
+	}
+
+	routes := f.Routes()
+	if len(routes) != len(tests) {
+		t.Fatalf("%d routes, want %d: %v", len(routes), len(tests), routes)
+	}
+
+	for i, want := range tests {
+		if !reflect.DeepEqual(routes[i], want) {
+			t.Errorf("route %d: got %+v, want %+v", i, routes[i], want)
+		}
+	}
+
+	request, response := f.Path("/typed").WithTypes(nil, &item{}).Types()
+	if request != nil || response != reflect.TypeOf(&item{}) {
+		t.Errorf("Types: got %v %v", request, response)
+	}
+}
+
+func TestHostVars(t *testing.T) {
+	f, _ := NewFramework("test", "")
+	f.SessionSecret = []byte("secret")
+
+	var subdomain, id string
+	f.HostVars("{subdomain}.example.com").Path("/items/{id}").Handler(func(ctx *RequestContext) Response {
+		subdomain, id = ctx.RouteVar("subdomain"), ctx.RouteVar("id")
+		return BlankResponse(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		url       string
+		status    int
+		subdomain string
+		id        string
+	}{
+		{"http://acme.example.com/items/7", http.StatusNoContent, "acme", "7"},
+		{"http://example.org/items/7", http.StatusNotFound, "", ""},
+	}
+
+	for _, tt := range tests {
+		subdomain, id = "", ""
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest("GET", tt.url, nil))
+		if w.Code != tt.status || subdomain != tt.subdomain || id != tt.id {
+			t.Errorf("%s: status %d vars %q %q, want %d %q %q", tt.url, w.Code, subdomain, id, tt.status, tt.subdomain, tt.id)
+		}
+	}
+}
+
+func TestHostVarsConflict(t *testing.T) {
+	f, _ := NewFramework("test", "")
+	defer func() {
+		if recover() == nil {
+			t.Error("conflicting host and path variables accepted")
+		}
+	}()
+
+	f.HostVars("{id}.example.org").Path("/x/{id}").Handler(func(ctx *RequestContext) Response { return nil })
+}
+
+func TestRouteMethods(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.SessionSecret = []byte("secret")
+	f.Path("/only-get").Get(func(ctx *RequestContext) Response { return JSONResponse("get") })
+	f.Path("/both").
+		Get(func(ctx *RequestContext) Response { return JSONResponse("get") }).
+		Delete(func(ctx *RequestContext) Response { return JSONResponse("delete") })
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{"get", "GET", "/only-get", http.StatusOK, "\"get\"\n"},
+		{"put unhandled", "PUT", "/only-get", http.StatusMethodNotAllowed, ""},
+		{"post unhandled", "POST", "/only-get", http.StatusMethodNotAllowed, ""},
+		{"first of two", "GET", "/both", http.StatusOK, "\"get\"\n"},
+		{"second of two", "DELETE", "/both", http.StatusOK, "\"delete\"\n"},
+		{"patch unhandled", "PATCH", "/both", http.StatusMethodNotAllowed, ""},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.name, w.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.SessionSecret = []byte("secret")
+	f.Path("/thing").Get(func(ctx *RequestContext) Response { return JSONResponse("thing") })
+
+	custom := func(ctx *RequestContext) Response {
+		return ctx.CustomErrorResponse(nil, "No such page: "+ctx.Request.URL.Path, http.StatusNotFound)
+	}
+
+	tests := []struct {
+		name     string
+		notFound ContextHandlerFunc
+		method   string
+		path     string
+		status   int
+		body     string
+	}{
+		{"not found", nil, "GET", "/nope", http.StatusNotFound, "{}"},
+		{"method not allowed", nil, "POST", "/thing", http.StatusMethodNotAllowed, "{}"},
+		{"custom", custom, "GET", "/nope", http.StatusNotFound, "{\"message\":\"No such page: /nope\"}\n"},
+	}
+
+	for _, tt := range tests {
+		if tt.notFound != nil {
+			f.NotFound(tt.notFound)
+		}
+
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+
+		if got := w.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("%s: content type %q", tt.name, got)
+		}
+
+		if w.Body.String() != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.name, w.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestStrictSlash(t *testing.T) {
+	tests := []struct {
+		name     string
+		strict   bool
+		path     string
+		status   int
+		location string
+		ran      bool
+	}{
+		{"strict redirect", true, "/foo/", http.StatusMovedPermanently, "/foo", false},
+		{"strict exact", true, "/foo", http.StatusNoContent, "", true},
+		{"lax slash", false, "/foo/", http.StatusNotFound, "", false},
+		{"lax exact", false, "/foo", http.StatusNoContent, "", true},
+	}
+
+	for _, tt := range tests {
+		f, _ := NewFramework("app", "", StrictSlash(tt.strict))
+		f.SessionSecret = []byte("secret")
+
+		var ran bool
+		f.Path("/foo").Handler(func(ctx *RequestContext) Response {
+			ran = true
+			return BlankResponse(http.StatusNoContent)
+		})
+
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+
+		if got := w.Header().Get("Location"); got != tt.location {
+			t.Errorf("%s: location %q, want %q", tt.name, got, tt.location)
+		}
+
+		if ran != tt.ran {
+			t.Errorf("%s: handler ran %v, want %v", tt.name, ran, tt.ran)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package chopshop
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// SchemaValidator validates a JSON document against a schema.
+type SchemaValidator interface {
+	Validate(document []byte) ValidationErrors
+}
+
+// SchemaValidatorFunc adapts a function for use as a SchemaValidator, allowing
+// another JSON Schema library to be used in place of the default one.
+type SchemaValidatorFunc func(document []byte) ValidationErrors
+
+// Validate implements SchemaValidator.
+func (fn SchemaValidatorFunc) Validate(document []byte) ValidationErrors {
+	return fn(document)
+}
+
+// JSONSchemaMiddleware constructs a middleware which validates the request body
+// against the given JSON Schema. It panics if the schema cannot be compiled.
+// See CompileJSONSchema.
+func JSONSchemaMiddleware(schema []byte) Middleware {
+	validator, err := CompileJSONSchema(schema)
+	if err != nil {
+		panic(fmt.Sprintf("chopshop: invalid JSON schema: %s", err))
+	}
+
+	return SchemaMiddleware(validator)
+}
+
+// SchemaMiddleware constructs a middleware which returns a
+// ValidationErrorResponse when the request body fails validation. On success
+// the body is restored so that the handler may read it.
+func SchemaMiddleware(validator SchemaValidator) Middleware {
+	return func(fn ContextHandlerFunc) ContextHandlerFunc {
+		return func(ctx *RequestContext) Response {
+			body, err := ctx.CaptureBody()
+			if err == nil {
+				err = ctx.framework.checkJSONLimits(body)
+			}
+
+			if err != nil {
+				return ctx.bodyErrorResponse(err)
+			}
+
+			if errs := validator.Validate(body); len(errs) > 0 {
+				return ValidationErrorResponse(errs)
+			}
+
+			return fn(ctx)
+		}
+	}
+}
+
+// CompileJSONSchema compiles a JSON Schema (drafts 4, 6 and 7, as supported by
+// gojsonschema) into a SchemaValidator. The fields of the validation errors
+// are the dotted paths of the offending values, such as "tags.0", and are
+// empty for the document itself.
+func CompileJSONSchema(schema []byte) (SchemaValidator, error) {
+	loader := gojsonschema.NewSchemaLoader()
+	compiled, err := loader.Compile(gojsonschema.NewBytesLoader(schema))
+	if err != nil {
+		return nil, err
+	}
+
+	return &jsonSchema{schema: compiled}, nil
+}
+
+type jsonSchema struct {
+	schema *gojsonschema.Schema
+}
+
+// Validate implements SchemaValidator.
+func (s *jsonSchema) Validate(document []byte) ValidationErrors {
+	result, err := s.schema.Validate(gojsonschema.NewBytesLoader(document))
+	if err != nil {
+		return ValidationErrors{{Message: "is not valid JSON"}}
+	}
+
+	var errs ValidationErrors
+	for _, resultErr := range result.Errors() {
+		field := resultErr.Field()
+		if field == gojsonschema.STRING_CONTEXT_ROOT {
+			field = ""
+		}
+
+		errs = append(errs, ValidationError{Field: field, Message: resultErr.Description()})
+	}
+
+	return errs
+}
+
+func decodeJSONNumbers(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
@@ -0,0 +1,106 @@
+package chopshop
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSchema = `{
+	"definitions": {
+		"tag": {"enum": ["a", "b"]}
+	},
+	"type": "object",
+	"required": ["name"],
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "minLength": 2},
+		"age": {"allOf": [{"type": "integer"}, {"minimum": 0}]},
+		"email": {"type": "string", "format": "email"},
+		"tags": {"type": "array", "items": {"$ref": "#/definitions/tag"}}
+	}
+}`
+
+func TestCompileJSONSchema(t *testing.T) {
+	validator, err := CompileJSONSchema([]byte(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		document string
+		fields   []string
+	}{
+		{`{"name": "bob", "age": 3, "email": "bob@example.com", "tags": ["a"]}`, nil},
+		{`{"name": "bob", "age": 3.5}`, []string{"age"}},
+		{`{"name": "bob", "age": -1}`, []string{"age"}},
+		{`{"name": "bob", "email": "bob"}`, []string{"email"}},
+		{`{"name": "bob", "tags": ["c"]}`, []string{"tags.0"}},
+		{`{"name": "b", "x": 1}`, []string{"", "name"}},
+		{`{}`, []string{""}},
+		{`not json`, []string{""}},
+	}
+
+	for _, tt := range tests {
+		got := make(map[string]bool)
+		for _, err := range validator.Validate([]byte(tt.document)) {
+			got[err.Field] = true
+		}
+
+		want := make(map[string]bool)
+		for _, field := range tt.fields {
+			want[field] = true
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got errors for %v, want %v", tt.document, got, want)
+		}
+	}
+}
+
+func TestCompileJSONSchemaInvalid(t *testing.T) {
+	tests := []string{
+		`{"type": "widget"}`,
+		`{"$ref": "#/definitions/missing"}`,
+		`{"minLength": "two"}`,
+		`not json`,
+	}
+
+	for _, schema := range tests {
+		if _, err := CompileJSONSchema([]byte(schema)); err == nil {
+			t.Errorf("%s: compiled", schema)
+		}
+	}
+}
+
+func TestJSONSchemaMiddleware(t *testing.T) {
+	handler := JSONSchemaMiddleware([]byte(testSchema))(func(ctx *RequestContext) Response {
+		body, _ := ioutil.ReadAll(ctx.Request.Body)
+		return ResponseFunc(func(w http.ResponseWriter, r *http.Request) { w.Write(body) })
+	})
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"name": "bob"}`, http.StatusOK},
+		{`{"name": "b"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t)
+		ctx.Request = httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+		handler(ctx).ServeHTTP(w, ctx.Request)
+		if w.Code != tt.status {
+			t.Errorf("%s: got %d %s, want %d", tt.body, w.Code, w.Body.String(), tt.status)
+		}
+
+		if tt.status == http.StatusOK && w.Body.String() != tt.body {
+			t.Errorf("%s: handler read %q", tt.body, w.Body.String())
+		}
+	}
+}
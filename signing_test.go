@@ -0,0 +1,210 @@
+package chopshop
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedURL(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.SessionSecret = []byte("secret")
+	clock := NewTestClock(time.Unix(1000, 0))
+	f.Clock = clock.Now
+
+	signed, err := f.SignedURL("/files/a b.pdf?x=1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		advance time.Duration
+		want    error
+	}{
+		{"valid", signed, 0, nil},
+		{"tampered query", strings.Replace(signed, "x=1", "x=2", 1), 0, ErrInvalidURLSignature},
+		{"tampered path", strings.Replace(signed, "a%20b", "c", 1), 0, ErrInvalidURLSignature},
+		{"unsigned", "/files/a%20b.pdf?x=1", 0, ErrInvalidURLSignature},
+		{"at expiry", signed, time.Minute, nil},
+		{"expired", signed, time.Second, ErrSignedURLExpired},
+	}
+
+	for _, tt := range tests {
+		clock.Advance(tt.advance)
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := f.VerifySignedURL(u); err != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestSignedBase64JSONCookie(t *testing.T) {
+	f, ctx, w := newTestContext(t, "admin")
+	if err := ctx.SetSignedBase64JSONCookie("c", map[string]interface{}{"rights": []string{"admin"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	value := responseCookies(w)["c"].Value
+	i := strings.LastIndex(value, ".")
+	data, signature := value[:i], value[i+1:]
+	forged := base64.URLEncoding.EncodeToString([]byte(`{"rights":["root"]}`))
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		err    error
+	}{
+		{"valid", &http.Cookie{Name: "c", Value: value}, nil},
+		{"forged", &http.Cookie{Name: "c", Value: forged + "." + signature}, ErrInvalidCookieSignature},
+		{"unsigned", &http.Cookie{Name: "c", Value: data}, ErrInvalidCookieSignature},
+		{"bad signature", &http.Cookie{Name: "c", Value: data + ".x"}, ErrInvalidCookieSignature},
+		{"empty", &http.Cookie{Name: "c", Value: ""}, ErrInvalidCookieSignature},
+		{"renamed", &http.Cookie{Name: "d", Value: value}, ErrInvalidCookieSignature},
+		{"missing", nil, http.ErrNoCookie},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		name := "c"
+		if tt.cookie != nil {
+			r.AddCookie(tt.cookie)
+			name = tt.cookie.Name
+		}
+
+		readCtx, err := f.CreateRequestContext(httptest.NewRecorder(), r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var v struct {
+			Rights []string `json:"rights"`
+		}
+		if err := readCtx.ReadSignedBase64JSONCookie(name, &v); err != tt.err {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+
+		if tt.err == nil && !reflect.DeepEqual(v.Rights, []string{"admin"}) {
+			t.Errorf("%s: read %+v", tt.name, v)
+		}
+	}
+}
+
+func TestUserCookie(t *testing.T) {
+	plain := base64.URLEncoding.EncodeToString([]byte(`{"rights":["admin"]}`))
+
+	tests := []struct {
+		name   string
+		sign   bool
+		secret []byte
+		signed bool
+		err    error
+	}{
+		{"plain", false, []byte("secret"), false, nil},
+		{"plain without secret", false, nil, false, nil},
+		{"signed", true, []byte("secret"), true, nil},
+		{"signed without secret", true, nil, false, ErrNoSigningKey},
+	}
+
+	for _, tt := range tests {
+		f, ctx, w := newTestContext(t, "admin")
+		reporter := newErrorRecorder()
+		f.ErrorReporter = reporter
+		f.SignUserCookie = tt.sign
+		f.SessionSecret = tt.secret
+
+		f.BeforeResponse(ctx)
+		cookie, ok := responseCookies(w)[f.UserCookieName]
+		switch {
+		case tt.err != nil:
+			if ok {
+				t.Errorf("%s: cookie set to %q", tt.name, cookie.Value)
+			}
+			if len(reporter.errors) != 1 || !strings.HasSuffix(reporter.errors[0].Error, tt.err.Error()) {
+				t.Errorf("%s: reported %d errors, want %v", tt.name, len(reporter.errors), tt.err)
+			}
+			continue
+		case !ok:
+			t.Errorf("%s: no cookie set", tt.name)
+			continue
+		case len(reporter.errors) != 0:
+			t.Errorf("%s: reported %q", tt.name, reporter.errors[0].Error)
+		}
+
+		if tt.signed {
+			if !strings.HasPrefix(cookie.Value, plain+".") {
+				t.Errorf("%s: cookie %q is not signed", tt.name, cookie.Value)
+			}
+		} else if cookie.Value != plain {
+			t.Errorf("%s: cookie %q, want %q", tt.name, cookie.Value, plain)
+		}
+	}
+
+	f, ctx, _ := newTestContext(t, "admin")
+	f.SessionSecret = nil
+	if err := ctx.SetSignedBase64JSONCookie("c", "v"); err != ErrNoSigningKey {
+		t.Errorf("set without secret: got %v, want %v", err, ErrNoSigningKey)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "c", Value: plain + ".sig"})
+	ctx.Request = r
+	var v interface{}
+	if err := ctx.ReadSignedBase64JSONCookie("c", &v); err != ErrNoSigningKey {
+		t.Errorf("read without secret: got %v, want %v", err, ErrNoSigningKey)
+	}
+}
+
+func TestSignedURLKeys(t *testing.T) {
+	tests := []struct {
+		name          string
+		sessionSecret []byte
+		urlKey        []byte
+		err           error
+	}{
+		{"session secret", []byte("secret"), nil, nil},
+		{"url key", nil, []byte("key"), nil},
+		{"no key", nil, nil, ErrNoSigningKey},
+		{"empty key", []byte{}, []byte{}, ErrNoSigningKey},
+	}
+
+	for _, tt := range tests {
+		f, _ := NewFramework("app", "")
+		f.SessionSecret = tt.sessionSecret
+		f.URLSigningKey = tt.urlKey
+
+		signed, err := f.SignedURL("/files/a", time.Minute)
+		if err != tt.err {
+			t.Errorf("%s: SignedURL error %v, want %v", tt.name, err, tt.err)
+		}
+
+		if tt.err != nil {
+			// A signature computed with an empty key must not be accepted.
+			signed = "/files/a?expires=9999999999&signature=" + emptyKeySignature("/files/a", "expires=9999999999")
+		}
+
+		u, _ := url.Parse(signed)
+		if err := f.VerifySignedURL(u); err != tt.err {
+			t.Errorf("%s: VerifySignedURL error %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+// emptyKeySignature signs a URL as signURL would with an empty key.
+func emptyKeySignature(path, query string) string {
+	mac := hmac.New(sha256.New, nil)
+	mac.Write([]byte(path + "?" + query))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
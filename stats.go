@@ -0,0 +1,95 @@
+package chopshop
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// RouteStats holds the request counters of a route template.
+type RouteStats struct {
+	Requests int64 `json:"requests"`
+	InFlight int64 `json:"in_flight"`
+	Errors   int64 `json:"errors"`
+}
+
+// routeCounters accumulates RouteStats for the routes sharing a template.
+type routeCounters struct {
+	requests int64
+	inFlight int64
+	errors   int64
+}
+
+// begin records the start of a request.
+func (c *routeCounters) begin() {
+	atomic.AddInt64(&c.requests, 1)
+	atomic.AddInt64(&c.inFlight, 1)
+}
+
+// end records the completion of a request, counting it as an error if it
+// panicked or its response had a 5xx status.
+func (c *routeCounters) end(status int, panicked bool) {
+	atomic.AddInt64(&c.inFlight, -1)
+	if panicked || status >= http.StatusInternalServerError {
+		atomic.AddInt64(&c.errors, 1)
+	}
+}
+
+type routeStatsRegistry struct {
+	mu       sync.RWMutex
+	counters map[string]*routeCounters
+}
+
+// routeCounters returns the counters for a route template, creating them if
+// necessary.
+func (f *Framework) routeCounters(template string) *routeCounters {
+	reg := &f.routeStats
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if reg.counters == nil {
+		reg.counters = make(map[string]*routeCounters)
+	}
+
+	c, ok := reg.counters[template]
+	if !ok {
+		c = &routeCounters{}
+		reg.counters[template] = c
+	}
+
+	return c
+}
+
+// Stats returns the request counters of each route template to which a
+// handler has been attached: the number of requests served, the number
+// currently being served, and the number which panicked or failed with a 5xx
+// status.
+func (f *Framework) Stats() map[string]RouteStats {
+	reg := &f.routeStats
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	stats := make(map[string]RouteStats, len(reg.counters))
+	for template, c := range reg.counters {
+		stats[template] = RouteStats{
+			Requests: atomic.LoadInt64(&c.requests),
+			InFlight: atomic.LoadInt64(&c.inFlight),
+			Errors:   atomic.LoadInt64(&c.errors),
+		}
+	}
+
+	return stats
+}
+
+// ResetStats zeroes the request and error counters returned by Stats. Requests
+// in flight continue to be counted.
+func (f *Framework) ResetStats() {
+	reg := &f.routeStats
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	for _, c := range reg.counters {
+		atomic.StoreInt64(&c.requests, 0)
+		atomic.StoreInt64(&c.errors, 0)
+	}
+}
@@ -0,0 +1,73 @@
+package chopshop
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.SessionSecret = []byte("secret")
+	f.Path("/ok/{id}").Handler(func(ctx *RequestContext) Response { return JSONResponse(1) })
+	f.Path("/missing").Handler(func(ctx *RequestContext) Response { return BlankResponse(http.StatusNotFound) })
+	f.Path("/fail").Handler(func(ctx *RequestContext) Response {
+		return ctx.ErrorResponse(errors.New("failed"), http.StatusInternalServerError)
+	})
+	f.Path("/panic").Handler(func(ctx *RequestContext) Response { panic("boom") })
+
+	for _, path := range []string{"/ok/1", "/ok/2", "/missing", "/fail", "/panic"} {
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	tests := []struct {
+		template string
+		want     RouteStats
+	}{
+		{"/ok/{id}", RouteStats{Requests: 2}},
+		{"/missing", RouteStats{Requests: 1}},
+		{"/fail", RouteStats{Requests: 1, Errors: 1}},
+		{"/panic", RouteStats{Requests: 1, Errors: 1}},
+	}
+
+	stats := f.Stats()
+	for _, tt := range tests {
+		if got := stats[tt.template]; got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.template, got, tt.want)
+		}
+	}
+
+	f.ResetStats()
+	if got := f.Stats()["/ok/{id}"]; got != (RouteStats{}) {
+		t.Errorf("after reset: %+v", got)
+	}
+}
+
+func TestStatsInFlight(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.SessionSecret = []byte("secret")
+	started, release := make(chan struct{}), make(chan struct{})
+	f.Path("/slow").Handler(func(ctx *RequestContext) Response {
+		close(started)
+		<-release
+		return JSONResponse(1)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+		close(done)
+	}()
+
+	<-started
+	if got := f.Stats()["/slow"]; got != (RouteStats{Requests: 1, InFlight: 1}) {
+		t.Errorf("during request: %+v", got)
+	}
+
+	close(release)
+	<-done
+	if got := f.Stats()["/slow"]; got != (RouteStats{Requests: 1}) {
+		t.Errorf("after request: %+v", got)
+	}
+}
@@ -0,0 +1,71 @@
+package chopshop
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+)
+
+// StreamErrorTrailer is the trailer through which a JSON stream reports an
+// error which interrupted it.
+const StreamErrorTrailer = "X-Stream-Error"
+
+// jsonStreamer is a Response which writes the items produced by a function as
+// the elements of a JSON array.
+type jsonStreamer struct {
+	ctx  *RequestContext
+	next func() (interface{}, bool, error)
+}
+
+// StreamJSONFunc constructs a response which writes the items returned by
+// successive calls to next as a JSON array, serializing each with the rights of
+// the context. next returns false once there are no more items. Items are
+// pulled only as fast as they can be written, so next may, for instance, scan
+// sql.Rows directly.
+//
+// If next fails, the stream stops without closing the array, the error is
+// reported and a friendly message is sent in the StreamErrorTrailer trailer.
+func (ctx *RequestContext) StreamJSONFunc(next func() (interface{}, bool, error)) Response {
+	return &jsonStreamer{ctx: ctx, next: next}
+}
+
+func (s *jsonStreamer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Trailer", StreamErrorTrailer)
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	w.Write([]byte("["))
+	for i := 0; ; i++ {
+		item, ok, err := s.next()
+		if err == nil && ok {
+			var out interface{}
+			if out, err = s.ctx.safeSerialize(reflect.ValueOf(item)); err == nil {
+				if i > 0 {
+					w.Write([]byte(","))
+				}
+				err = enc.Encode(out)
+			}
+		}
+
+		if err != nil {
+			s.ctx.NotifyError(err, http.StatusInternalServerError)
+			w.Header().Set(StreamErrorTrailer, s.ctx.CustomErrorMessage(err, s.ctx.framework.DefaultErrorText))
+			return
+		}
+
+		if !ok {
+			break
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	w.Write([]byte("]"))
+}
+
+// Cancel is a no-op as the items are produced only while the response is
+// served.
+func (s *jsonStreamer) Cancel() {}
@@ -0,0 +1,58 @@
+package chopshop
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+type streamItem struct {
+	Name   string `json:"name"`
+	Secret string `json:"secret" readWrite:"admin"`
+}
+
+// sliceIterator returns a StreamJSONFunc iterator over items, which fails with
+// err once they are exhausted if err is not nil.
+func sliceIterator(items []interface{}, err error) func() (interface{}, bool, error) {
+	i := 0
+	return func() (interface{}, bool, error) {
+		if i == len(items) {
+			return nil, false, err
+		}
+		i++
+		return items[i-1], true, nil
+	}
+}
+
+func TestStreamJSONFunc(t *testing.T) {
+	items := []interface{}{streamItem{"a", "x"}, streamItem{"b", "y"}}
+
+	tests := []struct {
+		name    string
+		rights  []string
+		items   []interface{}
+		err     error
+		body    string
+		trailer string
+	}{
+		{"empty", nil, nil, nil, "[]", ""},
+		{"filtered", []string{"user"}, items, nil, `[{"name":"a"}` + "\n" + `,{"name":"b"}` + "\n" + `]`, ""},
+		{"admin", []string{"admin"}, items[:1], nil, `[{"name":"a","secret":"x"}` + "\n" + `]`, ""},
+		{"failed", nil, items[:1], errors.New("boom"), `[{"name":"a"}` + "\n", "An error occurred."},
+	}
+
+	for _, tt := range tests {
+		f, ctx, _ := newTestContext(t, tt.rights...)
+		f.DefaultErrorText = "An error occurred."
+
+		w := httptest.NewRecorder()
+		ctx.StreamJSONFunc(sliceIterator(tt.items, tt.err)).ServeHTTP(w, ctx.Request)
+		if w.Body.String() != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.name, w.Body.String(), tt.body)
+		}
+
+		if got := w.Result().Trailer.Get(StreamErrorTrailer); got != tt.trailer {
+			t.Errorf("%s: trailer %q, want %q", tt.name, got, tt.trailer)
+		}
+	}
+}
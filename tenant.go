@@ -0,0 +1,79 @@
+package chopshop
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ErrTenantNotResolved is returned by the tenant resolvers when the request
+// does not identify a tenant.
+var ErrTenantNotResolved = errors.New("tenant could not be resolved")
+
+// TenantResolver determines the tenant to which a request belongs.
+type TenantResolver func(ctx *RequestContext) (tenantID string, err error)
+
+// TenantMiddleware constructs a middleware which resolves the tenant of each
+// request and records it on the context (see RequestContext.TenantID). If
+// resolution fails, the response produced by Framework.TenantErrorResponse is
+// returned instead.
+func TenantMiddleware(resolve TenantResolver) Middleware {
+	return func(fn ContextHandlerFunc) ContextHandlerFunc {
+		return func(ctx *RequestContext) Response {
+			tenantID, err := resolve(ctx)
+			if err == nil && tenantID == "" {
+				err = ErrTenantNotResolved
+			}
+
+			if err != nil {
+				if fn := ctx.framework.TenantErrorResponse; fn != nil {
+					return fn(ctx, err)
+				}
+
+				return ctx.CustomErrorResponse(err, "Unknown tenant.", http.StatusNotFound)
+			}
+
+			ctx.tenantID = tenantID
+			return fn(ctx)
+		}
+	}
+}
+
+// SubdomainTenant constructs a TenantResolver which takes the tenant from the
+// leftmost label of a host beneath the given domain, e.g. "acme" from
+// "acme.example.com" for the domain "example.com".
+func SubdomainTenant(domain string) TenantResolver {
+	suffix := "." + strings.ToLower(strings.Trim(domain, "."))
+	return func(ctx *RequestContext) (string, error) {
+		host := ctx.Request.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+
+		host = strings.ToLower(host)
+		if !strings.HasSuffix(host, suffix) {
+			return "", ErrTenantNotResolved
+		}
+
+		labels := strings.Split(strings.TrimSuffix(host, suffix), ".")
+		return labels[len(labels)-1], nil
+	}
+}
+
+// HeaderTenant constructs a TenantResolver which takes the tenant from the
+// named request header.
+func HeaderTenant(header string) TenantResolver {
+	return func(ctx *RequestContext) (string, error) {
+		if tenantID := ctx.Request.Header.Get(header); tenantID != "" {
+			return tenantID, nil
+		}
+
+		return "", ErrTenantNotResolved
+	}
+}
+
+// TenantID returns the tenant resolved by TenantMiddleware, or the empty string.
+func (ctx *RequestContext) TenantID() string {
+	return ctx.tenantID
+}
@@ -0,0 +1,72 @@
+package chopshop
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSubdomainTenant(t *testing.T) {
+	tests := []struct {
+		host   string
+		tenant string
+		err    error
+	}{
+		{"acme.example.com", "acme", nil},
+		{"ACME.Example.com:8080", "acme", nil},
+		{"www.acme.example.com", "acme", nil},
+		{"example.com", "", ErrTenantNotResolved},
+		{"acme.example.org", "", ErrTenantNotResolved},
+		{"acmeexample.com", "", ErrTenantNotResolved},
+	}
+
+	for _, tt := range tests {
+		_, ctx, _ := newTestContext(t)
+		ctx.Request.Host = tt.host
+		tenant, err := SubdomainTenant(".example.com")(ctx)
+		if tenant != tt.tenant || err != tt.err {
+			t.Errorf("%s: got %q %v, want %q %v", tt.host, tenant, err, tt.tenant, tt.err)
+		}
+	}
+}
+
+func TestTenantMiddleware(t *testing.T) {
+	errCustom := errors.New("suspended")
+
+	tests := []struct {
+		name     string
+		resolver TenantResolver
+		custom   bool
+		status   int
+		tenant   string
+	}{
+		{"header", HeaderTenant("X-Tenant"), false, http.StatusOK, "acme"},
+		{"missing", HeaderTenant("X-Missing"), false, http.StatusNotFound, ""},
+		{"empty", func(*RequestContext) (string, error) { return "", nil }, false, http.StatusNotFound, ""},
+		{"custom", func(*RequestContext) (string, error) { return "", errCustom }, true, http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		f, ctx, _ := newTestContext(t)
+		ctx.Request.Header.Set("X-Tenant", "acme")
+		if tt.custom {
+			f.TenantErrorResponse = func(ctx *RequestContext, err error) Response {
+				if err != errCustom {
+					t.Errorf("%s: got %v", tt.name, err)
+				}
+				return BlankResponse(http.StatusForbidden)
+			}
+		}
+
+		var tenant string
+		w := httptest.NewRecorder()
+		TenantMiddleware(tt.resolver)(func(ctx *RequestContext) Response {
+			tenant = ctx.TenantID()
+			return BlankResponse(http.StatusOK)
+		})(ctx).ServeHTTP(w, ctx.Request)
+		if w.Code != tt.status || tenant != tt.tenant {
+			t.Errorf("%s: status %d tenant %q, want %d %q", tt.name, w.Code, tenant, tt.status, tt.tenant)
+		}
+	}
+}
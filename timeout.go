@@ -0,0 +1,284 @@
+package chopshop
+
+import (
+	stdcontext "context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Timeout sets a deadline for requests to the route. The request's context is
+// cancelled when the deadline passes so that downstream work may stop, and a
+// 504 error is returned in place of the handler's response. If the handler has
+// already begun writing its response, the timeout is only logged, and anything
+// it writes afterwards is discarded. A panic in the abandoned handler is
+// reported with NotifyError.
+func (r *Route) Timeout(d time.Duration) *Route {
+	r.timeout = d
+	return r
+}
+
+// serveWithTimeout serves fn as ServeContext does, abandoning it once d has
+// passed. sw is the writer underlying ctx.ResponseWriter. The handler runs on
+// a copy of ctx, which is only copied back if it completes in time, so that an
+// abandoned handler cannot race with the rest of the request.
+func (f *Framework) serveWithTimeout(ctx *RequestContext, fn ContextHandlerFunc, d time.Duration, sw *statusWriter) {
+	c, cancel := stdcontext.WithTimeout(ctx.Context(), d)
+	defer cancel()
+
+	tw := &timeoutWriter{w: sw}
+	handlerCtx := ctx.clone()
+	handlerCtx.Request = ctx.Request.WithContext(c)
+	handlerCtx.ResponseWriter = tw
+
+	done := make(chan interface{}, 1)
+	go func() {
+		defer func() { done <- recover() }()
+		f.ServeContext(handlerCtx, fn)
+	}()
+
+	select {
+	case p := <-done:
+		if p != nil {
+			panic(p)
+		}
+
+		ctx.restore(handlerCtx)
+	case <-c.Done():
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+
+		tw.timedOut = true
+		go func() { handlerCtx.notifyLatePanic(<-done) }()
+
+		if sw.status != 0 {
+			f.logf("chopshop: %s %s timed out after %s with its response partially written",
+				ctx.Request.Method, ctx.Request.URL.Path, d)
+			return
+		}
+
+		ErrorResponse("The request timed out.", http.StatusGatewayTimeout).ServeHTTP(sw, ctx.Request)
+	}
+}
+
+// clone returns a copy of the context, with its own copies of the session
+// state which a handler may modify, for running a handler which may be
+// abandoned.
+func (ctx *RequestContext) clone() *RequestContext {
+	c := *ctx
+	if ctx.token != nil {
+		token := *ctx.token
+		token.Header = copyClaimValue(ctx.token.Header).(map[string]interface{})
+		token.Claims = copyClaimValue(ctx.token.Claims).(map[string]interface{})
+		c.token = &token
+	}
+
+	if ctx.principal != nil {
+		c.principal = copyClaimValue(ctx.principal).(*Principal)
+	}
+
+	c.errors = append([]error(nil), ctx.errors...)
+	return &c
+}
+
+// restore adopts the state of a clone whose handler has completed, keeping the
+// request and writer of ctx.
+func (ctx *RequestContext) restore(clone *RequestContext) {
+	request, writer := ctx.Request, ctx.ResponseWriter
+	*ctx = *clone
+	ctx.Request, ctx.ResponseWriter = request, writer
+}
+
+// notifyLatePanic reports p, recovered from a handler which panicked after
+// being abandoned, if it is not nil.
+func (ctx *RequestContext) notifyLatePanic(p interface{}) {
+	if p != nil {
+		ctx.NotifyError(fmt.Errorf("panic after timeout: %v", p), http.StatusInternalServerError)
+	}
+}
+
+// copyClaimValue deeply copies the maps, slices and principals of a decoded
+// claim.
+func copyClaimValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = copyClaimValue(e)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, e := range v {
+			s[i] = copyClaimValue(e)
+		}
+		return s
+	case []string:
+		return append([]string(nil), v...)
+	case *Principal:
+		p := *v
+		p.Rights = append([]string(nil), v.Rights...)
+		return &p
+	}
+
+	return v
+}
+
+// timeoutWriter is an http.ResponseWriter which discards everything written to
+// it once its request has timed out.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	w        http.ResponseWriter
+	header   http.Header
+	copied   bool
+	timedOut bool
+}
+
+// Header returns a header private to the handler until it writes, so that it
+// cannot race with a timeout response, and the underlying writer's header
+// afterwards, so that trailers may still be set. Once the request has timed
+// out, changes to the header are discarded.
+func (tw *timeoutWriter) Header() http.Header {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.copied && !tw.timedOut {
+		return tw.w.Header()
+	}
+
+	if tw.header == nil {
+		tw.header = make(http.Header)
+	}
+
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if !tw.timedOut {
+		tw.copyHeader()
+		tw.w.WriteHeader(status)
+	}
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+
+	tw.copyHeader()
+	return tw.w.Write(p)
+}
+
+// Flush implements http.Flusher if the underlying writer does.
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if flusher, ok := tw.w.(http.Flusher); ok && !tw.timedOut {
+		flusher.Flush()
+	}
+}
+
+// copyHeader moves the handler's header to the underlying writer.
+func (tw *timeoutWriter) copyHeader() {
+	if tw.copied {
+		return
+	}
+
+	dst := tw.w.Header()
+	for k, v := range tw.header {
+		dst[k] = v
+	}
+
+	tw.header = nil
+	tw.copied = true
+}
+
+// TimeoutMiddleware constructs a middleware which gives the wrapped handler a
+// deadline of d. The request's context is cancelled at the deadline so that
+// downstream work may stop, and if the handler has not returned by then a 503
+// error is returned in its place. The response the handler eventually returns
+// is cancelled with its Cancel method, and a panic is reported. The handler
+// runs on a copy of ctx, which is only copied back if it returns in time, so
+// that an abandoned handler cannot change the session.
+func TimeoutMiddleware(d time.Duration) Middleware {
+	return func(fn ContextHandlerFunc) ContextHandlerFunc {
+		return func(ctx *RequestContext) Response {
+			c, cancel := stdcontext.WithTimeout(ctx.Context(), d)
+
+			tw := &timeoutWriter{w: ctx.ResponseWriter}
+			handlerCtx := ctx.clone()
+			handlerCtx.Request = ctx.Request.WithContext(c)
+			handlerCtx.ResponseWriter = tw
+
+			type result struct {
+				response  Response
+				recovered interface{}
+			}
+
+			done := make(chan result, 1)
+			go func() {
+				var res result
+				defer func() {
+					res.recovered = recover()
+					done <- res
+				}()
+				res.response = fn(handlerCtx)
+			}()
+
+			select {
+			case res := <-done:
+				if res.recovered != nil {
+					cancel()
+					panic(res.recovered)
+				}
+
+				tw.mu.Lock()
+				tw.copyHeader()
+				tw.mu.Unlock()
+
+				ctx.restore(handlerCtx)
+				return &cancelingResponse{Response: res.response, cancel: cancel}
+			case <-c.Done():
+				tw.mu.Lock()
+				tw.timedOut = true
+				tw.mu.Unlock()
+				cancel()
+
+				go func() {
+					res := <-done
+					if res.response != nil {
+						res.response.Cancel()
+					}
+					handlerCtx.notifyLatePanic(res.recovered)
+				}()
+
+				return ErrorResponse("The service is temporarily unavailable.", http.StatusServiceUnavailable)
+			}
+		}
+	}
+}
+
+// cancelingResponse is a Response which releases its request's context once
+// it has been served or cancelled.
+type cancelingResponse struct {
+	Response
+	cancel stdcontext.CancelFunc
+}
+
+func (c *cancelingResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer c.cancel()
+	c.Response.ServeHTTP(w, r)
+}
+
+func (c *cancelingResponse) Cancel() {
+	c.cancel()
+	c.Response.Cancel()
+}
@@ -0,0 +1,263 @@
+package chopshop
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// cancelRecorder is a Response which records whether it was cancelled.
+type cancelRecorder struct {
+	ResponseFunc
+	cancelled chan struct{}
+}
+
+func (c *cancelRecorder) Cancel() { close(c.cancelled) }
+
+func TestTimeoutMiddleware(t *testing.T) {
+	late := &cancelRecorder{
+		ResponseFunc: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("late")) },
+		cancelled:    make(chan struct{}),
+	}
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		handler ContextHandlerFunc
+		status  int
+		body    string
+	}{
+		{
+			name:    "fast",
+			timeout: time.Second,
+			handler: func(ctx *RequestContext) Response {
+				return ResponseFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.Context().Err() != nil {
+						t.Error("context cancelled before response served")
+					}
+					w.Write([]byte("ok"))
+				})
+			},
+			status: http.StatusOK,
+			body:   "ok",
+		},
+		{
+			name:    "slow",
+			timeout: 20 * time.Millisecond,
+			handler: func(ctx *RequestContext) Response {
+				<-ctx.Context().Done()
+				return late
+			},
+			status: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, test := range tests {
+		f, _ := NewFramework("app", "")
+		f.SessionSecret = []byte("secret")
+		f.Path("/").Middleware(TimeoutMiddleware(test.timeout)).Handler(test.handler)
+
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, w.Code, test.status)
+		}
+
+		if test.body != "" && w.Body.String() != test.body {
+			t.Errorf("%s: body %q, want %q", test.name, w.Body.String(), test.body)
+		}
+	}
+
+	select {
+	case <-late.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("late response not cancelled")
+	}
+}
+
+func TestTimeoutMiddlewareAbandonsSession(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.SessionSecret = []byte("secret")
+	reporter := newErrorRecorder()
+	f.ErrorReporter = reporter
+
+	released := make(chan struct{})
+	f.Path("/").Middleware(TimeoutMiddleware(10 * time.Millisecond)).Handler(func(ctx *RequestContext) Response {
+		<-ctx.Context().Done()
+		<-released
+		for i := 0; i < 100; i++ {
+			ctx.PutSession("late", i)
+			ctx.SetPrincipal("mallory", 2, []string{"admin"})
+		}
+		panic("late")
+	})
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	close(released)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+
+	if c, ok := responseCookies(w)[f.UserCookieName]; ok && c.Value != "" {
+		t.Error("abandoned handler's principal was written")
+	}
+
+	select {
+	case <-reporter.notify:
+	case <-time.After(time.Second):
+		t.Fatal("late panic not reported")
+	}
+}
+
+func TestTimeoutMiddlewareKeepsSession(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.SessionSecret = []byte("secret")
+	f.Path("/").Middleware(TimeoutMiddleware(time.Second)).Handler(func(ctx *RequestContext) Response {
+		ctx.SetPrincipal("bob", 1, []string{"a"})
+		ctx.PutSession("k", "v")
+		ctx.ResponseWriter.Header().Set("X-Handler", "1")
+		return BlankResponse(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusNoContent)
+	}
+
+	if w.Header().Get("X-Handler") != "1" {
+		t.Error("handler's header was lost")
+	}
+
+	if _, ok := responseCookies(w)[f.UserCookieName]; !ok {
+		t.Error("handler's principal was not written")
+	}
+}
+
+func TestRouteTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		handler ContextHandlerFunc
+		status  int
+		body    string
+	}{
+		{
+			name:    "fast",
+			timeout: time.Second,
+			handler: func(ctx *RequestContext) Response { return JSONResponse("ok") },
+			status:  http.StatusOK,
+			body:    "\"ok\"\n",
+		},
+		{
+			name:    "slow",
+			timeout: 20 * time.Millisecond,
+			handler: func(ctx *RequestContext) Response {
+				<-ctx.Context().Done()
+				return JSONResponse("late")
+			},
+			status: http.StatusGatewayTimeout,
+		},
+		{
+			name:    "partial",
+			timeout: 20 * time.Millisecond,
+			handler: func(ctx *RequestContext) Response {
+				ctx.ResponseWriter.WriteHeader(http.StatusOK)
+				ctx.ResponseWriter.Write([]byte("partial"))
+				<-ctx.Context().Done()
+				return BlankResponse(http.StatusOK)
+			},
+			status: http.StatusOK,
+			body:   "partial",
+		},
+		{
+			name:    "panic",
+			timeout: time.Second,
+			handler: func(ctx *RequestContext) Response { panic("boom") },
+			status:  http.StatusInternalServerError,
+		},
+	}
+
+	for _, test := range tests {
+		f, _ := NewFramework("app", "")
+		f.SessionSecret = []byte("secret")
+		f.Path("/").Timeout(test.timeout).Handler(test.handler)
+
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, w.Code, test.status)
+		}
+
+		if test.body != "" && w.Body.String() != test.body {
+			t.Errorf("%s: body %q, want %q", test.name, w.Body.String(), test.body)
+		}
+	}
+}
+
+func TestRouteTimeoutTrailer(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.SessionSecret = []byte("secret")
+	f.Path("/").Timeout(time.Second).Handler(func(ctx *RequestContext) Response {
+		sent := false
+		return ctx.StreamJSONFunc(func() (interface{}, bool, error) {
+			if sent {
+				return nil, false, errors.New("failed")
+			}
+			sent = true
+			return "item", true, nil
+		})
+	})
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Result().Trailer.Get(StreamErrorTrailer) == "" {
+		t.Errorf("trailer lost: %v", w.Result().Trailer)
+	}
+}
+
+func TestRouteTimeoutAbandonsHandler(t *testing.T) {
+	f, _ := NewFramework("app", "")
+	f.SessionSecret = []byte("secret")
+	reporter := newErrorRecorder()
+	f.ErrorReporter = reporter
+
+	var statuses []int
+	f.AfterResponse = func(ctx *RequestContext, status int) {
+		if ctx.principal != nil {
+			t.Error("abandoned handler's principal was kept")
+		}
+		statuses = append(statuses, status)
+	}
+
+	released := make(chan struct{})
+	f.Path("/").Timeout(10 * time.Millisecond).Handler(func(ctx *RequestContext) Response {
+		<-ctx.Context().Done()
+		<-released
+		for i := 0; i < 100; i++ {
+			ctx.SetPrincipal("mallory", 2, []string{"admin"})
+			ctx.Request = ctx.Request.WithContext(ctx.Context())
+		}
+		panic("late")
+	})
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	close(released)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusGatewayTimeout)
+	}
+
+	if len(statuses) != 1 || statuses[0] != http.StatusGatewayTimeout {
+		t.Errorf("AfterResponse statuses %v", statuses)
+	}
+
+	select {
+	case <-reporter.notify:
+	case <-time.After(time.Second):
+		t.Fatal("late panic not reported")
+	}
+}
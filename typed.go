@@ -0,0 +1,218 @@
+package chopshop
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+)
+
+// Validator is implemented by inputs of typed handlers which check their own
+// contents once decoded.
+type Validator interface {
+	Validate() ValidationErrors
+}
+
+var (
+	requestContextType = reflect.TypeOf((*RequestContext)(nil))
+	responseType       = reflect.TypeOf((*Response)(nil)).Elem()
+)
+
+// Typed mounts a handler of the form func(*RequestContext, T) Response, where
+// T is a struct or a pointer to one. Before the handler runs, T is populated
+// from the request body (via ReadBody, so writeRight tags are respected), then
+// from the query string and route variables named by the query and var tags
+// of its fields. If T implements Validator it is then validated. Any failure
+// is answered with a 400 response and the handler is not called. Typed panics
+// if fn does not have the required signature.
+func (r *Route) Typed(fn interface{}) {
+	fv := reflect.ValueOf(fn)
+	inputType := typedInputType(fv.Type())
+
+	request, response := r.Types()
+	if request == nil {
+		r.f.setRouteTypes(r.r, inputType, response)
+	}
+
+	r.Handler(func(ctx *RequestContext) Response {
+		input := reflect.New(indirectType(inputType))
+		if response := ctx.readTypedInput(input.Interface()); response != nil {
+			return response
+		}
+
+		if inputType.Kind() != reflect.Ptr {
+			input = input.Elem()
+		}
+
+		out := fv.Call([]reflect.Value{reflect.ValueOf(ctx), input})
+		response, _ := out[0].Interface().(Response)
+		return response
+	})
+}
+
+// typedInputType checks that ty is the type of a typed handler and returns the
+// type of its input.
+func typedInputType(ty reflect.Type) reflect.Type {
+	if ty.Kind() != reflect.Func || ty.NumIn() != 2 || ty.NumOut() != 1 ||
+		ty.In(0) != requestContextType || ty.Out(0) != responseType ||
+		indirectType(ty.In(1)).Kind() != reflect.Struct {
+		panic(fmt.Sprintf("chopshop: typed handler must be func(*RequestContext, T) Response, not %s", ty))
+	}
+
+	return ty.In(1)
+}
+
+func indirectType(ty reflect.Type) reflect.Type {
+	if ty.Kind() == reflect.Ptr {
+		return ty.Elem()
+	}
+
+	return ty
+}
+
+// readTypedInput populates v, a pointer to a struct, for a typed handler,
+// returning the response to send if the input is invalid.
+func (ctx *RequestContext) readTypedInput(v interface{}) Response {
+	if hasRequestBody(ctx.Request) {
+		if err := ctx.ReadBody(v); err != nil && err != io.EOF {
+			if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+				return ValidationErrorResponse(ValidationErrors{{
+					Field:   typeErr.Field,
+					Message: "cannot be " + typeErr.Value,
+				}})
+			}
+
+			return ctx.bodyErrorResponse(err)
+		}
+	}
+
+	if errs := ctx.bindRequestVars(reflect.ValueOf(v).Elem()); len(errs) > 0 {
+		return ValidationErrorResponse(errs)
+	}
+
+	if validator, ok := v.(Validator); ok {
+		if errs := validator.Validate(); len(errs) > 0 {
+			return ValidationErrorResponse(errs)
+		}
+	}
+
+	return nil
+}
+
+func hasRequestBody(r *http.Request) bool {
+	return r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0
+}
+
+// bindRequestVars sets the fields of dst tagged with query or var from the
+// query string and route variables respectively, provided that the current
+// context has the right to write them.
+func (ctx *RequestContext) bindRequestVars(dst reflect.Value) ValidationErrors {
+	var errs ValidationErrors
+
+	ty := dst.Type()
+	for i := 0; i < ty.NumField(); i++ {
+		field := ty.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if w := field.Tag.Get("writeRight"); w != "" && !ctx.HasRight(w) {
+			continue
+		}
+
+		var name string
+		var values []string
+		if name = field.Tag.Get("var"); name != "" {
+			if v := ctx.RouteVar(name); v != "" {
+				values = []string{v}
+			}
+		} else if name = field.Tag.Get("query"); name != "" {
+			values = ctx.QueryVars(name)
+		}
+
+		if len(values) == 0 {
+			continue
+		}
+
+		if err := setFromStrings(dst.Field(i), values); err != nil {
+			errs = append(errs, ValidationError{Field: name, Message: err.Error()})
+		}
+	}
+
+	return errs
+}
+
+// setFromStrings parses values into dst, which may be a slice to receive each
+// value or a scalar to receive the first.
+func setFromStrings(dst reflect.Value, values []string) error {
+	if dst.Kind() == reflect.Slice && dst.Type().Elem().Kind() != reflect.Uint8 {
+		slice := reflect.MakeSlice(dst.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setFromString(slice.Index(i), value); err != nil {
+				return err
+			}
+		}
+
+		dst.Set(slice)
+		return nil
+	}
+
+	return setFromString(dst, values[0])
+}
+
+func setFromString(dst reflect.Value, value string) error {
+	if dst.Kind() == reflect.Ptr {
+		ptr := reflect.New(dst.Type().Elem())
+		if err := setFromString(ptr.Elem(), value); err != nil {
+			return err
+		}
+
+		dst.Set(ptr)
+		return nil
+	}
+
+	if u, ok := dst.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		if err := u.UnmarshalText([]byte(value)); err != nil {
+			return errors.New("is not valid")
+		}
+
+		return nil
+	}
+
+	switch dst.Kind() {
+	case reflect.String:
+		dst.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("must be a boolean")
+		}
+		dst.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, dst.Type().Bits())
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		dst.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, dst.Type().Bits())
+		if err != nil {
+			return errors.New("must be a non-negative integer")
+		}
+		dst.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, dst.Type().Bits())
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		dst.SetFloat(n)
+	default:
+		return errors.New("cannot be set from a string")
+	}
+
+	return nil
+}
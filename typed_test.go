@@ -0,0 +1,117 @@
+package chopshop
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type typedInput struct {
+	Name  string   `json:"name"`
+	Role  string   `json:"role" writeRight:"admin"`
+	ID    uint64   `var:"id"`
+	Limit int      `query:"limit"`
+	Tags  []string `query:"tag"`
+}
+
+func (in typedInput) Validate() ValidationErrors {
+	if in.Name == "" {
+		return ValidationErrors{{Field: "name", Message: "is required"}}
+	}
+
+	return nil
+}
+
+func TestTyped(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		body     string
+		status   int
+		want     *typedInput
+		contains string
+	}{
+		{
+			name:   "bound",
+			url:    "/things/7?limit=5&tag=a&tag=b",
+			body:   `{"name":"n","role":"root"}`,
+			status: http.StatusNoContent,
+			want:   &typedInput{Name: "n", ID: 7, Limit: 5, Tags: []string{"a", "b"}},
+		},
+		{
+			name:     "invalid",
+			url:      "/things/7",
+			body:     `{"role":"x"}`,
+			status:   http.StatusBadRequest,
+			contains: "is required",
+		},
+		{
+			name:     "wrong type",
+			url:      "/things/7",
+			body:     `{"name":5}`,
+			status:   http.StatusBadRequest,
+			contains: "name",
+		},
+		{
+			name:     "bad query",
+			url:      "/things/7?limit=many",
+			body:     `{"name":"n"}`,
+			status:   http.StatusBadRequest,
+			contains: "limit",
+		},
+	}
+
+	for _, tt := range tests {
+		f, _ := NewFramework("test", "")
+		f.SessionSecret = []byte("secret")
+
+		var got *typedInput
+		f.Path("/things/{id}").Methods("POST").Typed(func(ctx *RequestContext, in typedInput) Response {
+			got = &in
+			return ctx.NoContent()
+		})
+
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest("POST", tt.url, strings.NewReader(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.status, w.Body)
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: handler given %+v, want %+v", tt.name, got, tt.want)
+		}
+
+		if !strings.Contains(w.Body.String(), tt.contains) {
+			t.Errorf("%s: body %q does not contain %q", tt.name, w.Body, tt.contains)
+		}
+	}
+}
+
+func TestTypedSignature(t *testing.T) {
+	tests := []struct {
+		name  string
+		fn    interface{}
+		valid bool
+	}{
+		{"value", func(*RequestContext, typedInput) Response { return nil }, true},
+		{"pointer", func(*RequestContext, *typedInput) Response { return nil }, true},
+		{"no context", func(typedInput) Response { return nil }, false},
+		{"not a struct", func(*RequestContext, string) Response { return nil }, false},
+		{"no response", func(*RequestContext, typedInput) {}, false},
+		{"not a function", typedInput{}, false},
+	}
+
+	for _, tt := range tests {
+		f, _ := NewFramework("test", "")
+		func() {
+			defer func() {
+				if p := recover(); (p == nil) != tt.valid {
+					t.Errorf("%s: recovered %v", tt.name, p)
+				}
+			}()
+			f.Path("/").Typed(tt.fn)
+		}()
+	}
+}
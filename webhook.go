@@ -0,0 +1,138 @@
+package chopshop
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrWebhookSignatureExpired is returned by VerifyWebhookSignature when a
+// timestamped signature is older than the tolerance of its scheme.
+var ErrWebhookSignatureExpired = errors.New("webhook signature expired")
+
+// SignatureEncoding is the text encoding of a webhook signature.
+type SignatureEncoding int
+
+// Supported signature encodings.
+const (
+	SignatureHex SignatureEncoding = iota
+	SignatureBase64
+)
+
+// SignatureScheme describes how a webhook provider signs its payloads with an
+// HMAC.
+type SignatureScheme struct {
+	// Hash constructs the hash underlying the HMAC. If nil, SHA-256 is used.
+	Hash func() hash.Hash
+
+	// Encoding is the encoding of the signature.
+	Encoding SignatureEncoding
+
+	// Prefix precedes the signature in the header, such as "sha256=".
+	Prefix string
+
+	// TimestampKey and SignatureKey, if set, are the keys of the timestamp
+	// and signatures in a header of comma separated key=value pairs, such as
+	// "t=1492774577,v1=5257a869...". The signed payload is then the timestamp,
+	// a period and the body.
+	TimestampKey string
+	SignatureKey string
+
+	// Tolerance is the maximum age of a timestamped signature. If zero, the
+	// age is not checked.
+	Tolerance time.Duration
+}
+
+// Signature schemes of common webhook providers.
+var (
+	GitHubSignatureScheme = SignatureScheme{
+		Encoding: SignatureHex,
+		Prefix:   "sha256=",
+	}
+
+	StripeSignatureScheme = SignatureScheme{
+		Encoding:     SignatureHex,
+		TimestampKey: "t",
+		SignatureKey: "v1",
+		Tolerance:    5 * time.Minute,
+	}
+)
+
+// VerifyWebhookSignature returns true if the named header carries a valid
+// signature of the request body under the given secret and scheme. The body
+// remains available to the handler.
+func (ctx *RequestContext) VerifyWebhookSignature(secret []byte, header string, scheme SignatureScheme) (bool, error) {
+	body, err := ctx.CaptureBody()
+	if err != nil {
+		return false, err
+	}
+
+	value := ctx.Request.Header.Get(header)
+	if value == "" {
+		return false, nil
+	}
+
+	payload := body
+	signatures := []string{strings.TrimPrefix(value, scheme.Prefix)}
+	if scheme.TimestampKey != "" {
+		var timestamp string
+		signatures = nil
+		for _, pair := range strings.Split(value, ",") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+
+			switch kv[0] {
+			case scheme.TimestampKey:
+				timestamp = kv[1]
+			case scheme.SignatureKey:
+				signatures = append(signatures, strings.TrimPrefix(kv[1], scheme.Prefix))
+			}
+		}
+
+		sec, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return false, nil
+		}
+
+		if scheme.Tolerance > 0 && ctx.framework.now().Sub(time.Unix(sec, 0)) > scheme.Tolerance {
+			return false, ErrWebhookSignatureExpired
+		}
+
+		payload = append([]byte(timestamp+"."), body...)
+	}
+
+	expected := scheme.sign(secret, payload)
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// sign returns the encoded signature of the payload.
+func (s SignatureScheme) sign(secret, payload []byte) string {
+	hashFn := s.Hash
+	if hashFn == nil {
+		hashFn = sha256.New
+	}
+
+	mac := hmac.New(hashFn, secret)
+	mac.Write(payload)
+	sum := mac.Sum(nil)
+
+	if s.Encoding == SignatureBase64 {
+		return base64.StdEncoding.EncodeToString(sum)
+	}
+
+	return hex.EncodeToString(sum)
+}
@@ -0,0 +1,70 @@
+package chopshop
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	secret := []byte("whsec")
+	body := `{"ok":true}`
+	stripeSig := StripeSignatureScheme.sign(secret, []byte("990."+body))
+	base64Scheme := SignatureScheme{Encoding: SignatureBase64}
+	sha1Scheme := SignatureScheme{Hash: sha1.New, Prefix: "sha1="}
+
+	tests := []struct {
+		name   string
+		scheme SignatureScheme
+		header string
+		value  string
+		body   string
+		age    time.Duration
+		ok     bool
+		err    error
+	}{
+		{"github", GitHubSignatureScheme, "X-Hub-Signature-256",
+			"sha256=" + GitHubSignatureScheme.sign(secret, []byte(body)), body, 0, true, nil},
+		{"github tampered", GitHubSignatureScheme, "X-Hub-Signature-256",
+			"sha256=" + GitHubSignatureScheme.sign(secret, []byte(body)), body + " ", 0, false, nil},
+		{"missing header", GitHubSignatureScheme, "X-Hub-Signature-256", "", body, 0, false, nil},
+		{"stripe", StripeSignatureScheme, "Stripe-Signature",
+			fmt.Sprintf("t=990,v1=bad,v1=%s", stripeSig), body, 0, true, nil},
+		{"stripe wrong timestamp", StripeSignatureScheme, "Stripe-Signature",
+			fmt.Sprintf("t=991,v1=%s", stripeSig), body, 0, false, nil},
+		{"stripe no timestamp", StripeSignatureScheme, "Stripe-Signature",
+			fmt.Sprintf("v1=%s", stripeSig), body, 0, false, nil},
+		{"stripe expired", StripeSignatureScheme, "Stripe-Signature",
+			fmt.Sprintf("t=990,v1=%s", stripeSig), body, time.Hour, false, ErrWebhookSignatureExpired},
+		{"base64", base64Scheme, "X-Sig", base64Scheme.sign(secret, []byte(body)), body, 0, true, nil},
+		{"sha1", sha1Scheme, "X-Sig", "sha1=" + sha1Scheme.sign(secret, []byte(body)), body, 0, true, nil},
+	}
+
+	for _, tt := range tests {
+		f, ctx, _ := newTestContext(t)
+		f.Clock = NewTestClock(time.Unix(1000, 0).Add(tt.age)).Now
+		ctx.Request, _ = http.NewRequest("POST", "/", strings.NewReader(tt.body))
+		ctx.Request.Header.Set(tt.header, tt.value)
+
+		ok, err := ctx.VerifyWebhookSignature(secret, tt.header, tt.scheme)
+		if ok != tt.ok || err != tt.err {
+			t.Errorf("%s: got %v, %v, want %v, %v", tt.name, ok, err, tt.ok, tt.err)
+		}
+
+		if b, _ := ioutil.ReadAll(ctx.Request.Body); string(b) != tt.body {
+			t.Errorf("%s: body %q not preserved", tt.name, b)
+		}
+	}
+}
+
+func TestGitHubSignatureScheme(t *testing.T) {
+	// the example from GitHub's documentation on validating webhook deliveries
+	sig := GitHubSignatureScheme.sign([]byte("It's a Secret to Everybody"), []byte("Hello, World!"))
+	if want := "757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"; sig != want {
+		t.Errorf("signature %s, want %s", sig, want)
+	}
+}